@@ -0,0 +1,59 @@
+// Command auditverify checks the hash chain of the configured audit log and
+// exits non-zero if any event was altered, removed or reordered.
+//
+//	go run ./cmd/auditverify -batch 1000
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/Zaman-R/otp-validator/cmd/config"
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func main() {
+	batch := flag.Int("batch", 1000, "number of events read per batch")
+	file := flag.String("file", "", "JSONL audit file to verify (default: the configured sink)")
+	flag.Parse()
+
+	var sink otp.AuditSink
+	path := *file
+	if path == "" {
+		config.LoadConfig()
+		switch config.ConfigOTP.AuditSink {
+		case "sql":
+			config.ConnectDB()
+			sink = repository.NewAuditRepository(config.GetDB().GetDB())
+		case "jsonl":
+			path = config.ConfigOTP.AuditFile
+		default:
+			log.Fatal("No audit sink configured; set OTP_AUDIT_SINK or pass -file")
+		}
+	}
+	if path != "" {
+		jsonl, err := repository.NewJSONLAuditSink(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer jsonl.Close()
+		sink = jsonl
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := otp.VerifyAuditChain(ctx, sink, *batch)
+	if errors.Is(err, otp.ErrAuditTampered) {
+		log.Fatalf("Audit log is NOT intact after %d events: %v", result.Events, err)
+	}
+	if err != nil {
+		log.Fatalf("Verification failed after %d events: %v", result.Events, err)
+	}
+	log.Printf("Verified %d events, last hash %s", result.Events, result.LastHash)
+}
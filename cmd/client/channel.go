@@ -0,0 +1,95 @@
+package client
+
+import "context"
+
+// Message is a rendered OTP message for a single recipient.
+type Message struct {
+	// Recipient is a mobile number or email address, depending on the
+	// channel.
+	Recipient string
+	// Subject is set for email channels.
+	Subject string
+	Text    string
+	// HTML is an optional alternative to Text for channels that support it.
+	HTML   string
+	Locale string
+	// Metadata carries references such as the OTP reference and purpose,
+	// for providers that can attach them to the message.
+	Metadata map[string]string
+}
+
+// Receipt acknowledges a message accepted by a provider.
+type Receipt struct {
+	Provider string
+	// MessageID is the provider's ID for the message, if it returns one.
+	MessageID string
+}
+
+// Channel delivers messages through one medium, such as SMS or email.
+type Channel interface {
+	Send(ctx context.Context, msg Message) (Receipt, error)
+}
+
+// SMSChannel adapts provider to Channel, sending the message's text.
+// Providers that implement Channel themselves are returned as is.
+func SMSChannel(provider SMSProvider) Channel {
+	if channel, ok := provider.(Channel); ok {
+		return channel
+	}
+	return smsChannel{provider}
+}
+
+type smsChannel struct {
+	provider SMSProvider
+}
+
+func (c smsChannel) Name() string {
+	return ProviderName(c.provider)
+}
+
+func (c smsChannel) Send(ctx context.Context, msg Message) (Receipt, error) {
+	return Receipt{Provider: c.Name()}, c.provider.SendSMS(ctx, msg.Recipient, msg.Text)
+}
+
+// VoiceChannel adapts provider to Channel, reading out the message's text.
+// Providers that implement Channel themselves are returned as is.
+func VoiceChannel(provider VoiceProvider) Channel {
+	if channel, ok := provider.(Channel); ok {
+		return channel
+	}
+	return voiceChannel{provider}
+}
+
+type voiceChannel struct {
+	provider VoiceProvider
+}
+
+func (c voiceChannel) Name() string {
+	return ProviderName(c.provider)
+}
+
+func (c voiceChannel) Send(ctx context.Context, msg Message) (Receipt, error) {
+	return Receipt{Provider: c.Name()}, c.provider.SendVoice(ctx, msg.Recipient, msg.Text)
+}
+
+// EmailChannel adapts provider to Channel. EmailProvider has no subject or
+// HTML part, so only the message's text is sent, unless the provider
+// implements Channel itself, like SMTPProvider; then it is returned as is.
+func EmailChannel(provider EmailProvider) Channel {
+	if channel, ok := provider.(Channel); ok {
+		return channel
+	}
+	return emailChannel{provider}
+}
+
+type emailChannel struct {
+	provider EmailProvider
+}
+
+func (c emailChannel) Name() string {
+	return ProviderName(c.provider)
+}
+
+func (c emailChannel) Send(ctx context.Context, msg Message) (Receipt, error) {
+	return Receipt{Provider: c.Name()}, c.provider.SendEmail(ctx, msg.Recipient, msg.Text)
+}
@@ -1,21 +1,42 @@
 package client
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
 )
 
 type EmailProvider interface {
-	//SendEmail(to string, subject string, body string) error
-	SendEmail(email, otp string) error
+	SendEmail(ctx context.Context, email, message string) error
 }
 
-type CustomEmailProvider struct{}
+// CustomEmailProvider is a placeholder that logs instead of sending. Logger
+// defaults to utils.DefaultLogger; recipients are masked either way.
+type CustomEmailProvider struct {
+	Logger *slog.Logger
+}
 
 func NewCustomEmailProvider() *CustomEmailProvider {
 	return &CustomEmailProvider{}
 }
-func (c *CustomEmailProvider) SendEmail(email, otp string) error {
-	fmt.Printf("Sending Email to %s: Your OTP is %s\n", email, otp)
+
+func (c *CustomEmailProvider) logger() *slog.Logger {
+	if c.Logger != nil {
+		return utils.Redacting(c.Logger)
+	}
+	return utils.DefaultLogger()
+}
+
+func (c *CustomEmailProvider) Name() string {
+	return "custom"
+}
+
+func (c *CustomEmailProvider) SendEmail(ctx context.Context, email, message string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.logger().InfoContext(ctx, "sending email", "provider", c.Name(), "channel", "EMAIL", "recipient", email)
 	return errors.New("not Implemented")
 }
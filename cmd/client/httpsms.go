@@ -0,0 +1,286 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+)
+
+const (
+	DefaultHTTPSMSBaseURL      = "https://api.twilio.com"
+	DefaultHTTPSMSTimeout      = 10 * time.Second
+	DefaultHTTPSMSMaxRetries   = 2
+	DefaultHTTPSMSRetryBackoff = 500 * time.Millisecond
+	DefaultHTTPSMSMaxRetryWait = 30 * time.Second
+)
+
+var (
+	// ErrSMSInvalidRecipient is matched by errors for numbers the gateway
+	// cannot send to, e.g. malformed or landline numbers.
+	ErrSMSInvalidRecipient = errors.New("sms: invalid recipient")
+	// ErrSMSOptedOut is matched by errors for recipients who unsubscribed.
+	ErrSMSOptedOut = errors.New("sms: recipient opted out")
+	// ErrSMSAuth is matched by errors for rejected credentials.
+	ErrSMSAuth = errors.New("sms: authentication failed")
+	// ErrSMSRateLimited is matched by errors for throttled requests.
+	ErrSMSRateLimited = errors.New("sms: rate limited")
+	// ErrSMSUnavailable is matched by errors for gateway failures (5xx).
+	ErrSMSUnavailable = errors.New("sms: gateway unavailable")
+)
+
+// SMSError is an error response from an SMS gateway. Use errors.Is with the
+// ErrSMS* values to classify it.
+type SMSError struct {
+	StatusCode int
+	// Code is the gateway's error code, e.g. Twilio's 21211; 0 if none.
+	Code     int
+	Message  string
+	MoreInfo string
+	// RetryAfter is the wait the gateway asked for with Retry-After.
+	RetryAfter time.Duration
+}
+
+func (e *SMSError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != 0 {
+		return fmt.Sprintf("sms: gateway error %d (HTTP %d): %s", e.Code, e.StatusCode, msg)
+	}
+	return fmt.Sprintf("sms: gateway error (HTTP %d): %s", e.StatusCode, msg)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *SMSError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (e *SMSError) Is(target error) bool {
+	switch target {
+	case ErrSMSInvalidRecipient:
+		return e.Code == 21211 || e.Code == 21614 || e.Code == 21217
+	case ErrSMSOptedOut:
+		return e.Code == 21610
+	case ErrSMSAuth:
+		return e.StatusCode == http.StatusUnauthorized || e.Code == 20003
+	case ErrSMSRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests || e.Code == 20429 || e.Code == 14107
+	case ErrSMSUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// HTTPSMSConfig configures an HTTPSMSProvider. AccountSID, AuthToken and
+// one of From or MessagingServiceSID are required.
+type HTTPSMSConfig struct {
+	// BaseURL is the gateway's API root; it defaults to Twilio's.
+	BaseURL    string
+	AccountSID string
+	AuthToken  string
+	// From is the sender number or ID. MessagingServiceSID selects a
+	// messaging service instead and takes precedence.
+	From                string
+	MessagingServiceSID string
+	// StatusCallback, when set, is the URL the gateway reports delivery
+	// status to.
+	StatusCallback string
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// MaxRetries is how often a request answered with 429 or 5xx is
+	// retried; a negative value disables retries. Retries wait for
+	// Retry-After, or back off exponentially from RetryBackoff, but never
+	// longer than MaxRetryWait.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	MaxRetryWait time.Duration
+	// HTTPClient defaults to a client with Timeout.
+	HTTPClient *http.Client
+	// Logger defaults to utils.DefaultLogger; recipients are masked
+	// either way.
+	Logger *slog.Logger
+}
+
+// HTTPSMSProvider sends SMS through a gateway speaking Twilio's REST API:
+// a form POST to /2010-04-01/Accounts/{AccountSID}/Messages.json with
+// basic auth. It implements SMSProvider and Channel; as a Channel the
+// receipt carries the message SID.
+type HTTPSMSProvider struct {
+	cfg      HTTPSMSConfig
+	endpoint string
+	client   *http.Client
+}
+
+var (
+	_ SMSProvider = (*HTTPSMSProvider)(nil)
+	_ Channel     = (*HTTPSMSProvider)(nil)
+)
+
+// NewHTTPSMSProvider validates cfg and returns a provider.
+func NewHTTPSMSProvider(cfg HTTPSMSConfig) (*HTTPSMSProvider, error) {
+	if cfg.AccountSID == "" || cfg.AuthToken == "" {
+		return nil, errors.New("sms: account SID and auth token are required")
+	}
+	if cfg.From == "" && cfg.MessagingServiceSID == "" {
+		return nil, errors.New("sms: a from number or messaging service SID is required")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultHTTPSMSBaseURL
+	}
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("sms: invalid base URL %q", cfg.BaseURL)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultHTTPSMSTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultHTTPSMSMaxRetries
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = DefaultHTTPSMSRetryBackoff
+	}
+	if cfg.MaxRetryWait == 0 {
+		cfg.MaxRetryWait = DefaultHTTPSMSMaxRetryWait
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &HTTPSMSProvider{
+		cfg:      cfg,
+		endpoint: base.JoinPath("2010-04-01", "Accounts", cfg.AccountSID, "Messages.json").String(),
+		client:   client,
+	}, nil
+}
+
+func (p *HTTPSMSProvider) Name() string {
+	return "http_sms"
+}
+
+func (p *HTTPSMSProvider) logger() *slog.Logger {
+	if p.cfg.Logger != nil {
+		return utils.Redacting(p.cfg.Logger)
+	}
+	return utils.DefaultLogger()
+}
+
+func (p *HTTPSMSProvider) SendSMS(ctx context.Context, phone, message string) error {
+	_, err := p.Send(ctx, Message{Recipient: phone, Text: message})
+	return err
+}
+
+// Send posts msg's text to the gateway, retrying throttled and failed
+// requests, and returns the message SID as the receipt's MessageID.
+func (p *HTTPSMSProvider) Send(ctx context.Context, msg Message) (Receipt, error) {
+	receipt := Receipt{Provider: p.Name()}
+	form := url.Values{"To": {msg.Recipient}, "Body": {msg.Text}}
+	if p.cfg.MessagingServiceSID != "" {
+		form.Set("MessagingServiceSid", p.cfg.MessagingServiceSID)
+	} else {
+		form.Set("From", p.cfg.From)
+	}
+	if p.cfg.StatusCallback != "" {
+		form.Set("StatusCallback", p.cfg.StatusCallback)
+	}
+	body := form.Encode()
+
+	for attempt := 0; ; attempt++ {
+		sid, err := p.post(ctx, body)
+		if err == nil {
+			receipt.MessageID = sid
+			return receipt, nil
+		}
+		var smsErr *SMSError
+		if !errors.As(err, &smsErr) || !smsErr.Temporary() || attempt >= p.cfg.MaxRetries {
+			return receipt, err
+		}
+
+		wait := smsErr.RetryAfter
+		if wait <= 0 {
+			wait = p.cfg.RetryBackoff << attempt
+		}
+		wait = min(wait, p.cfg.MaxRetryWait)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return receipt, err
+		}
+		p.logger().WarnContext(ctx, "retrying SMS", "provider", p.Name(), "channel", "SMS",
+			"recipient", msg.Recipient, "attempt", attempt+1, "wait", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return receipt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// post makes one request and returns the message SID.
+func (p *HTTPSMSProvider) post(ctx context.Context, body string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(p.cfg.AccountSID, p.cfg.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("sms: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("sms: read response: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var message struct {
+			SID string `json:"sid"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			return "", fmt.Errorf("sms: decode response: %w", err)
+		}
+		return message.SID, nil
+	}
+
+	smsErr := &SMSError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	var apiErr struct {
+		Code     int    `json:"code"`
+		Message  string `json:"message"`
+		MoreInfo string `json:"more_info"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil {
+		smsErr.Code, smsErr.Message, smsErr.MoreInfo = apiErr.Code, apiErr.Message, apiErr.MoreInfo
+	}
+	return "", smsErr
+}
+
+// parseRetryAfter parses a Retry-After value in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
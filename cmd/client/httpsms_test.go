@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smsGateway is an httptest server that answers the nth request with
+// responses[n], or with the last response once they run out.
+type smsGateway struct {
+	*httptest.Server
+	responses []smsResponse
+	mu        sync.Mutex
+	requests  []*http.Request
+	forms     []url.Values
+}
+
+type smsResponse struct {
+	status     int
+	body       string
+	retryAfter string
+}
+
+func newSMSGateway(t *testing.T, responses ...smsResponse) *smsGateway {
+	t.Helper()
+	g := &smsGateway{responses: responses}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *smsGateway) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	g.mu.Lock()
+	n := len(g.requests)
+	g.requests = append(g.requests, r)
+	g.forms = append(g.forms, r.PostForm)
+	g.mu.Unlock()
+
+	resp := g.responses[min(n, len(g.responses)-1)]
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	io.WriteString(w, resp.body)
+}
+
+func (g *smsGateway) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.requests)
+}
+
+var (
+	smsCreated     = smsResponse{status: http.StatusCreated, body: `{"sid":"SM123"}`}
+	smsUnavailable = smsResponse{status: http.StatusServiceUnavailable, body: `{"message":"try later"}`}
+)
+
+func newHTTPSMSProvider(t *testing.T, gateway *smsGateway, cfg HTTPSMSConfig) *HTTPSMSProvider {
+	t.Helper()
+	cfg.BaseURL = gateway.URL
+	cfg.AccountSID = "AC123"
+	cfg.AuthToken = "token"
+	if cfg.From == "" && cfg.MessagingServiceSID == "" {
+		cfg.From = "+15550000000"
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	provider, err := NewHTTPSMSProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestHTTPSMSProviderSend(t *testing.T) {
+	gateway := newSMSGateway(t, smsCreated)
+	provider := newHTTPSMSProvider(t, gateway, HTTPSMSConfig{StatusCallback: "https://acme.example/sms/status"})
+
+	receipt, err := provider.Send(context.Background(), Message{Recipient: "+15551234567", Text: "Your code is 123456"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if receipt.Provider != "http_sms" || receipt.MessageID != "SM123" {
+		t.Errorf("receipt = %+v", receipt)
+	}
+
+	req := gateway.requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+		t.Errorf("request = %s %s", req.Method, req.URL.Path)
+	}
+	if user, password, ok := req.BasicAuth(); !ok || user != "AC123" || password != "token" {
+		t.Errorf("basic auth = %q/%q, %v", user, password, ok)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := url.Values{
+		"To":             {"+15551234567"},
+		"Body":           {"Your code is 123456"},
+		"From":           {"+15550000000"},
+		"StatusCallback": {"https://acme.example/sms/status"},
+	}
+	if got := gateway.forms[0]; got.Encode() != want.Encode() {
+		t.Errorf("form = %v, want %v", got, want)
+	}
+}
+
+func TestHTTPSMSProviderMessagingService(t *testing.T) {
+	gateway := newSMSGateway(t, smsCreated)
+	provider := newHTTPSMSProvider(t, gateway, HTTPSMSConfig{From: "+15550000000", MessagingServiceSID: "MG123"})
+
+	if err := provider.SendSMS(context.Background(), "+15551234567", "code"); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	form := gateway.forms[0]
+	if form.Get("MessagingServiceSid") != "MG123" || form.Has("From") {
+		t.Errorf("form = %v, want MessagingServiceSid without From", form)
+	}
+}
+
+func TestHTTPSMSProviderRetries(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry smsResponse
+	}{
+		{"429", smsResponse{status: http.StatusTooManyRequests, body: `{"code":20429}`}},
+		{"500", smsResponse{status: http.StatusInternalServerError}},
+		{"503", smsUnavailable},
+		{"Retry-After seconds", smsResponse{status: http.StatusTooManyRequests, retryAfter: "60"}},
+		{"Retry-After date", smsResponse{status: http.StatusServiceUnavailable, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newSMSGateway(t, tt.retry, tt.retry, smsCreated)
+			// MaxRetryWait caps the Retry-After waits.
+			provider := newHTTPSMSProvider(t, gateway, HTTPSMSConfig{MaxRetries: 2, MaxRetryWait: 10 * time.Millisecond})
+
+			receipt, err := provider.Send(context.Background(), Message{Recipient: "+15551234567", Text: "code"})
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if receipt.MessageID != "SM123" {
+				t.Errorf("MessageID = %q", receipt.MessageID)
+			}
+			if got := gateway.count(); got != 3 {
+				t.Errorf("gateway received %d requests, want 3", got)
+			}
+		})
+	}
+}
+
+func TestHTTPSMSProviderGivesUp(t *testing.T) {
+	gateway := newSMSGateway(t, smsUnavailable)
+	provider := newHTTPSMSProvider(t, gateway, HTTPSMSConfig{MaxRetries: 2})
+
+	_, err := provider.Send(context.Background(), Message{Recipient: "+15551234567", Text: "code"})
+	if !errors.Is(err, ErrSMSUnavailable) {
+		t.Errorf("Send error = %v, want ErrSMSUnavailable", err)
+	}
+	if got := gateway.count(); got != 3 {
+		t.Errorf("gateway received %d requests, want 3", got)
+	}
+}
+
+func TestHTTPSMSProviderNoRetryOn4xx(t *testing.T) {
+	gateway := newSMSGateway(t, smsResponse{
+		status: http.StatusBadRequest,
+		body:   `{"code":21211,"message":"Invalid 'To' Phone Number","more_info":"https://www.twilio.com/docs/errors/21211"}`,
+	})
+	provider := newHTTPSMSProvider(t, gateway, HTTPSMSConfig{MaxRetries: 2})
+
+	_, err := provider.Send(context.Background(), Message{Recipient: "+1555", Text: "code"})
+	var smsErr *SMSError
+	if !errors.As(err, &smsErr) {
+		t.Fatalf("Send error = %v, want an SMSError", err)
+	}
+	if smsErr.StatusCode != http.StatusBadRequest || smsErr.Code != 21211 ||
+		smsErr.Message != "Invalid 'To' Phone Number" || smsErr.MoreInfo == "" {
+		t.Errorf("SMSError = %+v", smsErr)
+	}
+	if got := gateway.count(); got != 1 {
+		t.Errorf("gateway received %d requests, want 1", got)
+	}
+}
+
+// TestHTTPSMSProviderDeadline covers waits that would outlast the context:
+// Send returns the gateway's error straight away instead of sleeping into
+// the deadline.
+func TestHTTPSMSProviderDeadline(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry smsResponse
+		cfg   HTTPSMSConfig
+		wait  time.Duration
+	}{
+		{"backoff", smsUnavailable, HTTPSMSConfig{RetryBackoff: time.Hour, MaxRetryWait: time.Hour}, 0},
+		{"Retry-After seconds", smsResponse{status: http.StatusTooManyRequests, retryAfter: "60"}, HTTPSMSConfig{}, time.Minute},
+		{"Retry-After date", smsResponse{status: http.StatusTooManyRequests, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, HTTPSMSConfig{}, 59 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newSMSGateway(t, tt.retry, smsCreated)
+			provider := newHTTPSMSProvider(t, gateway, tt.cfg)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			_, err := provider.Send(ctx, Message{Recipient: "+15551234567", Text: "code"})
+			var smsErr *SMSError
+			if !errors.As(err, &smsErr) || !smsErr.Temporary() {
+				t.Fatalf("Send error = %v, want the gateway's temporary error", err)
+			}
+			if smsErr.RetryAfter < tt.wait || smsErr.RetryAfter > tt.wait+time.Minute {
+				t.Errorf("RetryAfter = %v, want about %v", smsErr.RetryAfter, tt.wait)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Send took %v", elapsed)
+			}
+			if got := gateway.count(); got != 1 {
+				t.Errorf("gateway received %d requests, want 1", got)
+			}
+		})
+	}
+}
+
+func TestSMSErrorIs(t *testing.T) {
+	sentinels := []error{ErrSMSInvalidRecipient, ErrSMSOptedOut, ErrSMSAuth, ErrSMSRateLimited, ErrSMSUnavailable}
+	tests := []struct {
+		err  *SMSError
+		want error
+	}{
+		{&SMSError{StatusCode: http.StatusBadRequest, Code: 21211}, ErrSMSInvalidRecipient},
+		{&SMSError{StatusCode: http.StatusBadRequest, Code: 21610}, ErrSMSOptedOut},
+		{&SMSError{StatusCode: http.StatusUnauthorized, Code: 20003}, ErrSMSAuth},
+		{&SMSError{StatusCode: http.StatusTooManyRequests, Code: 20429}, ErrSMSRateLimited},
+		{&SMSError{StatusCode: http.StatusServiceUnavailable}, ErrSMSUnavailable},
+		{&SMSError{StatusCode: http.StatusBadRequest, Code: 21602}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			wrapped := errors.Join(errors.New("delivery failed"), tt.err)
+			for _, sentinel := range sentinels {
+				if got := errors.Is(wrapped, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %v", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about 1h", date, got)
+	}
+	for _, value := range []string{"", "-5", "soon", "Mon, 01 Jan 2001 00:00:00 GMT"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", value, got)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package client
+
+import "fmt"
+
+// Named is implemented by providers that report a name for metrics and
+// logs.
+type Named interface {
+	Name() string
+}
+
+// ProviderName returns the name of provider: its Name if it implements
+// Named, otherwise its type.
+func ProviderName(provider interface{}) string {
+	if named, ok := provider.(Named); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", provider)
+}
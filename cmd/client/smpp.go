@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Zaman-R/otp-validator/cmd/smpp"
+)
+
+// SMPPConfig configures an SMPPProvider.
+type SMPPConfig struct {
+	smpp.Options
+	// Source is the sender address: a number, "+" and a number in
+	// international format, or an alphanumeric ID.
+	Source string
+	// RegisteredDelivery requests delivery receipts, which are passed to
+	// Options.OnReceipt.
+	RegisteredDelivery bool
+}
+
+// SMPPProvider sends SMS over an SMPP transceiver bind. It implements
+// SMSProvider and Channel; as a Channel the receipt carries the SMSC's
+// message IDs, comma-separated for messages sent in several parts.
+type SMPPProvider struct {
+	client             *smpp.Client
+	source             string
+	registeredDelivery bool
+}
+
+var (
+	_ SMSProvider = (*SMPPProvider)(nil)
+	_ Channel     = (*SMPPProvider)(nil)
+)
+
+// NewSMPPProvider returns a provider and starts binding in the background.
+// Close it to unbind.
+func NewSMPPProvider(cfg SMPPConfig) (*SMPPProvider, error) {
+	if cfg.Source == "" {
+		return nil, errors.New("smpp: source address is required")
+	}
+	client, err := smpp.NewClient(cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &SMPPProvider{client: client, source: cfg.Source, registeredDelivery: cfg.RegisteredDelivery}, nil
+}
+
+func (p *SMPPProvider) Name() string {
+	return "smpp"
+}
+
+func (p *SMPPProvider) SendSMS(ctx context.Context, phone, message string) error {
+	_, err := p.Send(ctx, Message{Recipient: phone, Text: message})
+	return err
+}
+
+func (p *SMPPProvider) Send(ctx context.Context, msg Message) (Receipt, error) {
+	ids, err := p.client.Submit(ctx, smpp.Message{
+		Source:             p.source,
+		Destination:        msg.Recipient,
+		Text:               msg.Text,
+		RegisteredDelivery: p.registeredDelivery,
+	})
+	return Receipt{Provider: p.Name(), MessageID: strings.Join(ids, ",")}, err
+}
+
+// Close unbinds from the SMSC.
+func (p *SMPPProvider) Close(ctx context.Context) error {
+	return p.client.Close(ctx)
+}
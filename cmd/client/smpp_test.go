@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/smpp"
+	"github.com/Zaman-R/otp-validator/cmd/smpp/smpptest"
+)
+
+func newSMPPProvider(t *testing.T, server *smpptest.Server) *SMPPProvider {
+	t.Helper()
+	provider, err := NewSMPPProvider(SMPPConfig{
+		Options: smpp.Options{
+			Addr:     server.Addr,
+			SystemID: "otp",
+			Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+		Source: "Acme",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.Close(context.Background()) })
+	return provider
+}
+
+func TestSMPPProviderSend(t *testing.T) {
+	server, err := smpptest.NewServer(smpptest.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	provider := newSMPPProvider(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receipt, err := provider.Send(ctx, Message{Recipient: "+15551234567", Text: strings.Repeat("code ", 40)})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if receipt.Provider != "smpp" || len(strings.Split(receipt.MessageID, ",")) != 2 {
+		t.Errorf("receipt = %+v, want two comma-separated IDs from smpp", receipt)
+	}
+	submits := server.Submits()
+	if len(submits) != 2 || submits[0].Source != "Acme" || submits[0].Destination != "15551234567" {
+		t.Errorf("server received %+v", submits)
+	}
+
+	if err := provider.SendSMS(ctx, "+15551234567", "Your code is 123456"); err != nil {
+		t.Errorf("SendSMS: %v", err)
+	}
+}
+
+func TestNewSMPPProviderRequiresSource(t *testing.T) {
+	if _, err := NewSMPPProvider(SMPPConfig{Options: smpp.Options{Addr: "127.0.0.1:2775", SystemID: "otp"}}); err == nil {
+		t.Error("NewSMPPProvider succeeded without a source address")
+	}
+}
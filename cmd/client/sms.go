@@ -1,21 +1,42 @@
 package client
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
 )
 
 type SMSProvider interface {
-	//SendSMS(phone string, message string) error
-	SendSMS(phone, otp string) error
+	SendSMS(ctx context.Context, phone, message string) error
 }
 
-type CustomSMSProvider struct{}
+// CustomSMSProvider is a placeholder that logs instead of sending. Logger
+// defaults to utils.DefaultLogger; recipients are masked either way.
+type CustomSMSProvider struct {
+	Logger *slog.Logger
+}
 
 func NewCustomSMSProvider() *CustomSMSProvider {
 	return &CustomSMSProvider{}
 }
-func (c *CustomSMSProvider) SendSMS(phone, otp string) error {
-	fmt.Printf("Sending SMS to %s: Your OTP is %s\n", phone, otp)
+
+func (c *CustomSMSProvider) logger() *slog.Logger {
+	if c.Logger != nil {
+		return utils.Redacting(c.Logger)
+	}
+	return utils.DefaultLogger()
+}
+
+func (c *CustomSMSProvider) Name() string {
+	return "custom"
+}
+
+func (c *CustomSMSProvider) SendSMS(ctx context.Context, phone, message string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.logger().InfoContext(ctx, "sending SMS", "provider", c.Name(), "channel", "SMS", "recipient", phone)
 	return errors.New("not Implemented")
 }
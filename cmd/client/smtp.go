@@ -0,0 +1,517 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+)
+
+// SMTPSecurity selects how the connection to the SMTP server is encrypted.
+type SMTPSecurity string
+
+const (
+	// SMTPStartTLS upgrades a plain connection with STARTTLS and fails if
+	// the server does not offer it. It is the default, on port 587.
+	SMTPStartTLS SMTPSecurity = "starttls"
+	// SMTPImplicitTLS connects with TLS from the start, on port 465.
+	SMTPImplicitTLS SMTPSecurity = "tls"
+	// SMTPPlain does not encrypt. Use it only for a relay on a trusted
+	// network; PLAIN and LOGIN auth refuse it except on localhost.
+	SMTPPlain SMTPSecurity = "none"
+)
+
+const (
+	DefaultSMTPTimeout     = 30 * time.Second
+	DefaultSMTPPoolSize    = 2
+	DefaultSMTPIdleTimeout = time.Minute
+	DefaultEmailSubject    = "Your verification code"
+)
+
+// SMTPConfig configures an SMTPProvider. Host and From are required.
+type SMTPConfig struct {
+	Host string
+	// Port defaults to 465 with SMTPImplicitTLS and 587 otherwise.
+	Port     int
+	Security SMTPSecurity
+	// Username enables authentication with Password.
+	Username string
+	Password string
+	// AuthMechanism is "PLAIN" or "LOGIN"; empty picks PLAIN, or LOGIN if
+	// the server offers only that.
+	AuthMechanism string
+	// From and ReplyTo are addresses, optionally with a display name, e.g.
+	// "Acme <no-reply@acme.example>".
+	From    string
+	ReplyTo string
+	// Subject is used for messages without one, as SendEmail sends.
+	Subject string
+	// HelloName is sent with EHLO; it defaults to "localhost".
+	HelloName string
+	// TLSConfig overrides the TLS settings; ServerName defaults to Host.
+	TLSConfig *tls.Config
+	// Timeout bounds connecting and each send.
+	Timeout time.Duration
+	// PoolSize is the number of idle connections kept for reuse, and
+	// IdleTimeout how long they are kept. A negative PoolSize disables
+	// reuse.
+	PoolSize    int
+	IdleTimeout time.Duration
+	// Logger defaults to utils.DefaultLogger; recipients are masked
+	// either way.
+	Logger *slog.Logger
+}
+
+// SMTPProvider sends email through an SMTP server, reusing connections
+// between messages. It implements EmailProvider and Channel; as a Channel
+// it sends the message's subject and, when set, an HTML alternative. It is
+// safe for concurrent use.
+type SMTPProvider struct {
+	cfg       SMTPConfig
+	addr      string
+	from      *mail.Address
+	replyTo   *mail.Address
+	tlsConfig *tls.Config
+	idle      chan *smtpConn
+}
+
+var (
+	_ EmailProvider = (*SMTPProvider)(nil)
+	_ Channel       = (*SMTPProvider)(nil)
+)
+
+// NewSMTPProvider validates cfg and returns a provider. It does not
+// connect until the first message is sent.
+func NewSMTPProvider(cfg SMTPConfig) (*SMTPProvider, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp: host is required")
+	}
+	switch cfg.Security {
+	case "":
+		cfg.Security = SMTPStartTLS
+	case SMTPStartTLS, SMTPImplicitTLS, SMTPPlain:
+	default:
+		return nil, fmt.Errorf("smtp: unknown security %q", cfg.Security)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+		if cfg.Security == SMTPImplicitTLS {
+			cfg.Port = 465
+		}
+	}
+	cfg.AuthMechanism = strings.ToUpper(cfg.AuthMechanism)
+	switch cfg.AuthMechanism {
+	case "", "PLAIN", "LOGIN":
+	default:
+		return nil, fmt.Errorf("smtp: unsupported auth mechanism %q", cfg.AuthMechanism)
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = DefaultEmailSubject
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultSMTPTimeout
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = DefaultSMTPPoolSize
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultSMTPIdleTimeout
+	}
+
+	p := &SMTPProvider{
+		cfg:  cfg,
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		idle: make(chan *smtpConn, max(cfg.PoolSize, 0)),
+	}
+	var err error
+	if p.from, err = mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("smtp: invalid from address: %w", err)
+	}
+	if cfg.ReplyTo != "" {
+		if p.replyTo, err = mail.ParseAddress(cfg.ReplyTo); err != nil {
+			return nil, fmt.Errorf("smtp: invalid reply-to address: %w", err)
+		}
+	}
+	if cfg.TLSConfig != nil {
+		p.tlsConfig = cfg.TLSConfig.Clone()
+	} else {
+		p.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if p.tlsConfig.ServerName == "" {
+		p.tlsConfig.ServerName = cfg.Host
+	}
+	return p, nil
+}
+
+func (p *SMTPProvider) Name() string {
+	return "smtp"
+}
+
+func (p *SMTPProvider) logger() *slog.Logger {
+	if p.cfg.Logger != nil {
+		return utils.Redacting(p.cfg.Logger)
+	}
+	return utils.DefaultLogger()
+}
+
+// SendEmail sends message as the text body, with the configured subject.
+func (p *SMTPProvider) SendEmail(ctx context.Context, email, message string) error {
+	_, err := p.Send(ctx, Message{Recipient: email, Subject: p.cfg.Subject, Text: message})
+	return err
+}
+
+// Send sends msg and returns its Message-ID as the receipt's MessageID.
+func (p *SMTPProvider) Send(ctx context.Context, msg Message) (Receipt, error) {
+	receipt := Receipt{Provider: p.Name()}
+	if err := ctx.Err(); err != nil {
+		return receipt, err
+	}
+	to, err := mail.ParseAddress(msg.Recipient)
+	if err != nil {
+		return receipt, fmt.Errorf("smtp: invalid recipient: %w", err)
+	}
+	if msg.Subject == "" {
+		msg.Subject = p.cfg.Subject
+	}
+	id, err := newMessageID(p.from.Address)
+	if err != nil {
+		return receipt, err
+	}
+	data, err := buildMessage(p.from, p.replyTo, to, msg, id, time.Now())
+	if err != nil {
+		return receipt, fmt.Errorf("smtp: build message: %w", err)
+	}
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		return receipt, err
+	}
+	stop := conn.watch(ctx, p.cfg.Timeout)
+	err = conn.send(p.from.Address, to.Address, data)
+	stop()
+	p.put(ctx, conn, err)
+	if err != nil {
+		return receipt, fmt.Errorf("smtp: %w", err)
+	}
+
+	receipt.MessageID = id
+	p.logger().DebugContext(ctx, "email sent", "provider", p.Name(), "channel", "EMAIL",
+		"recipient", to.Address, "message_id", id)
+	return receipt, nil
+}
+
+// Close quits and closes the idle connections.
+func (p *SMTPProvider) Close() error {
+	var errs []error
+	for {
+		select {
+		case conn := <-p.idle:
+			errs = append(errs, conn.quit(p.cfg.Timeout))
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// get returns an idle connection that still answers RSET, or a new one.
+func (p *SMTPProvider) get(ctx context.Context) (*smtpConn, error) {
+	for {
+		select {
+		case conn := <-p.idle:
+			if time.Since(conn.lastUsed) > p.cfg.IdleTimeout {
+				conn.quit(p.cfg.Timeout)
+				continue
+			}
+			// The server may have dropped the connection while it was idle.
+			stop := conn.watch(ctx, p.cfg.Timeout)
+			err := conn.client.Reset()
+			stop()
+			if err != nil {
+				conn.close()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			return conn, nil
+		default:
+			return p.dial(ctx)
+		}
+	}
+}
+
+// put keeps conn for reuse unless the send left it in an unknown state.
+// A rejection by the server leaves it usable.
+func (p *SMTPProvider) put(ctx context.Context, conn *smtpConn, err error) {
+	var protoErr *textproto.Error
+	if err != nil && (!errors.As(err, &protoErr) || ctx.Err() != nil) {
+		conn.close()
+		return
+	}
+	conn.lastUsed = time.Now()
+	select {
+	case p.idle <- conn:
+	default:
+		conn.quit(p.cfg.Timeout)
+	}
+}
+
+func (p *SMTPProvider) dial(ctx context.Context) (*smtpConn, error) {
+	dialer := &net.Dialer{Timeout: p.cfg.Timeout}
+	var netConn net.Conn
+	var err error
+	if p.cfg.Security == SMTPImplicitTLS {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}).DialContext(ctx, "tcp", p.addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smtp: connect: %w", err)
+	}
+
+	conn := &smtpConn{conn: netConn}
+	stop := conn.watch(ctx, p.cfg.Timeout)
+	defer stop()
+	if err := p.handshake(conn); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	p.logger().DebugContext(ctx, "connected to SMTP server", "provider", p.Name(), "addr", p.addr)
+	return conn, nil
+}
+
+// handshake greets the server, upgrades to TLS and authenticates.
+func (p *SMTPProvider) handshake(conn *smtpConn) error {
+	var err error
+	if conn.client, err = smtp.NewClient(conn.conn, p.cfg.Host); err != nil {
+		return err
+	}
+	if p.cfg.HelloName != "" {
+		if err := conn.client.Hello(p.cfg.HelloName); err != nil {
+			return err
+		}
+	}
+	if p.cfg.Security == SMTPStartTLS {
+		if ok, _ := conn.client.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := conn.client.StartTLS(p.tlsConfig); err != nil {
+			return err
+		}
+	}
+	if p.cfg.Username == "" {
+		return nil
+	}
+	return conn.client.Auth(p.auth(conn.client))
+}
+
+func (p *SMTPProvider) auth(client *smtp.Client) smtp.Auth {
+	mechanism := p.cfg.AuthMechanism
+	if mechanism == "" {
+		_, offered := client.Extension("AUTH")
+		mechanisms := strings.Fields(strings.ToUpper(offered))
+		mechanism = "PLAIN"
+		if !slices.Contains(mechanisms, "PLAIN") && slices.Contains(mechanisms, "LOGIN") {
+			mechanism = "LOGIN"
+		}
+	}
+	if mechanism == "LOGIN" {
+		return &loginAuth{username: p.cfg.Username, password: p.cfg.Password, host: p.cfg.Host}
+	}
+	return smtp.PlainAuth("", p.cfg.Username, p.cfg.Password, p.cfg.Host)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks. Like
+// smtp.PlainAuth it only sends credentials over TLS or to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// watch bounds the connection's I/O by timeout and ctx until the returned
+// function is called.
+func (c *smtpConn) watch(ctx context.Context, timeout time.Duration) (stop func()) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+	stopAfter := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	return func() {
+		stopAfter()
+		c.conn.SetDeadline(time.Time{})
+	}
+}
+
+func (c *smtpConn) send(from, to string, data []byte) error {
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+	if err := c.client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (c *smtpConn) quit(timeout time.Duration) error {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if err := c.client.Quit(); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *smtpConn) close() {
+	c.conn.Close()
+}
+
+// newMessageID returns a unique Message-ID, without angle brackets, in the
+// domain of from.
+func newMessageID(from string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("smtp: message id: %w", err)
+	}
+	domain := "localhost"
+	if at := strings.LastIndexByte(from, '@'); at >= 0 {
+		domain = from[at+1:]
+	}
+	return hex.EncodeToString(b[:]) + "@" + domain, nil
+}
+
+// buildMessage formats msg as a MIME message: quoted-printable text, or a
+// multipart/alternative of text and HTML when HTML is set. Non-ASCII
+// headers are RFC 2047 encoded.
+func buildMessage(from, replyTo, to *mail.Address, msg Message, id string, date time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	header := func(key, value string) {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", from.String())
+	header("To", to.String())
+	if replyTo != nil {
+		header("Reply-To", replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+id+">")
+	header("MIME-Version", "1.0")
+	if validLanguageTag(msg.Locale) {
+		header("Content-Language", msg.Locale)
+	}
+
+	if msg.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, msg.Text); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&b)
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, text); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// validLanguageTag reports whether tag looks like a BCP 47 tag, so it can
+// go into a header as is.
+func validLanguageTag(tag string) bool {
+	if tag == "" || len(tag) > 35 {
+		return false
+	}
+	for _, r := range tag {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
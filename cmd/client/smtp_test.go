@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/client/smtptest"
+)
+
+func newSMTPServer(t *testing.T, opts smtptest.Options) *smtptest.Server {
+	t.Helper()
+	server, err := smtptest.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// newSMTPProvider returns a provider for server that trusts its certificate.
+func newSMTPProvider(t *testing.T, server *smtptest.Server, cfg SMTPConfig) *SMTPProvider {
+	t.Helper()
+	cfg.Host = server.Host()
+	cfg.Port = server.Port()
+	cfg.TLSConfig = server.ClientTLSConfig()
+	cfg.From = "Acme <no-reply@acme.example>"
+	cfg.Timeout = 5 * time.Second
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	provider, err := NewSMTPProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.Close() })
+	return provider
+}
+
+func sendEmail(t *testing.T, provider *SMTPProvider, msg Message) Receipt {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receipt, err := provider.Send(ctx, msg)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	return receipt
+}
+
+func TestSMTPProviderSecurity(t *testing.T) {
+	tests := []struct {
+		name        string
+		implicitTLS bool
+		security    SMTPSecurity
+	}{
+		{"STARTTLS", false, SMTPStartTLS},
+		{"implicit TLS", true, SMTPImplicitTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, smtptest.Options{ImplicitTLS: tt.implicitTLS})
+			provider := newSMTPProvider(t, server, SMTPConfig{Security: tt.security})
+
+			receipt := sendEmail(t, provider, Message{Recipient: "user@example.com", Subject: "Code", Text: "Your code is 123456"})
+			if receipt.Provider != "smtp" || !strings.HasSuffix(receipt.MessageID, "@acme.example") {
+				t.Errorf("receipt = %+v", receipt)
+			}
+			messages := server.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(messages))
+			}
+			msg := messages[0]
+			if !msg.TLS {
+				t.Error("message was not sent over TLS")
+			}
+			if msg.From != "no-reply@acme.example" || len(msg.To) != 1 || msg.To[0] != "user@example.com" {
+				t.Errorf("envelope from %q to %q", msg.From, msg.To)
+			}
+			if !strings.Contains(string(msg.Data), "Message-ID: <"+receipt.MessageID+">\r\n") {
+				t.Errorf("message does not carry the receipt's Message-ID:\n%s", msg.Data)
+			}
+		})
+	}
+}
+
+func TestSMTPProviderAuth(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		password  string
+		wantErr   bool
+	}{
+		{"PLAIN", "secret", false},
+		{"LOGIN", "secret", false},
+		{"", "secret", false},
+		{"PLAIN", "wrong", true},
+		{"LOGIN", "wrong", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mechanism+"/"+tt.password, func(t *testing.T) {
+			server := newSMTPServer(t, smtptest.Options{Username: "otp", Password: "secret"})
+			provider := newSMTPProvider(t, server, SMTPConfig{
+				Username:      "otp",
+				Password:      tt.password,
+				AuthMechanism: tt.mechanism,
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := provider.Send(ctx, Message{Recipient: "user@example.com", Text: "code"})
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+				return
+			}
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) || protoErr.Code != 535 {
+				t.Errorf("Send error = %v, want 535", err)
+			}
+			if got := len(server.Messages()); got != 0 {
+				t.Errorf("server received %d messages without authentication", got)
+			}
+		})
+	}
+}
+
+func TestSMTPProviderRejectedRecipient(t *testing.T) {
+	server := newSMTPServer(t, smtptest.Options{
+		RejectRecipient: func(addr string) bool { return addr == "gone@example.com" },
+	})
+	provider := newSMTPProvider(t, server, SMTPConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := provider.Send(ctx, Message{Recipient: "gone@example.com", Text: "code"})
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Fatalf("Send error = %v, want 550", err)
+	}
+
+	// The rejection leaves the connection usable.
+	sendEmail(t, provider, Message{Recipient: "user@example.com", Text: "code"})
+	if got := len(server.Messages()); got != 1 {
+		t.Errorf("server received %d messages, want 1", got)
+	}
+	if got := server.Connections(); got != 1 {
+		t.Errorf("Connections() = %d, want 1", got)
+	}
+}
+
+func TestSMTPProviderReusesConnections(t *testing.T) {
+	tests := []struct {
+		name     string
+		poolSize int
+		want     int
+	}{
+		{"pooled", 0, 1},
+		{"no reuse", -1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, smtptest.Options{})
+			provider := newSMTPProvider(t, server, SMTPConfig{PoolSize: tt.poolSize})
+
+			for i := 0; i < 3; i++ {
+				sendEmail(t, provider, Message{Recipient: "user@example.com", Text: "code"})
+			}
+			if got := len(server.Messages()); got != 3 {
+				t.Errorf("server received %d messages, want 3", got)
+			}
+			if got := server.Connections(); got != tt.want {
+				t.Errorf("Connections() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSMTPProviderDotStuffing(t *testing.T) {
+	server := newSMTPServer(t, smtptest.Options{})
+	provider := newSMTPProvider(t, server, SMTPConfig{})
+
+	text := "Your code is 123456\n.\n..two dots\n.end"
+	sendEmail(t, provider, Message{Recipient: "user@example.com", Text: text})
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(messages))
+	}
+	_, body, ok := strings.Cut(string(messages[0].Data), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no body:\n%s", messages[0].Data)
+	}
+	if want := strings.ReplaceAll(text, "\n", "\r\n") + "\r\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
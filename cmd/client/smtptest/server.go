@@ -0,0 +1,385 @@
+// Package smtptest provides an in-process stand-in for an SMTP server, for
+// use in tests of code that sends email.
+package smtptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a Server.
+type Options struct {
+	// ImplicitTLS makes the server speak TLS from the start; otherwise it
+	// offers STARTTLS.
+	ImplicitTLS bool
+	// Username and Password, when set, are required with AUTH PLAIN or
+	// LOGIN before MAIL.
+	Username string
+	Password string
+	// RejectRecipient, when set, is called for each RCPT TO; returning true
+	// rejects the recipient with 550.
+	RejectRecipient func(addr string) bool
+}
+
+// Message is a message received by the server.
+type Message struct {
+	From string
+	To   []string
+	// Data is the message as sent after DATA, with CRLF line endings and
+	// dot-stuffing removed.
+	Data []byte
+	// TLS reports whether the message was sent over TLS.
+	TLS bool
+}
+
+// Server implements the subset of SMTP used by client.SMTPProvider: EHLO,
+// HELO, STARTTLS, AUTH (PLAIN, LOGIN), MAIL, RCPT, DATA, RSET, NOOP and
+// QUIT. It uses a self-signed certificate for 127.0.0.1 and localhost.
+type Server struct {
+	Addr string
+
+	opts      Options
+	listener  net.Listener
+	tlsConfig *tls.Config
+	roots     *x509.CertPool
+	mu        sync.Mutex
+	messages  []Message
+	accepted  int
+	conns     sync.WaitGroup
+	closed    chan struct{}
+}
+
+// NewServer starts a server listening on a random loopback port.
+func NewServer(opts Options) (*Server, error) {
+	cert, roots, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	var listener net.Listener
+	if opts.ImplicitTLS {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:      listener.Addr().String(),
+		opts:      opts,
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		roots:     roots,
+		closed:    make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Host returns the host of the server's address.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	return host
+}
+
+// Port returns the port of the server's address.
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// ClientTLSConfig returns a TLS configuration that trusts the server's
+// certificate.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.roots, ServerName: s.Host()}
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *Server) Close() error {
+	close(s.closed)
+	err := s.listener.Close()
+	s.conns.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.accepted++
+		s.mu.Unlock()
+		s.conns.Add(1)
+		go s.handle(conn)
+	}
+}
+
+type session struct {
+	conn   net.Conn
+	text   *textproto.Conn
+	tls    bool
+	hello  bool
+	authed bool
+	from   string
+	to     []string
+	inMail bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.conns.Done()
+	defer conn.Close()
+	go func() {
+		<-s.closed
+		conn.Close()
+	}()
+
+	sess := &session{conn: conn, text: textproto.NewConn(conn), tls: s.opts.ImplicitTLS}
+	sess.reply(220, "smtptest ESMTP ready")
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !s.dispatch(sess, strings.ToUpper(verb), arg) {
+			return
+		}
+	}
+}
+
+func (sess *session) reply(code int, lines ...string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		sess.text.PrintfLine("%d%s%s", code, sep, line)
+	}
+}
+
+func (sess *session) reset() {
+	sess.from, sess.to, sess.inMail = "", nil, false
+}
+
+// dispatch runs one command and reports whether the session continues.
+func (s *Server) dispatch(sess *session, verb, arg string) bool {
+	switch verb {
+	case "EHLO":
+		sess.hello = true
+		sess.reset()
+		lines := []string{"smtptest", "8BITMIME", "PIPELINING"}
+		if !sess.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		if s.opts.Username != "" {
+			lines = append(lines, "AUTH PLAIN LOGIN")
+		}
+		sess.reply(250, lines...)
+	case "HELO":
+		sess.hello = true
+		sess.reset()
+		sess.reply(250, "smtptest")
+	case "STARTTLS":
+		if sess.tls {
+			sess.reply(503, "already using TLS")
+			break
+		}
+		sess.reply(220, "ready to start TLS")
+		tlsConn := tls.Server(sess.conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return false
+		}
+		*sess = session{conn: tlsConn, text: textproto.NewConn(tlsConn), tls: true}
+	case "AUTH":
+		if s.opts.Username == "" || sess.authed {
+			sess.reply(503, "AUTH not allowed")
+			break
+		}
+		username, password, ok := s.readAuth(sess, arg)
+		if !ok {
+			return true
+		}
+		if username != s.opts.Username || password != s.opts.Password {
+			sess.reply(535, "authentication failed")
+			break
+		}
+		sess.authed = true
+		sess.reply(235, "authentication succeeded")
+	case "MAIL":
+		switch {
+		case !sess.hello:
+			sess.reply(503, "send EHLO first")
+		case s.opts.Username != "" && !sess.authed:
+			sess.reply(530, "authentication required")
+		case sess.inMail:
+			sess.reply(503, "nested MAIL command")
+		default:
+			addr, ok := path(arg, "FROM:")
+			if !ok {
+				sess.reply(501, "syntax: MAIL FROM:<address>")
+				break
+			}
+			sess.from, sess.inMail = addr, true
+			sess.reply(250, "OK")
+		}
+	case "RCPT":
+		addr, ok := path(arg, "TO:")
+		switch {
+		case !sess.inMail:
+			sess.reply(503, "need MAIL before RCPT")
+		case !ok:
+			sess.reply(501, "syntax: RCPT TO:<address>")
+		case s.opts.RejectRecipient != nil && s.opts.RejectRecipient(addr):
+			sess.reply(550, "mailbox unavailable")
+		default:
+			sess.to = append(sess.to, addr)
+			sess.reply(250, "OK")
+		}
+	case "DATA":
+		if len(sess.to) == 0 {
+			sess.reply(503, "need RCPT before DATA")
+			break
+		}
+		sess.reply(354, "end data with <CR><LF>.<CR><LF>")
+		data, err := sess.text.ReadDotBytes()
+		if err != nil {
+			return false
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, Message{From: sess.from, To: sess.to, Data: crlf(data), TLS: sess.tls})
+		id := len(s.messages)
+		s.mu.Unlock()
+		sess.reset()
+		sess.reply(250, fmt.Sprintf("OK queued as %d", id))
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "OK")
+	case "NOOP":
+		sess.reply(250, "OK")
+	case "QUIT":
+		sess.reply(221, "bye")
+		return false
+	default:
+		sess.reply(502, "command not implemented")
+	}
+	return true
+}
+
+// readAuth reads the credentials of an AUTH command. It replies and
+// returns false when the exchange fails.
+func (s *Server) readAuth(sess *session, arg string) (username, password string, ok bool) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if initial == "" {
+			sess.reply(334, "")
+			var err error
+			if initial, err = sess.text.ReadLine(); err != nil {
+				return "", "", false
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(decoded), "\x00")
+		if err != nil || len(parts) != 3 {
+			sess.reply(501, "malformed PLAIN response")
+			return "", "", false
+		}
+		return parts[1], parts[2], true
+	case "LOGIN":
+		values := make([]string, 2)
+		for i, prompt := range []string{"Username:", "Password:"} {
+			sess.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt)))
+			line, err := sess.text.ReadLine()
+			if err != nil {
+				return "", "", false
+			}
+			decoded, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				sess.reply(501, "malformed LOGIN response")
+				return "", "", false
+			}
+			values[i] = string(decoded)
+		}
+		return values[0], values[1], true
+	}
+	sess.reply(504, "unrecognized authentication type")
+	return "", "", false
+}
+
+// path parses "<prefix><address> [params]", as in MAIL FROM:<a@b>.
+func path(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(arg[len(prefix):]), " ")
+	if !strings.HasPrefix(addr, "<") || !strings.HasSuffix(addr, ">") {
+		return "", false
+	}
+	return addr[1 : len(addr)-1], true
+}
+
+// crlf restores the CRLF line endings that ReadDotBytes turns into LF.
+func crlf(data []byte) []byte {
+	return []byte(strings.ReplaceAll(string(data), "\n", "\r\n"))
+}
+
+func selfSigned() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots, nil
+}
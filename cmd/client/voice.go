@@ -0,0 +1,9 @@
+package client
+
+import "context"
+
+// VoiceProvider places a call to phone that reads message aloud. The
+// service uses it for the VOICE channel, with the SMS template.
+type VoiceProvider interface {
+	SendVoice(ctx context.Context, phone, message string) error
+}
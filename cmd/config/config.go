@@ -1,16 +1,119 @@
 package config
 
 import (
+	"log/slog"
+	"os"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
 	"github.com/spf13/viper"
-	"log"
 )
 
 type OTPConfig struct {
-	MinLength         int      `json:"min_length" yaml:"min_length"`
-	MaxLength         int      `json:"max_length" yaml:"max_length"`
-	ExpirationSeconds int      `json:"expiration_seconds" yaml:"expiration_seconds"`
-	RetryLimit        int      `json:"retry_limit" yaml:"retry_limit"`
-	AllowedDeliveries []string `json:"allowed_delivery_methods" yaml:"allowed_delivery_methods"`
+	MinLength         int             `json:"min_length" yaml:"min_length"`
+	MaxLength         int             `json:"max_length" yaml:"max_length"`
+	ExpirationSeconds int             `json:"expiration_seconds" yaml:"expiration_seconds"`
+	RetryLimit        int             `json:"retry_limit" yaml:"retry_limit"`
+	AllowedDeliveries []string        `json:"allowed_delivery_methods" yaml:"allowed_delivery_methods"`
+	CodeAlphabet      string          `json:"code_alphabet" yaml:"code_alphabet"`
+	CodeGroupSize     int             `json:"code_group_size" yaml:"code_group_size"`
+	CodeGroupSep      string          `json:"code_group_separator" yaml:"code_group_separator"`
+	HashAlgorithm     string          `json:"hash_algorithm" yaml:"hash_algorithm"`
+	HashPepper        string          `json:"-" yaml:"-"`
+	HashPepperID      string          `json:"hash_pepper_id" yaml:"hash_pepper_id"`
+	HashPrevious      string          `json:"-" yaml:"-"`
+	ResendCooldown    int             `json:"resend_cooldown_seconds" yaml:"resend_cooldown_seconds"`
+	MaxResends        int             `json:"max_resends" yaml:"max_resends"`
+	RateLimit         RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	PurposesFile      string          `json:"purposes_file" yaml:"purposes_file"`
+	TemplateStore     string          `json:"template_store" yaml:"template_store"`
+	TemplatesFile     string          `json:"templates_file" yaml:"templates_file"`
+	AppName           string          `json:"app_name" yaml:"app_name"`
+	PayloadKeyring    string          `json:"payload_keyring_file" yaml:"payload_keyring_file"`
+	PayloadKeys       string          `json:"-" yaml:"-"`
+	PayloadPrimaryKey string          `json:"payload_primary_key" yaml:"payload_primary_key"`
+	Sweep             SweepConfig     `json:"sweep" yaml:"sweep"`
+	Outbox            OutboxConfig    `json:"outbox" yaml:"outbox"`
+	PseudonymKey      string          `json:"-" yaml:"-"`
+	AuditSink         string          `json:"audit_sink" yaml:"audit_sink"`
+	AuditFile         string          `json:"audit_file" yaml:"audit_file"`
+	MetricsAddr       string          `json:"metrics_addr" yaml:"metrics_addr"`
+	Routing           string          `json:"routing" yaml:"routing"`
+	SMTP              SMTPConfig      `json:"smtp" yaml:"smtp"`
+	SMS               SMSConfig       `json:"sms" yaml:"sms"`
+}
+
+// SMSConfig selects and configures the SMS provider. Provider is empty for
+// the placeholder provider, "http" for a Twilio-compatible gateway or
+// "smpp" for an SMPP bind.
+type SMSConfig struct {
+	Provider            string     `json:"provider" yaml:"provider"`
+	BaseURL             string     `json:"base_url" yaml:"base_url"`
+	AccountSID          string     `json:"account_sid" yaml:"account_sid"`
+	AuthToken           string     `json:"-" yaml:"-"`
+	From                string     `json:"from" yaml:"from"`
+	MessagingServiceSID string     `json:"messaging_service_sid" yaml:"messaging_service_sid"`
+	StatusCallback      string     `json:"status_callback" yaml:"status_callback"`
+	TimeoutSeconds      int        `json:"timeout_seconds" yaml:"timeout_seconds"`
+	MaxRetries          int        `json:"max_retries" yaml:"max_retries"`
+	SMPP                SMPPConfig `json:"smpp" yaml:"smpp"`
+}
+
+// SMPPConfig configures the SMPP provider.
+type SMPPConfig struct {
+	Addr               string `json:"addr" yaml:"addr"`
+	SystemID           string `json:"system_id" yaml:"system_id"`
+	Password           string `json:"-" yaml:"-"`
+	SystemType         string `json:"system_type" yaml:"system_type"`
+	TLS                bool   `json:"tls" yaml:"tls"`
+	Source             string `json:"source" yaml:"source"`
+	WindowSize         int    `json:"window_size" yaml:"window_size"`
+	EnquireLinkSeconds int    `json:"enquire_link_seconds" yaml:"enquire_link_seconds"`
+	RegisteredDelivery bool   `json:"registered_delivery" yaml:"registered_delivery"`
+}
+
+// SMTPConfig configures the SMTP email provider, used when Host is set.
+// Security is starttls, tls or none.
+type SMTPConfig struct {
+	Host           string `json:"host" yaml:"host"`
+	Port           int    `json:"port" yaml:"port"`
+	Security       string `json:"security" yaml:"security"`
+	Username       string `json:"username" yaml:"username"`
+	Password       string `json:"-" yaml:"-"`
+	AuthMechanism  string `json:"auth" yaml:"auth"`
+	From           string `json:"from" yaml:"from"`
+	ReplyTo        string `json:"reply_to" yaml:"reply_to"`
+	Subject        string `json:"subject" yaml:"subject"`
+	TimeoutSeconds int    `json:"timeout_seconds" yaml:"timeout_seconds"`
+}
+
+// SweepConfig controls the background expiry sweeper. Retention is written
+// as "<status>=<duration>" pairs, e.g. "verified=720h,EXPIRED=168h".
+type SweepConfig struct {
+	Enabled         bool   `json:"enabled" yaml:"enabled"`
+	IntervalSeconds int    `json:"interval_seconds" yaml:"interval_seconds"`
+	BatchSize       int    `json:"batch_size" yaml:"batch_size"`
+	Retention       string `json:"retention" yaml:"retention"`
+	Archive         bool   `json:"archive" yaml:"archive"`
+}
+
+// OutboxConfig controls queued delivery through the transactional outbox.
+type OutboxConfig struct {
+	Enabled           bool `json:"enabled" yaml:"enabled"`
+	Workers           int  `json:"workers" yaml:"workers"`
+	BatchSize         int  `json:"batch_size" yaml:"batch_size"`
+	MaxAttempts       int  `json:"max_attempts" yaml:"max_attempts"`
+	BackoffSeconds    int  `json:"backoff_seconds" yaml:"backoff_seconds"`
+	MaxBackoffSeconds int  `json:"max_backoff_seconds" yaml:"max_backoff_seconds"`
+}
+
+// RateLimitConfig holds limits written as "<requests>/<window>", e.g. "5/1h".
+// Empty limits are unlimited.
+type RateLimitConfig struct {
+	Store             string   `json:"store" yaml:"store"`
+	SendPerRecipient  string   `json:"send_per_recipient" yaml:"send_per_recipient"`
+	SendPerClient     string   `json:"send_per_client" yaml:"send_per_client"`
+	ValidatePerClient string   `json:"validate_per_client" yaml:"validate_per_client"`
+	Overrides         []string `json:"overrides" yaml:"overrides"`
 }
 
 type TOTPConfig struct {
@@ -23,14 +126,21 @@ type TOTPConfig struct {
 }
 
 type Config struct {
-	DBDriver   string
-	DBHost     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBPort     string
-	SSLMode    string
-	TimeZone   string
+	DBDriver      string
+	DBHost        string
+	DBUser        string
+	DBPassword    string
+	DBName        string
+	DBPort        string
+	SSLMode       string
+	TimeZone      string
+	OTPStore      string
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisPrefix   string
+	LogLevel      string
+	LogFormat     string
 }
 
 var AppConfig *Config
@@ -38,6 +148,20 @@ var ConfigOTP *OTPConfig
 var ConfigTOTP *TOTPConfig
 var isTOTPEnabled bool
 
+// logger is built by LoadConfig from LOG_LEVEL and LOG_FORMAT.
+var logger = utils.DefaultLogger()
+
+// Logger returns the logger configured by LoadConfig, which redacts secrets
+// and masks recipients.
+func Logger() *slog.Logger {
+	return logger
+}
+
+// SetLogger replaces the logger used by this package and returned by Logger.
+func SetLogger(l *slog.Logger) {
+	logger = utils.Redacting(l)
+}
+
 func LoadConfig() {
 	viper.AddConfigPath(".")
 	viper.SetConfigFile(".env")
@@ -45,20 +169,52 @@ func LoadConfig() {
 
 	err := viper.ReadInConfig()
 	if err != nil {
-		log.Fatalf("Error reading config file, %s", err)
+		logger.Error("failed to read config file", "error", err)
+		os.Exit(1)
 	}
 
 	viper.AutomaticEnv()
+	viper.SetDefault("OTP_STORE", "sql")
+	viper.SetDefault("REDIS_PREFIX", "otp:")
+	viper.SetDefault("OTP_RESEND_COOLDOWN_SECONDS", 30)
+	viper.SetDefault("OTP_MAX_RESENDS", 3)
+	viper.SetDefault("OTP_RATE_LIMIT_STORE", "memory")
+	viper.SetDefault("OTP_SWEEP_ENABLED", true)
+	viper.SetDefault("OTP_SWEEP_INTERVAL_SECONDS", 60)
+	viper.SetDefault("OTP_SWEEP_BATCH_SIZE", 500)
+	viper.SetDefault("OTP_AUDIT_FILE", "otp-audit.jsonl")
+	viper.SetDefault("OTP_OUTBOX_WORKERS", 4)
+	viper.SetDefault("OTP_OUTBOX_BATCH_SIZE", 50)
+	viper.SetDefault("OTP_OUTBOX_MAX_ATTEMPTS", 5)
+	viper.SetDefault("OTP_OUTBOX_BACKOFF_SECONDS", 2)
+	viper.SetDefault("OTP_OUTBOX_MAX_BACKOFF_SECONDS", 300)
+	viper.SetDefault("SMTP_SECURITY", "starttls")
+	viper.SetDefault("SMTP_TIMEOUT_SECONDS", 30)
+	viper.SetDefault("SMS_TIMEOUT_SECONDS", 10)
+	viper.SetDefault("SMS_MAX_RETRIES", 2)
+	viper.SetDefault("SMPP_WINDOW_SIZE", 10)
+	viper.SetDefault("SMPP_ENQUIRE_LINK_SECONDS", 30)
+	viper.SetDefault("OTP_TEMPLATES_FILE", "templates.json")
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "text")
 	AppConfig = &Config{
-		DBDriver:   viper.GetString("DB_DRIVER"),
-		DBHost:     viper.GetString("DB_HOST"),
-		DBUser:     viper.GetString("DB_USER"),
-		DBPassword: viper.GetString("DB_PASSWORD"),
-		DBName:     viper.GetString("DB_NAME"),
-		DBPort:     viper.GetString("DB_PORT"),
-		SSLMode:    viper.GetString("SSL_MODE"),
-		TimeZone:   viper.GetString("TIME_ZONE"),
+		DBDriver:      viper.GetString("DB_DRIVER"),
+		DBHost:        viper.GetString("DB_HOST"),
+		DBUser:        viper.GetString("DB_USER"),
+		DBPassword:    viper.GetString("DB_PASSWORD"),
+		DBName:        viper.GetString("DB_NAME"),
+		DBPort:        viper.GetString("DB_PORT"),
+		SSLMode:       viper.GetString("SSL_MODE"),
+		TimeZone:      viper.GetString("TIME_ZONE"),
+		OTPStore:      viper.GetString("OTP_STORE"),
+		RedisAddr:     viper.GetString("REDIS_ADDR"),
+		RedisPassword: viper.GetString("REDIS_PASSWORD"),
+		RedisDB:       viper.GetInt("REDIS_DB"),
+		RedisPrefix:   viper.GetString("REDIS_PREFIX"),
+		LogLevel:      viper.GetString("LOG_LEVEL"),
+		LogFormat:     viper.GetString("LOG_FORMAT"),
 	}
+	SetLogger(utils.NewLogger(os.Stderr, utils.ParseLogLevel(AppConfig.LogLevel), AppConfig.LogFormat))
 
 	ConfigOTP = &OTPConfig{
 		MinLength:         viper.GetInt("OTP_MIN_LENGTH"),
@@ -66,6 +222,83 @@ func LoadConfig() {
 		ExpirationSeconds: viper.GetInt("OTP_EXPIRATION_SECONDS"),
 		RetryLimit:        viper.GetInt("OTP_RETRY_LIMIT"),
 		AllowedDeliveries: viper.GetStringSlice("OTP_ALLOWED_DELIVERY"),
+		CodeAlphabet:      viper.GetString("OTP_CODE_ALPHABET"),
+		CodeGroupSize:     viper.GetInt("OTP_CODE_GROUP_SIZE"),
+		CodeGroupSep:      viper.GetString("OTP_CODE_GROUP_SEPARATOR"),
+		HashAlgorithm:     viper.GetString("OTP_HASH_ALGORITHM"),
+		HashPepper:        viper.GetString("OTP_HASH_PEPPER"),
+		HashPepperID:      viper.GetString("OTP_HASH_PEPPER_ID"),
+		HashPrevious:      viper.GetString("OTP_HASH_PREVIOUS"),
+		ResendCooldown:    viper.GetInt("OTP_RESEND_COOLDOWN_SECONDS"),
+		MaxResends:        viper.GetInt("OTP_MAX_RESENDS"),
+		PurposesFile:      viper.GetString("OTP_PURPOSES_FILE"),
+		TemplateStore:     viper.GetString("OTP_TEMPLATE_STORE"),
+		TemplatesFile:     viper.GetString("OTP_TEMPLATES_FILE"),
+		AppName:           viper.GetString("OTP_APP_NAME"),
+		PayloadKeyring:    viper.GetString("OTP_PAYLOAD_KEYRING_FILE"),
+		PayloadKeys:       viper.GetString("OTP_PAYLOAD_KEYS"),
+		PayloadPrimaryKey: viper.GetString("OTP_PAYLOAD_PRIMARY_KEY"),
+		PseudonymKey:      viper.GetString("OTP_PSEUDONYM_KEY"),
+		AuditSink:         viper.GetString("OTP_AUDIT_SINK"),
+		AuditFile:         viper.GetString("OTP_AUDIT_FILE"),
+		MetricsAddr:       viper.GetString("OTP_METRICS_ADDR"),
+		Routing:           viper.GetString("OTP_ROUTING"),
+		RateLimit: RateLimitConfig{
+			Store:             viper.GetString("OTP_RATE_LIMIT_STORE"),
+			SendPerRecipient:  viper.GetString("OTP_RATE_LIMIT_SEND_PER_RECIPIENT"),
+			SendPerClient:     viper.GetString("OTP_RATE_LIMIT_SEND_PER_CLIENT"),
+			ValidatePerClient: viper.GetString("OTP_RATE_LIMIT_VALIDATE_PER_CLIENT"),
+			Overrides:         viper.GetStringSlice("OTP_RATE_LIMIT_OVERRIDES"),
+		},
+		Sweep: SweepConfig{
+			Enabled:         viper.GetBool("OTP_SWEEP_ENABLED"),
+			IntervalSeconds: viper.GetInt("OTP_SWEEP_INTERVAL_SECONDS"),
+			BatchSize:       viper.GetInt("OTP_SWEEP_BATCH_SIZE"),
+			Retention:       viper.GetString("OTP_RETENTION"),
+			Archive:         viper.GetBool("OTP_ARCHIVE"),
+		},
+		Outbox: OutboxConfig{
+			Enabled:           viper.GetBool("OTP_OUTBOX_ENABLED"),
+			Workers:           viper.GetInt("OTP_OUTBOX_WORKERS"),
+			BatchSize:         viper.GetInt("OTP_OUTBOX_BATCH_SIZE"),
+			MaxAttempts:       viper.GetInt("OTP_OUTBOX_MAX_ATTEMPTS"),
+			BackoffSeconds:    viper.GetInt("OTP_OUTBOX_BACKOFF_SECONDS"),
+			MaxBackoffSeconds: viper.GetInt("OTP_OUTBOX_MAX_BACKOFF_SECONDS"),
+		},
+		SMTP: SMTPConfig{
+			Host:           viper.GetString("SMTP_HOST"),
+			Port:           viper.GetInt("SMTP_PORT"),
+			Security:       viper.GetString("SMTP_SECURITY"),
+			Username:       viper.GetString("SMTP_USERNAME"),
+			Password:       viper.GetString("SMTP_PASSWORD"),
+			AuthMechanism:  viper.GetString("SMTP_AUTH"),
+			From:           viper.GetString("SMTP_FROM"),
+			ReplyTo:        viper.GetString("SMTP_REPLY_TO"),
+			Subject:        viper.GetString("SMTP_SUBJECT"),
+			TimeoutSeconds: viper.GetInt("SMTP_TIMEOUT_SECONDS"),
+		},
+		SMS: SMSConfig{
+			Provider:            viper.GetString("SMS_PROVIDER"),
+			BaseURL:             viper.GetString("SMS_BASE_URL"),
+			AccountSID:          viper.GetString("SMS_ACCOUNT_SID"),
+			AuthToken:           viper.GetString("SMS_AUTH_TOKEN"),
+			From:                viper.GetString("SMS_FROM"),
+			MessagingServiceSID: viper.GetString("SMS_MESSAGING_SERVICE_SID"),
+			StatusCallback:      viper.GetString("SMS_STATUS_CALLBACK"),
+			TimeoutSeconds:      viper.GetInt("SMS_TIMEOUT_SECONDS"),
+			MaxRetries:          viper.GetInt("SMS_MAX_RETRIES"),
+			SMPP: SMPPConfig{
+				Addr:               viper.GetString("SMPP_ADDR"),
+				SystemID:           viper.GetString("SMPP_SYSTEM_ID"),
+				Password:           viper.GetString("SMPP_PASSWORD"),
+				SystemType:         viper.GetString("SMPP_SYSTEM_TYPE"),
+				TLS:                viper.GetBool("SMPP_TLS"),
+				Source:             viper.GetString("SMPP_SOURCE"),
+				WindowSize:         viper.GetInt("SMPP_WINDOW_SIZE"),
+				EnquireLinkSeconds: viper.GetInt("SMPP_ENQUIRE_LINK_SECONDS"),
+				RegisteredDelivery: viper.GetBool("SMPP_REGISTERED_DELIVERY"),
+			},
+		},
 	}
 
 	ConfigTOTP = &TOTPConfig{
@@ -79,7 +312,7 @@ func LoadConfig() {
 
 	isTOTPEnabled = viper.GetBool("TOTP_ENABLED")
 
-	log.Println("✅ Configuration loaded successfully")
+	logger.Info("configuration loaded", "otp_store", AppConfig.OTPStore, "log_level", AppConfig.LogLevel)
 }
 
 func TOTPEnabled() bool {
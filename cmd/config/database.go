@@ -4,14 +4,13 @@ import (
 	"fmt"
 	"github.com/Zaman-R/otp-validator/cmd/db"
 	"github.com/Zaman-R/otp-validator/cmd/repository"
-	"log"
 )
 
 var database repository.Database
 
 func getDsn() string {
 	if AppConfig == nil {
-		log.Panic("❌ AppConfig is not initialized. Call config.LoadConfig() first.")
+		panic("AppConfig is not initialized. Call config.LoadConfig() first.")
 	}
 
 	switch AppConfig.DBDriver {
@@ -31,36 +30,40 @@ func getDsn() string {
 		)
 
 	default:
-		log.Panic("❌ Unsupported database driver:", AppConfig.DBDriver)
-		return ""
+		panic(fmt.Sprintf("unsupported database driver %q", AppConfig.DBDriver))
 	}
 }
 
+// ConnectDB opens the database connection; later calls reuse it.
 func ConnectDB() {
 	if AppConfig == nil {
-		log.Panic("❌ AppConfig is not initialized. Call config.LoadConfig() first.")
+		panic("AppConfig is not initialized. Call config.LoadConfig() first.")
+	}
+	if database != nil {
+		return
 	}
 
 	var err error
 	switch AppConfig.DBDriver {
 	case "postgres":
-		database, err = db.CreatePostgresDB(getDsn())
+		database, err = db.CreatePostgresDB(getDsn(), logger)
 	case "mysql":
-		database, err = db.CreateMySQLDB(getDsn())
+		database, err = db.CreateMySQLDB(getDsn(), logger)
 	default:
-		log.Panic("❌ Unsupported database driver:", AppConfig.DBDriver)
+		panic(fmt.Sprintf("unsupported database driver %q", AppConfig.DBDriver))
 	}
 
 	if err != nil {
-		log.Panic("❌ Failed to connect to database:", err)
+		logger.Error("failed to connect to database", "driver", AppConfig.DBDriver, "host", AppConfig.DBHost, "error", err)
+		panic(fmt.Sprintf("failed to connect to database: %v", err))
 	}
 
-	log.Println("✅ Database connected successfully.")
+	logger.Info("database connected", "driver", AppConfig.DBDriver, "host", AppConfig.DBHost)
 }
 
 func GetDB() repository.Database {
 	if database == nil {
-		log.Panic("❌ Database connection is not initialized. Call ConnectDB() first.")
+		panic("database connection is not initialized. Call ConnectDB() first.")
 	}
 	return database
 }
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold is the duration above which queries are logged.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// newGormLogger logs GORM warnings and errors to logger. Queries are logged
+// with placeholders so bound values, such as recipients, stay out of logs.
+func newGormLogger(logger *slog.Logger) gormlogger.Interface {
+	if logger == nil {
+		logger = utils.DefaultLogger()
+	}
+	return gormlogger.New(utils.Printf{Logger: logger, Level: slog.LevelWarn}, gormlogger.Config{
+		SlowThreshold:             slowQueryThreshold,
+		LogLevel:                  gormlogger.Warn,
+		IgnoreRecordNotFoundError: true,
+		ParameterizedQueries:      true,
+	})
+}
@@ -0,0 +1,7 @@
+ALTER TABLE otps ADD COLUMN code_length INT NOT NULL DEFAULT 0;
+ALTER TABLE otps ADD COLUMN resend_count INT DEFAULT 0;
+ALTER TABLE otps ADD COLUMN max_resends INT DEFAULT 0;
+ALTER TABLE otps ADD COLUMN last_sent_at TIMESTAMP;
+ALTER TABLE otps ADD COLUMN sms_body TEXT;
+ALTER TABLE otps ADD COLUMN email_subject VARCHAR(255);
+ALTER TABLE otps ADD COLUMN email_body TEXT;
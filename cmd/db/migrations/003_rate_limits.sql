@@ -0,0 +1,8 @@
+CREATE TABLE otp_rate_limits (
+                      bucket_key VARCHAR(255) PRIMARY KEY,
+                      tokens DOUBLE PRECISION NOT NULL,
+                      updated_at TIMESTAMP NOT NULL,
+                      full_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_otp_rate_limits_full_at ON otp_rate_limits (full_at);
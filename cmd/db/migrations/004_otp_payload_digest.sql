@@ -0,0 +1 @@
+ALTER TABLE otps ADD COLUMN payload_digest VARCHAR(64);
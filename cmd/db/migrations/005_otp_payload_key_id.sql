@@ -0,0 +1 @@
+ALTER TABLE otps ADD COLUMN payload_key_id VARCHAR(64);
@@ -0,0 +1,28 @@
+CREATE TABLE otp_archives (
+                      id UUID PRIMARY KEY,
+                      purpose VARCHAR(50) NOT NULL,
+                      hashed_otp TEXT NOT NULL,
+                      code_length INT NOT NULL DEFAULT 0,
+                      delivery VARCHAR(20) NOT NULL,
+                      mobile_number VARCHAR(20),
+                      email VARCHAR(100),
+                      transaction_payload TEXT,
+                      payload_digest VARCHAR(64),
+                      payload_key_id VARCHAR(64),
+                      retry_limit INT NOT NULL,
+                      retry_count INT DEFAULT 0,
+                      resend_count INT DEFAULT 0,
+                      max_resends INT DEFAULT 0,
+                      last_sent_at TIMESTAMP,
+                      sms_body TEXT,
+                      email_subject VARCHAR(255),
+                      email_body TEXT,
+                      expires_at TIMESTAMP NOT NULL,
+                      status VARCHAR(20) NOT NULL,
+                      created_at TIMESTAMP,
+                      updated_at TIMESTAMP,
+                      archived_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_otps_status_created_at ON otps (status, created_at);
+CREATE INDEX idx_otps_status_expires_at ON otps (status, expires_at);
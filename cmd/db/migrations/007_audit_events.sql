@@ -0,0 +1,15 @@
+CREATE TABLE otp_audit_events (
+                      sequence BIGINT PRIMARY KEY,
+                      time TIMESTAMP(6) NOT NULL,
+                      type VARCHAR(32) NOT NULL,
+                      otp_id UUID,
+                      purpose VARCHAR(50),
+                      delivery VARCHAR(20),
+                      recipient VARCHAR(255),
+                      client VARCHAR(255),
+                      metadata TEXT,
+                      prev_hash VARCHAR(64),
+                      hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX idx_otp_audit_events_otp_id ON otp_audit_events (otp_id);
@@ -0,0 +1,21 @@
+CREATE TABLE otp_outbox (
+                      id UUID PRIMARY KEY,
+                      otp_id UUID NOT NULL,
+                      resend_count INT NOT NULL DEFAULT 0,
+                      mode VARCHAR(20) NOT NULL,
+                      channels VARCHAR(100) NOT NULL,
+                      sealed_code TEXT NOT NULL,
+                      key_id VARCHAR(64) NOT NULL,
+                      status VARCHAR(20) NOT NULL,
+                      attempts INT NOT NULL DEFAULT 0,
+                      next_attempt_at TIMESTAMP NOT NULL,
+                      last_error TEXT,
+                      created_at TIMESTAMP,
+                      updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_otp_outbox_status_next_attempt_at ON otp_outbox (status, next_attempt_at);
+CREATE INDEX idx_otp_outbox_otp_id ON otp_outbox (otp_id);
+
+ALTER TABLE otps ADD COLUMN delivery_status VARCHAR(20);
+ALTER TABLE otp_archives ADD COLUMN delivery_status VARCHAR(20);
@@ -0,0 +1,2 @@
+ALTER TABLE otps ADD COLUMN locale VARCHAR(20);
+ALTER TABLE otp_archives ADD COLUMN locale VARCHAR(20);
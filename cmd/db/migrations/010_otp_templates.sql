@@ -0,0 +1,13 @@
+CREATE TABLE otp_templates (
+                      name VARCHAR(100) NOT NULL,
+                      channel VARCHAR(20) NOT NULL DEFAULT '',
+                      subject VARCHAR(255),
+                      text TEXT NOT NULL,
+                      html TEXT,
+                      created_at TIMESTAMP,
+                      updated_at TIMESTAMP,
+                      PRIMARY KEY (name, channel)
+);
+
+ALTER TABLE otps ADD COLUMN template VARCHAR(100);
+ALTER TABLE otp_archives ADD COLUMN template VARCHAR(100);
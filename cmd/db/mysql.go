@@ -1,17 +1,19 @@
 package db
 
 import (
+	"log/slog"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	"log"
 )
 
 type MySQLDB struct {
 	DB *gorm.DB
 }
 
-func CreateMySQLDB(dsn string) (*MySQLDB, error) {
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// CreateMySQLDB connects to dsn, logging slow queries and errors to logger.
+func CreateMySQLDB(dsn string, logger *slog.Logger) (*MySQLDB, error) {
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: newGormLogger(logger)})
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +31,7 @@ func (m *MySQLDB) Close() error {
 
 func (m *MySQLDB) GetDB() *gorm.DB {
 	if m.DB == nil {
-		log.Panic("Database connection is nil")
+		panic("database connection is nil")
 	}
 	return m.DB
 }
@@ -1,17 +1,19 @@
 package db
 
 import (
+	"log/slog"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"log"
 )
 
 type PostgresDB struct {
 	DB *gorm.DB
 }
 
-func CreatePostgresDB(dsn string) (*PostgresDB, error) {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// CreatePostgresDB connects to dsn, logging slow queries and errors to logger.
+func CreatePostgresDB(dsn string, logger *slog.Logger) (*PostgresDB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: newGormLogger(logger)})
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +31,7 @@ func (p *PostgresDB) Close() error {
 
 func (p *PostgresDB) GetDB() *gorm.DB {
 	if p.DB == nil {
-		log.Panic("Database connection is nil")
+		panic("database connection is nil")
 	}
 	return p.DB
 }
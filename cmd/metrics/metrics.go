@@ -0,0 +1,295 @@
+// Package metrics implements counters and histograms exposed in the
+// Prometheus text exposition format, without external dependencies.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to network
+// calls.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and serves them over HTTP. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+type family interface {
+	write(w io.Writer) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+// vec holds the series of a family keyed by their label values.
+type vec[T any] struct {
+	name, help string
+	labels     []string
+	newSeries  func() T
+
+	mu     sync.Mutex
+	series map[string]T
+	values map[string][]string
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newSeries()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// sorted returns the label values and series, ordered by label values.
+func (v *vec[T]) sorted() ([][]string, []T) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([][]string, len(keys))
+	series := make([]T, len(keys))
+	for i, key := range keys {
+		values[i], series[i] = v.values[key], v.series[key]
+	}
+	return values, series
+}
+
+func (v *vec[T]) header(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, kind)
+	return err
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a family of counters partitioned by labels.
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// NewCounterVec registers a counter family. Names should end in _total.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[*Counter]{
+		name: name, help: help, labels: labels,
+		newSeries: func() *Counter { return &Counter{} },
+		series:    make(map[string]*Counter),
+		values:    make(map[string][]string),
+	}}
+	r.register(name, v)
+	return v
+}
+
+// With returns the counter for labelValues, given in the order of the
+// family's labels.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.with(labelValues)
+}
+
+func (v *CounterVec) write(w io.Writer) error {
+	if err := v.header(w, "counter"); err != nil {
+		return err
+	}
+	values, counters := v.sorted()
+	for i, c := range counters {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.name, labelPairs(v.labels, values[i], "", ""), formatFloat(c.Value())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.upperBounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper
+// bounds, DefaultBuckets if nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	v := &HistogramVec{vec[*Histogram]{
+		name: name, help: help, labels: labels,
+		newSeries: func() *Histogram {
+			return &Histogram{upperBounds: buckets, counts: make([]uint64, len(buckets))}
+		},
+		series: make(map[string]*Histogram),
+		values: make(map[string][]string),
+	}}
+	r.register(name, v)
+	return v
+}
+
+// With returns the histogram for labelValues, given in the order of the
+// family's labels.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.with(labelValues)
+}
+
+func (v *HistogramVec) write(w io.Writer) error {
+	if err := v.header(w, "histogram"); err != nil {
+		return err
+	}
+	values, histograms := v.sorted()
+	for i, h := range histograms {
+		h.mu.Lock()
+		counts, count, sum := append([]uint64(nil), h.counts...), h.count, h.sum
+		h.mu.Unlock()
+
+		for j, bound := range h.upperBounds {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelPairs(v.labels, values[i], "le", formatFloat(bound)), counts[j]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelPairs(v.labels, values[i], "le", "+Inf"), count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n",
+			v.name, labelPairs(v.labels, values[i], "", ""), formatFloat(sum),
+			v.name, labelPairs(v.labels, values[i], "", ""), count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelPairs formats labels as {name="value",...}, with an optional extra
+// pair such as a bucket's le.
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
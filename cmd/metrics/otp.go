@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+)
+
+// TimeToVerifyBuckets are upper bounds in seconds for how long users take
+// to enter a code.
+var TimeToVerifyBuckets = []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600}
+
+// OTPMetrics is the Prometheus implementation of otp.Metrics.
+type OTPMetrics struct {
+	issued        *CounterVec
+	deliveries    *CounterVec
+	deliveryTime  *HistogramVec
+	verifications *CounterVec
+	timeToVerify  *HistogramVec
+}
+
+var _ otp.Metrics = (*OTPMetrics)(nil)
+
+// NewOTPMetrics registers the OTP metric families in registry.
+func NewOTPMetrics(registry *Registry) *OTPMetrics {
+	return &OTPMetrics{
+		issued: registry.NewCounterVec("otp_issued_total",
+			"OTP codes issued, including resends.", "purpose", "delivery"),
+		deliveries: registry.NewCounterVec("otp_deliveries_total",
+			"Messages handed to providers, by result.", "provider", "channel", "result"),
+		deliveryTime: registry.NewHistogramVec("otp_delivery_duration_seconds",
+			"Latency of provider calls.", DefaultBuckets, "provider", "channel"),
+		verifications: registry.NewCounterVec("otp_verifications_total",
+			"Verification attempts, by outcome.", "purpose", "outcome"),
+		timeToVerify: registry.NewHistogramVec("otp_time_to_verify_seconds",
+			"Time from sending a code to its successful verification.", TimeToVerifyBuckets, "purpose"),
+	}
+}
+
+func (m *OTPMetrics) OTPIssued(purpose, delivery string) {
+	m.issued.With(purpose, delivery).Inc()
+}
+
+func (m *OTPMetrics) DeliveryCompleted(provider, channel string, latency time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.deliveries.With(provider, channel, result).Inc()
+	m.deliveryTime.With(provider, channel).Observe(latency.Seconds())
+}
+
+func (m *OTPMetrics) VerificationCompleted(purpose, outcome string) {
+	m.verifications.With(purpose, outcome).Inc()
+}
+
+func (m *OTPMetrics) OTPVerified(purpose string, timeToVerify time.Duration) {
+	m.timeToVerify.With(purpose).Observe(timeToVerify.Seconds())
+}
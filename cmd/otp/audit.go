@@ -0,0 +1,288 @@
+package otp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+	"github.com/google/uuid"
+)
+
+// AuditEventType names a step in the lifecycle of an OTP.
+type AuditEventType string
+
+const (
+	AuditIssued         AuditEventType = "issued"
+	AuditDelivered      AuditEventType = "delivered"
+	AuditDeliveryFailed AuditEventType = "delivery_failed"
+	AuditAttemptFailed  AuditEventType = "attempt_failed"
+	AuditVerified       AuditEventType = "verified"
+	AuditExpired        AuditEventType = "expired"
+	AuditRevoked        AuditEventType = "revoked"
+)
+
+// ErrAuditTampered is matched by the errors VerifyAuditChain returns for a
+// broken chain.
+var ErrAuditTampered = errors.New("audit log has been tampered with")
+
+// AuditEvent is an entry of the audit log. Each entry carries the hash of
+// the one before it, so changing, removing or reordering entries breaks
+// the chain from that point on.
+type AuditEvent struct {
+	Sequence  int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Type      AuditEventType    `json:"type"`
+	OTPID     uuid.UUID         `json:"otp_id"`
+	Purpose   string            `json:"purpose"`
+	Delivery  string            `json:"delivery,omitempty"`
+	Recipient string            `json:"recipient,omitempty"`
+	Client    string            `json:"client,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// ComputeHash returns the hex SHA-256 of the event's JSON encoding without
+// its Hash field, which covers PrevHash and so every earlier event.
+func (e *AuditEvent) ComputeHash() (string, error) {
+	unhashed := *e
+	unhashed.Hash = ""
+	unhashed.Time = e.Time.UTC()
+	data, err := json.Marshal(unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditSink stores audit events in sequence order.
+type AuditSink interface {
+	// Append stores event, which has already been chained.
+	Append(ctx context.Context, event *AuditEvent) error
+	// Last returns the event with the highest sequence, or nil if there is
+	// none.
+	Last(ctx context.Context) (*AuditEvent, error)
+	// Read returns up to limit events with sequences greater than after, in
+	// sequence order.
+	Read(ctx context.Context, after int64, limit int) ([]*AuditEvent, error)
+}
+
+// AuditLog chains events and appends them to a sink. Chaining relies on
+// seeing the previous event, so one sink must only be written by a single
+// AuditLog at a time. It is safe for concurrent use.
+type AuditLog struct {
+	sink AuditSink
+	// OnError is called with errors of events that could not be recorded
+	// by the service, which does not fail requests because of them. By
+	// default the service logs them.
+	OnError func(error)
+
+	mu       sync.Mutex
+	loaded   bool
+	sequence int64
+	lastHash string
+}
+
+func NewAuditLog(sink AuditSink) *AuditLog {
+	return &AuditLog{sink: sink}
+}
+
+// auditAppendAttempts bounds how often Record rechains and appends an
+// event after the sink rejects it.
+const auditAppendAttempts = 3
+
+// Record assigns event the next sequence, the current time if it has none
+// and its hashes, then appends it to the sink. If the append fails, for
+// example because another writer took the sequence, the last event is
+// reloaded and the event is chained again, a bounded number of times.
+func (l *AuditLog) Record(ctx context.Context, event AuditEvent) (*AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	// Stores keep microseconds at best; hash what they will return.
+	event.Time = event.Time.UTC().Truncate(time.Microsecond)
+
+	var appendErr error
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		if !l.loaded {
+			last, err := l.sink.Last(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load last audit event: %w", err)
+			}
+			l.sequence, l.lastHash = 0, ""
+			if last != nil {
+				l.sequence, l.lastHash = last.Sequence, last.Hash
+			}
+			l.loaded = true
+			// A failed append may still have been stored.
+			if appendErr != nil && last != nil && last.Hash == event.Hash {
+				return &event, nil
+			}
+		}
+
+		event.Sequence = l.sequence + 1
+		event.PrevHash = l.lastHash
+		hash, err := event.ComputeHash()
+		if err != nil {
+			return nil, err
+		}
+		event.Hash = hash
+
+		if appendErr = l.sink.Append(ctx, &event); appendErr == nil {
+			l.sequence, l.lastHash = event.Sequence, event.Hash
+			return &event, nil
+		}
+		// The sink may have moved on without us; reload before chaining again.
+		l.loaded = false
+	}
+	return nil, fmt.Errorf("failed to append audit event: %w", appendErr)
+}
+
+// AuditTamperError reports where VerifyAuditChain found the chain broken.
+type AuditTamperError struct {
+	Sequence int64
+	Reason   string
+}
+
+func (e *AuditTamperError) Error() string {
+	return fmt.Sprintf("audit event %d: %s", e.Sequence, e.Reason)
+}
+
+func (e *AuditTamperError) Is(target error) bool {
+	return target == ErrAuditTampered
+}
+
+// AuditVerification summarizes a successful VerifyAuditChain run.
+type AuditVerification struct {
+	Events   int64
+	LastHash string
+}
+
+// VerifyAuditChain reads every event from sink, batchSize at a time, and
+// checks that sequences are contiguous from 1, that each event's hash
+// matches its contents and that it links to the previous event. It returns
+// an *AuditTamperError at the first broken event. Truncation of the newest
+// events can only be detected by comparing LastHash with a value kept
+// elsewhere.
+func VerifyAuditChain(ctx context.Context, sink AuditSink, batchSize int) (AuditVerification, error) {
+	var result AuditVerification
+	for {
+		events, err := sink.Read(ctx, result.Events, batchSize)
+		if err != nil {
+			return result, fmt.Errorf("failed to read audit events: %w", err)
+		}
+		if len(events) == 0 {
+			return result, nil
+		}
+		for _, event := range events {
+			want := result.Events + 1
+			if event.Sequence != want {
+				return result, &AuditTamperError{Sequence: want, Reason: fmt.Sprintf("found sequence %d instead", event.Sequence)}
+			}
+			if event.PrevHash != result.LastHash {
+				return result, &AuditTamperError{Sequence: want, Reason: "does not link to the previous event"}
+			}
+			hash, err := event.ComputeHash()
+			if err != nil {
+				return result, err
+			}
+			if hash != event.Hash {
+				return result, &AuditTamperError{Sequence: want, Reason: "contents do not match its hash"}
+			}
+			result.Events, result.LastHash = want, event.Hash
+		}
+	}
+}
+
+// WithAuditLog records the lifecycle events of OTPs in log.
+func WithAuditLog(log *AuditLog) Option {
+	return func(s *OTPService) {
+		s.auditLog = log
+	}
+}
+
+type auditMetadataKey struct{}
+
+// ContextWithAuditMetadata attaches caller metadata, such as a user agent
+// or request ID, to the audit events recorded for requests using ctx.
+func ContextWithAuditMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, auditMetadataKey{}, metadata)
+}
+
+// audit records event in the audit log. Failures are reported to the log's
+// error handler rather than failing the request.
+func (s *OTPService) audit(ctx context.Context, event LifecycleEvent) {
+	if s.auditLog == nil {
+		return
+	}
+	otp := &event.OTP
+	entry := AuditEvent{
+		Time:     event.Time,
+		Type:     event.Type,
+		OTPID:    otp.ID,
+		Purpose:  otp.Purpose,
+		Delivery: event.Delivery,
+		Client:   ClientFromContext(ctx),
+	}
+	if recipient := s.recipientFor(otp, event.Delivery); recipient != "" {
+		entry.Recipient = MaskRecipient(recipient)
+	} else {
+		var recipients []string
+		for _, recipient := range []string{otp.MobileNumber, otp.Email} {
+			if recipient != "" {
+				recipients = append(recipients, MaskRecipient(recipient))
+			}
+		}
+		entry.Recipient = strings.Join(recipients, ",")
+	}
+
+	metadata, _ := ctx.Value(auditMetadataKey{}).(map[string]string)
+	entry.Metadata = make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		entry.Metadata[k] = v
+	}
+	if event.Reason != "" {
+		entry.Metadata["reason"] = string(event.Reason)
+	}
+	if event.Type == AuditAttemptFailed {
+		entry.Metadata["remaining_attempts"] = strconv.Itoa(event.RemainingAttempts)
+	}
+	if event.Err != nil {
+		entry.Metadata["error"] = event.Err.Error()
+	}
+	if event.MessageID != "" {
+		entry.Metadata["provider_message_id"] = event.MessageID
+	}
+	if event.Type == AuditIssued && otp.ResendCount > 0 {
+		entry.Metadata["resend"] = strconv.Itoa(otp.ResendCount)
+	}
+
+	// Record even if the request was canceled after the fact happened.
+	if _, err := s.auditLog.Record(context.WithoutCancel(ctx), entry); err != nil {
+		if s.auditLog.OnError != nil {
+			s.auditLog.OnError(fmt.Errorf("%s event for OTP %s: %w", event.Type, otp.ID, err))
+			return
+		}
+		s.logger.ErrorContext(ctx, "failed to record audit event",
+			"otp_ref", otp.ID, "purpose", otp.Purpose, "event", event.Type, "error", err)
+	}
+}
+
+// MaskRecipient hides most of a mobile number or email: the last two
+// digits of a number and the first character and domain of an email stay
+// visible.
+func MaskRecipient(recipient string) string {
+	return utils.MaskRecipient(recipient)
+}
@@ -0,0 +1,193 @@
+package otp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+)
+
+// memorySink is an otp.AuditSink keeping events in a slice. Like the SQL
+// sink, it rejects an event that does not take the next sequence.
+type memorySink struct {
+	events []*otp.AuditEvent
+	// failures makes that many Append calls fail.
+	failures int
+	appends  int
+}
+
+func (s *memorySink) Append(ctx context.Context, event *otp.AuditEvent) error {
+	s.appends++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	if want := int64(len(s.events)) + 1; event.Sequence != want {
+		return fmt.Errorf("duplicate sequence %d", event.Sequence)
+	}
+	copied := *event
+	s.events = append(s.events, &copied)
+	return nil
+}
+
+func (s *memorySink) Last(ctx context.Context) (*otp.AuditEvent, error) {
+	if len(s.events) == 0 {
+		return nil, nil
+	}
+	return s.events[len(s.events)-1], nil
+}
+
+func (s *memorySink) Read(ctx context.Context, after int64, limit int) ([]*otp.AuditEvent, error) {
+	var events []*otp.AuditEvent
+	for _, event := range s.events {
+		if event.Sequence > after && len(events) < limit {
+			copied := *event
+			events = append(events, &copied)
+		}
+	}
+	return events, nil
+}
+
+func buildChain(t *testing.T, sink otp.AuditSink, n int) []*otp.AuditEvent {
+	t.Helper()
+	log := otp.NewAuditLog(sink)
+	events := make([]*otp.AuditEvent, n)
+	for i := range events {
+		event, err := log.Record(context.Background(), otp.AuditEvent{
+			Type:     otp.AuditIssued,
+			OTPID:    uuid.New(),
+			Purpose:  "login",
+			Metadata: map[string]string{"request_id": fmt.Sprint(i)},
+		})
+		if err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		events[i] = event
+	}
+	return events
+}
+
+func TestAuditChain(t *testing.T) {
+	sink := &memorySink{}
+	events := buildChain(t, sink, 5)
+	for i, event := range events {
+		if event.Sequence != int64(i+1) {
+			t.Errorf("event %d sequence = %d", i, event.Sequence)
+		}
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			t.Errorf("event %d does not link to the one before it", i)
+		}
+	}
+	result, err := otp.VerifyAuditChain(context.Background(), sink, 2)
+	if err != nil || result.Events != 5 || result.LastHash != events[4].Hash {
+		t.Errorf("VerifyAuditChain = %+v, %v", result, err)
+	}
+
+	// Each case tampers with a fresh copy of the chain.
+	tests := []struct {
+		name   string
+		tamper func(events []*otp.AuditEvent) []*otp.AuditEvent
+		broken int64
+	}{
+		{"modify", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events[2].Purpose = "transaction"
+			return events
+		}, 3},
+		{"modify metadata", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events[1].Metadata["request_id"] = "forged"
+			return events
+		}, 2},
+		{"modify and rehash", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events[2].Time = events[2].Time.Add(time.Second)
+			events[2].Hash, _ = events[2].ComputeHash()
+			return events
+		}, 4},
+		{"delete", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			return append(events[:2], events[3:]...)
+		}, 3},
+		{"delete and renumber", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events = append(events[:2], events[3:]...)
+			for i, event := range events {
+				event.Sequence = int64(i + 1)
+			}
+			return events
+		}, 3},
+		{"reorder", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events[1], events[2] = events[2], events[1]
+			return events
+		}, 2},
+		{"reorder and renumber", func(events []*otp.AuditEvent) []*otp.AuditEvent {
+			events[1], events[2] = events[2], events[1]
+			events[1].Sequence, events[2].Sequence = 2, 3
+			return events
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied, err := sink.Read(context.Background(), 0, len(events))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, event := range copied {
+				metadata := make(map[string]string, len(event.Metadata))
+				for k, v := range event.Metadata {
+					metadata[k] = v
+				}
+				event.Metadata = metadata
+			}
+			tampered := &memorySink{events: tt.tamper(copied)}
+
+			_, err = otp.VerifyAuditChain(context.Background(), tampered, 2)
+			var tamperErr *otp.AuditTamperError
+			if !errors.Is(err, otp.ErrAuditTampered) || !errors.As(err, &tamperErr) {
+				t.Fatalf("VerifyAuditChain error = %v, want ErrAuditTampered", err)
+			}
+			if tamperErr.Sequence != tt.broken {
+				t.Errorf("broken at %d, want %d", tamperErr.Sequence, tt.broken)
+			}
+		})
+	}
+}
+
+func TestAuditLogRecordRechains(t *testing.T) {
+	ctx := context.Background()
+	sink := &memorySink{}
+	log := otp.NewAuditLog(sink)
+	if _, err := log.Record(ctx, otp.AuditEvent{Type: otp.AuditIssued, OTPID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer extends the chain, so the next append under the
+	// sequence log expects fails.
+	if _, err := otp.NewAuditLog(sink).Record(ctx, otp.AuditEvent{Type: otp.AuditIssued, OTPID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+	event, err := log.Record(ctx, otp.AuditEvent{Type: otp.AuditVerified, OTPID: uuid.New()})
+	if err != nil {
+		t.Fatalf("Record after another writer: %v", err)
+	}
+	if event.Sequence != 3 || event.PrevHash != sink.events[1].Hash {
+		t.Errorf("event = seq %d, prev %q; want it chained after the other writer's", event.Sequence, event.PrevHash)
+	}
+	if result, err := otp.VerifyAuditChain(ctx, sink, 10); err != nil || result.Events != 3 {
+		t.Errorf("VerifyAuditChain = %+v, %v", result, err)
+	}
+
+	// Retries are bounded.
+	sink.failures, sink.appends = 3, 0
+	if _, err := log.Record(ctx, otp.AuditEvent{Type: otp.AuditExpired, OTPID: uuid.New()}); err == nil {
+		t.Error("Record with a failing sink succeeded")
+	}
+	if sink.appends != 3 {
+		t.Errorf("appends = %d, want 3", sink.appends)
+	}
+
+	// The log recovers once the sink does.
+	if event, err := log.Record(ctx, otp.AuditEvent{Type: otp.AuditExpired, OTPID: uuid.New()}); err != nil || event.Sequence != 4 {
+		t.Errorf("Record after the sink recovered = %+v, %v", event, err)
+	}
+}
@@ -0,0 +1,187 @@
+package otp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/client"
+)
+
+// RecipientKind selects which recipient of an OTP a channel delivers to
+// and, with it, which message template the channel uses.
+type RecipientKind string
+
+const (
+	// RecipientPhone channels deliver the SMS template to the mobile
+	// number.
+	RecipientPhone RecipientKind = "phone"
+	// RecipientEmail channels deliver the email subject and body to the
+	// email address.
+	RecipientEmail RecipientKind = "email"
+)
+
+type channelEntry struct {
+	kind    RecipientKind
+	channel client.Channel
+}
+
+// WithChannel registers channel under name, replacing any channel of that
+// name; a nil channel removes it. Routing and purpose policies refer to
+// channels by name. The providers given to NewOTPService are registered as
+// DeliverySMS and DeliveryEmail.
+func WithChannel(name string, kind RecipientKind, channel client.Channel) Option {
+	return func(s *OTPService) {
+		s.registerChannel(name, kind, channel)
+	}
+}
+
+func (s *OTPService) registerChannel(name string, kind RecipientKind, channel client.Channel) {
+	if channel == nil {
+		delete(s.channels, name)
+		return
+	}
+	s.channels[name] = channelEntry{kind: kind, channel: channel}
+}
+
+// Channels returns the names of the registered channels, sorted.
+func (s *OTPService) Channels() []string {
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validChannelName reports whether name can be used as a channel name.
+// Names are stored comma-separated, so they must not contain commas.
+func validChannelName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ", \t\n")
+}
+
+// recipientFor returns the address of otp that channel delivers to, or ""
+// if no such channel is registered.
+func (s *OTPService) recipientFor(otp *OTP, channel string) string {
+	entry, ok := s.channels[channel]
+	if !ok {
+		return ""
+	}
+	if entry.kind == RecipientEmail {
+		return otp.Email
+	}
+	return otp.MobileNumber
+}
+
+// canDeliver reports whether channel is registered and otp has a recipient
+// and a message body or template for it.
+func (s *OTPService) canDeliver(otp *OTP, channel string) bool {
+	entry, ok := s.channels[channel]
+	if !ok {
+		return false
+	}
+	var hasBody bool
+	switch entry.kind {
+	case RecipientPhone:
+		hasBody = otp.MobileNumber != "" && otp.SMSBody != ""
+	case RecipientEmail:
+		hasBody = otp.Email != "" && otp.EmailBody != ""
+	default:
+		return false
+	}
+	if hasBody {
+		return true
+	}
+	if s.recipientFor(otp, channel) == "" {
+		return false
+	}
+	_, ok = s.templateFor(otp, channel)
+	return ok
+}
+
+// allowsRecipient reports whether policy allows a registered channel that
+// delivers to kind.
+func (s *OTPService) allowsRecipient(policy PurposePolicy, kind RecipientKind) bool {
+	for name, entry := range s.channels {
+		if entry.kind == kind && policy.AllowsChannel(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// message renders what channel delivers for otp. Bodies given with the
+// request take precedence over the channel's template.
+func (s *OTPService) message(otp *OTP, channel string, kind RecipientKind, displayOTP string, boundPayload map[string]interface{}) (client.Message, error) {
+	msg := client.Message{
+		Locale: otp.Locale,
+		Metadata: map[string]string{
+			"otp_ref": otp.ID.String(),
+			"purpose": otp.Purpose,
+			"channel": channel,
+		},
+	}
+	switch kind {
+	case RecipientPhone:
+		msg.Recipient = otp.MobileNumber
+		if otp.SMSBody != "" {
+			msg.Text = renderMessage(otp.SMSBody, displayOTP, boundPayload)
+			return msg, nil
+		}
+	case RecipientEmail:
+		msg.Recipient = otp.Email
+		if otp.EmailBody != "" {
+			msg.Subject = renderMessage(otp.EmailSubject, displayOTP, boundPayload)
+			msg.Text = renderMessage(otp.EmailBody, displayOTP, boundPayload)
+			return msg, nil
+		}
+	}
+
+	t, ok := s.templateFor(otp, channel)
+	if !ok {
+		return msg, errNoTemplate
+	}
+	data := s.templateData(otp, channel, displayOTP, boundPayload)
+	subject, text, html, err := t.execute(data)
+	if err != nil {
+		return msg, fmt.Errorf("failed to render message template: %w", err)
+	}
+	if boundPayload != nil {
+		text = appendPayloadDetails(text, boundPayload,
+			data.Amount == "" || strings.Contains(text, data.Amount),
+			data.Payee == "" || strings.Contains(text, data.Payee))
+	}
+	msg.Text = text
+	if kind == RecipientEmail {
+		msg.Subject, msg.HTML = subject, html
+		if otp.EmailSubject != "" {
+			msg.Subject = renderMessage(otp.EmailSubject, displayOTP, boundPayload)
+		}
+	}
+	return msg, nil
+}
+
+// sendTo sends the code through a single channel and reports the outcome.
+func (s *OTPService) sendTo(ctx context.Context, otp *OTP, channel, displayOTP string, boundPayload map[string]interface{}) DeliveryAttempt {
+	entry := s.channels[channel]
+	start := time.Now()
+	msg, err := s.message(otp, channel, entry.kind, displayOTP, boundPayload)
+	var receipt client.Receipt
+	if err == nil {
+		receipt, err = entry.channel.Send(ctx, msg)
+	}
+	s.observeDelivery(entry.channel, channel, start, err)
+	if err != nil {
+		s.emit(ctx, LifecycleEvent{Type: AuditDeliveryFailed, OTP: *otp, Delivery: channel, Err: err})
+	} else {
+		s.emit(ctx, LifecycleEvent{Type: AuditDelivered, OTP: *otp, Delivery: channel, MessageID: receipt.MessageID})
+	}
+
+	provider := receipt.Provider
+	if provider == "" {
+		provider = client.ProviderName(entry.channel)
+	}
+	return DeliveryAttempt{Channel: channel, Provider: provider, MessageID: receipt.MessageID, Err: err}
+}
@@ -2,6 +2,7 @@ package otp
 
 import (
 	"github.com/google/uuid"
+	"log/slog"
 	"time"
 )
 
@@ -9,14 +10,26 @@ type OTP struct {
 	ID                 uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	Purpose            string    `gorm:"type:varchar(50);not null"`
 	HashedOTP          string    `gorm:"type:text;not null"`
+	CodeLength         int       `gorm:"not null;default:0"`
 	Delivery           string    `gorm:"type:varchar(20);not null"`
 	MobileNumber       string    `gorm:"type:varchar(20)"`
 	Email              string    `gorm:"type:varchar(100)"`
 	TransactionPayload string    `gorm:"type:text"`
+	PayloadDigest      string    `gorm:"type:varchar(64)"`
+	PayloadKeyID       string    `gorm:"type:varchar(64)"`
 	RetryLimit         int       `gorm:"not null"`
 	RetryCount         int       `gorm:"default:0"`
+	ResendCount        int       `gorm:"default:0"`
+	MaxResends         int       `gorm:"default:0"`
+	LastSentAt         time.Time
+	SMSBody            string    `gorm:"type:text"`
+	EmailSubject       string    `gorm:"type:varchar(255)"`
+	EmailBody          string    `gorm:"type:text"`
+	Template           string    `gorm:"type:varchar(100)"`
+	Locale             string    `gorm:"type:varchar(20)"`
 	ExpiresAt          time.Time `gorm:"not null"`
 	Status             string    `gorm:"type:varchar(20);not null;default:'PENDING'"`
+	DeliveryStatus     string    `gorm:"type:varchar(20)"`
 	CreatedAt          time.Time
 	UpdatedAt          time.Time
 }
@@ -27,3 +40,19 @@ const (
 	OTPStatusExpired  = "EXPIRED"
 	OTPStatusVerified = "verified"
 )
+
+// Consumable reports whether otp can still be verified at now.
+func (otp *OTP) Consumable(now time.Time) bool {
+	return otp.Status == OTPStatusPending && otp.RetryCount < otp.RetryLimit && now.Before(otp.ExpiresAt)
+}
+
+// LogValue logs only the non-sensitive fields of otp, so records passed to
+// a logger never leak hashes, payloads or recipients.
+func (otp OTP) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("otp_ref", otp.ID.String()),
+		slog.String("purpose", otp.Purpose),
+		slog.String("channel", otp.Delivery),
+		slog.String("status", otp.Status),
+	)
+}
@@ -0,0 +1,83 @@
+package otp
+
+import "time"
+
+// ErrorCode is a stable, machine-readable identifier for an OTP failure.
+type ErrorCode string
+
+const (
+	CodeExpired         ErrorCode = "expired"
+	CodeMaxAttempts     ErrorCode = "max_attempts"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeNoLongerValid   ErrorCode = "no_longer_valid"
+	CodeInvalidCode     ErrorCode = "invalid_code"
+	CodeDeliveryFailed  ErrorCode = "delivery_failed"
+	CodeInvalidRequest  ErrorCode = "invalid_request"
+	CodeResendCooldown  ErrorCode = "resend_cooldown"
+	CodeMaxResends      ErrorCode = "max_resends"
+	CodeRateLimited     ErrorCode = "rate_limited"
+	CodePayloadMismatch ErrorCode = "payload_mismatch"
+)
+
+// Error is the error type returned by OTPService. Callers should switch on
+// Code, or use errors.Is with the sentinel of the same code:
+//
+//	var otpErr *otp.Error
+//	if errors.As(err, &otpErr) && otpErr.Code == otp.CodeInvalidCode {
+//		fmt.Println("attempts left:", otpErr.RemainingAttempts)
+//	}
+//	if errors.Is(err, otp.ErrExpired) { ... }
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// RemainingAttempts is how many more codes may be tried for the OTP;
+	// only meaningful for CodeInvalidCode and CodePayloadMismatch.
+	RemainingAttempts int
+	// RetryAfter is how long to wait before the operation may succeed;
+	// set for CodeResendCooldown and CodeRateLimited.
+	RetryAfter time.Duration
+	// Err is the underlying cause, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an *Error with the same code, so every error
+// matches the sentinel for its code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrExpired        = &Error{Code: CodeExpired, Message: "OTP expired"}
+	ErrMaxAttempts    = &Error{Code: CodeMaxAttempts, Message: "maximum retry attempts reached, OTP expired"}
+	ErrNotFound       = &Error{Code: CodeNotFound, Message: "OTP not found"}
+	ErrInvalidCode    = &Error{Code: CodeInvalidCode, Message: "invalid OTP provided"}
+	ErrDeliveryFailed = &Error{Code: CodeDeliveryFailed, Message: "failed to deliver OTP"}
+	ErrInvalidRequest = &Error{Code: CodeInvalidRequest, Message: "invalid OTP request"}
+	ErrResendCooldown = &Error{Code: CodeResendCooldown, Message: "OTP was sent too recently"}
+	ErrMaxResends     = &Error{Code: CodeMaxResends, Message: "maximum resends reached"}
+	ErrRateLimited    = &Error{Code: CodeRateLimited, Message: "too many requests"}
+	// ErrPayloadMismatch is returned when the payload given to ValidateOTP
+	// differs from the one the OTP was sent for; it counts as a failed attempt.
+	ErrPayloadMismatch = &Error{Code: CodePayloadMismatch, Message: "payload does not match the OTP"}
+	// ErrNoLongerValid is returned when the OTP was already used, or when a
+	// conditional store update finds it is no longer PENDING, unexpired and
+	// below its retry limit.
+	ErrNoLongerValid = &Error{Code: CodeNoLongerValid, Message: "OTP is no longer valid"}
+)
+
+// newError returns an error with code, message and an optional cause.
+func newError(code ErrorCode, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
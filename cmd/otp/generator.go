@@ -0,0 +1,173 @@
+package otp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	AlphabetNumeric      = "0123456789"
+	AlphabetAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// AlphabetUnambiguous leaves out characters that are easily confused when
+	// read off a phone screen: 0/O and 1/I.
+	AlphabetUnambiguous = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+const (
+	DefaultMinLength = 4
+	DefaultMaxLength = 12
+)
+
+var ErrInvalidLength = errors.New("invalid OTP length")
+
+// CodeGenerator produces the raw codes sent to users.
+type CodeGenerator interface {
+	Generate(length int) (string, error)
+	// Normalize maps user input (grouping separators, whitespace, letter
+	// case) back to the canonical form returned by Generate.
+	Normalize(input string) string
+}
+
+// ResolveAlphabet maps the configuration names "numeric", "alphanumeric" and
+// "unambiguous" to their alphabets; any other value is used as a custom
+// alphabet.
+func ResolveAlphabet(name string) string {
+	switch strings.ToLower(name) {
+	case "", "numeric":
+		return AlphabetNumeric
+	case "alphanumeric":
+		return AlphabetAlphanumeric
+	case "unambiguous":
+		return AlphabetUnambiguous
+	default:
+		return name
+	}
+}
+
+// RandomCodeGenerator draws codes uniformly from an alphabet using crypto/rand.
+type RandomCodeGenerator struct {
+	alphabet        []byte
+	caseInsensitive bool
+	keepHyphen      bool
+}
+
+// NewCodeGenerator creates a generator for alphabet, which must consist of at
+// least two distinct printable ASCII characters.
+func NewCodeGenerator(alphabet string) (*RandomCodeGenerator, error) {
+	if len(alphabet) < 2 {
+		return nil, errors.New("OTP alphabet needs at least two characters")
+	}
+	seen := make(map[byte]bool, len(alphabet))
+	hasLower, hasUpper := false, false
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c <= ' ' || c > '~' {
+			return nil, fmt.Errorf("OTP alphabet contains unsupported character %q", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("OTP alphabet contains duplicate character %q", c)
+		}
+		seen[c] = true
+		hasLower = hasLower || unicode.IsLower(rune(c))
+		hasUpper = hasUpper || unicode.IsUpper(rune(c))
+	}
+	return &RandomCodeGenerator{
+		alphabet:        []byte(alphabet),
+		caseInsensitive: hasUpper && !hasLower,
+		keepHyphen:      seen['-'],
+	}, nil
+}
+
+// Generate returns a code of length characters. Random bytes that would
+// bias the result towards the start of the alphabet are rejected.
+func (g *RandomCodeGenerator) Generate(length int) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("%w: %d", ErrInvalidLength, length)
+	}
+	n := len(g.alphabet)
+	limit := 256 - 256%n
+
+	code := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, g.alphabet[int(b)%n])
+			if len(code) == length {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+func (g *RandomCodeGenerator) Normalize(input string) string {
+	var b strings.Builder
+	for _, r := range input {
+		if unicode.IsSpace(r) || (r == '-' && !g.keepHyphen) {
+			continue
+		}
+		if g.caseInsensitive {
+			r = unicode.ToUpper(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// CheckGrouping returns an error if separator shares a character with
+// alphabet. Codes are normalized by stripping the separator, which would
+// then also strip characters of the code.
+func CheckGrouping(alphabet, separator string) error {
+	if i := strings.IndexAny(separator, alphabet); i >= 0 {
+		return fmt.Errorf("OTP group separator %q contains %q, which is in the code alphabet", separator, separator[i])
+	}
+	return nil
+}
+
+// CheckLengthBounds returns an error unless the code length bounds min and
+// max, where zero stands for DefaultMinLength and DefaultMaxLength as in
+// WithLengthBounds, are positive and min does not exceed max.
+func CheckLengthBounds(min, max int) error {
+	if min < 0 || max < 0 {
+		return fmt.Errorf("%w: bounds %d and %d must be positive", ErrInvalidLength, min, max)
+	}
+	if min == 0 {
+		min = DefaultMinLength
+	}
+	if max == 0 {
+		max = DefaultMaxLength
+	}
+	if min > max {
+		return fmt.Errorf("%w: minimum %d exceeds maximum %d", ErrInvalidLength, min, max)
+	}
+	return nil
+}
+
+// FormatCode splits code into groups of size characters joined by separator,
+// e.g. "123456" becomes "123-456". A non-positive size returns code unchanged.
+func FormatCode(code string, size int, separator string) string {
+	if size <= 0 || len(code) <= size {
+		return code
+	}
+	var b strings.Builder
+	for i := 0; i < len(code); i += size {
+		if i > 0 {
+			b.WriteString(separator)
+		}
+		end := i + size
+		if end > len(code) {
+			end = len(code)
+		}
+		b.WriteString(code[i:end])
+	}
+	return b.String()
+}
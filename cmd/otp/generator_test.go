@@ -0,0 +1,166 @@
+package otp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, alphabet := range []string{AlphabetNumeric, AlphabetAlphanumeric, AlphabetUnambiguous, "ab-"} {
+		generator, err := NewCodeGenerator(alphabet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, length := range []int{1, 6, 12} {
+			code, err := generator.Generate(length)
+			if err != nil {
+				t.Fatalf("Generate(%d): %v", length, err)
+			}
+			if len(code) != length {
+				t.Errorf("Generate(%d) = %q, want %d characters", length, code, length)
+			}
+			if i := strings.IndexFunc(code, func(r rune) bool { return !strings.ContainsRune(alphabet, r) }); i >= 0 {
+				t.Errorf("Generate(%d) = %q, %q is not in %q", length, code, code[i], alphabet)
+			}
+		}
+		for _, length := range []int{0, -1} {
+			if _, err := generator.Generate(length); err == nil {
+				t.Errorf("Generate(%d) succeeded", length)
+			}
+		}
+	}
+}
+
+// TestGenerateUniform checks that every character is drawn about equally
+// often. The bound is many standard deviations wide, so the test does not
+// flake.
+func TestGenerateUniform(t *testing.T) {
+	generator, err := NewCodeGenerator(AlphabetUnambiguous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const draws = 64000
+	counts := make(map[rune]int)
+	for i := 0; i < draws/16; i++ {
+		code, err := generator.Generate(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range code {
+			counts[r]++
+		}
+	}
+	want := draws / len(AlphabetUnambiguous)
+	for _, r := range AlphabetUnambiguous {
+		if got := counts[r]; got < want*8/10 || got > want*12/10 {
+			t.Errorf("%q drawn %d times, want about %d", r, got, want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		alphabet, input, want string
+	}{
+		{AlphabetNumeric, "123-456", "123456"},
+		{AlphabetNumeric, " 123 456\t", "123456"},
+		{AlphabetAlphanumeric, "ab3-x9z", "AB3X9Z"},
+		{AlphabetUnambiguous, "abc def", "ABCDEF"},
+		// Characters left out of the unambiguous alphabet are kept, so a
+		// misread code fails verification instead of matching another code.
+		{AlphabetUnambiguous, "o0I1", "O0I1"},
+		// Mixed-case alphabets are case-sensitive.
+		{"abcXYZ", "aX-b", "aXb"},
+		// A hyphen in the alphabet is part of the code.
+		{"ab-", "a-b", "a-b"},
+	}
+	for _, tt := range tests {
+		generator, err := NewCodeGenerator(tt.alphabet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := generator.Normalize(tt.input); got != tt.want {
+			t.Errorf("Normalize(%q) with alphabet %q = %q, want %q", tt.input, tt.alphabet, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCode(t *testing.T) {
+	tests := []struct {
+		code      string
+		size      int
+		separator string
+		want      string
+	}{
+		{"123456", 3, "-", "123-456"},
+		{"12345678", 3, "-", "123-456-78"},
+		{"ABCDEF", 2, " ", "AB CD EF"},
+		{"123456", 6, "-", "123456"},
+		{"123456", 8, "-", "123456"},
+		{"123456", 0, "-", "123456"},
+		{"123456", -1, "-", "123456"},
+		{"", 3, "-", ""},
+	}
+	for _, tt := range tests {
+		if got := FormatCode(tt.code, tt.size, tt.separator); got != tt.want {
+			t.Errorf("FormatCode(%q, %d, %q) = %q, want %q", tt.code, tt.size, tt.separator, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGrouping(t *testing.T) {
+	tests := []struct {
+		alphabet, separator string
+		wantErr             bool
+	}{
+		{AlphabetNumeric, "-", false},
+		{AlphabetNumeric, "", false},
+		{AlphabetUnambiguous, " ", false},
+		{"0123456789-", "-", true},
+		{AlphabetAlphanumeric, "/X/", true},
+	}
+	for _, tt := range tests {
+		err := CheckGrouping(tt.alphabet, tt.separator)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckGrouping(%q, %q) = %v, want error %v", tt.alphabet, tt.separator, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPurposeRegistryCheckGrouping(t *testing.T) {
+	registry, err := NewPurposeRegistry(
+		PurposePolicy{Name: "login"},
+		PurposePolicy{Name: "voucher", Alphabet: "ABC-XYZ"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.CheckGrouping("."); err != nil {
+		t.Errorf("CheckGrouping(.) = %v", err)
+	}
+	if err := registry.CheckGrouping("-"); err == nil {
+		t.Error("CheckGrouping(-) accepted a separator in the voucher alphabet")
+	}
+}
+
+func TestCheckLengthBounds(t *testing.T) {
+	tests := []struct {
+		min, max int
+		wantErr  bool
+	}{
+		{6, 8, false},
+		{6, 6, false},
+		{0, 0, false},
+		{0, 8, false},
+		{8, 6, true},
+		{DefaultMaxLength + 1, 0, true},
+		{-1, 8, true},
+		{6, -8, true},
+	}
+	for _, tt := range tests {
+		err := CheckLengthBounds(tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckLengthBounds(%d, %d) = %v, want error %v", tt.min, tt.max, err, tt.wantErr)
+		}
+	}
+}
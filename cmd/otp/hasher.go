@@ -0,0 +1,398 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashAlgorithmBcrypt     = "bcrypt"
+	HashAlgorithmHMACSHA256 = "hmac-sha256"
+	HashAlgorithmArgon2id   = "argon2id"
+)
+
+var (
+	ErrUnknownHashAlgorithm = errors.New("unknown OTP hash algorithm")
+	ErrMalformedHash        = errors.New("malformed OTP hash")
+)
+
+// OTPHasher hashes OTP codes into a self-describing string of the form
+// "$<algorithm>$<params>$<hash>" and verifies codes against it.
+type OTPHasher interface {
+	// Algorithm returns the identifier written as the first hash segment.
+	Algorithm() string
+	Hash(code string) (string, error)
+	Verify(code, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced with parameters
+	// other than the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// NewHasher builds a hasher by algorithm name, as used in configuration.
+func NewHasher(algorithm string, pepper []byte, pepperID string) (OTPHasher, error) {
+	switch algorithm {
+	case "", HashAlgorithmBcrypt:
+		return NewBcryptHasher(bcrypt.DefaultCost), nil
+	case HashAlgorithmHMACSHA256:
+		return NewHMACHasher(pepperID, pepper)
+	case HashAlgorithmArgon2id:
+		return NewArgon2idHasher(DefaultArgon2idParams), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, algorithm)
+	}
+}
+
+// Hashers hashes new codes with a primary hasher and verifies stored hashes
+// with whichever registered hasher produced them.
+type Hashers struct {
+	primary     OTPHasher
+	byAlgorithm map[string]OTPHasher
+}
+
+// ParsePreviousHashers parses retired hashers written as a comma-separated
+// list of "bcrypt", "argon2id" or "hmac-sha256:<pepper id>:<pepper>"
+// entries, e.g. from OTP_HASH_PREVIOUS.
+func ParsePreviousHashers(spec string) ([]OTPHasher, error) {
+	var hashers []OTPHasher
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		algorithm, rest, _ := strings.Cut(entry, ":")
+		pepperID, pepper, _ := strings.Cut(rest, ":")
+		if algorithm == HashAlgorithmHMACSHA256 && (pepperID == "" || pepper == "") {
+			return nil, fmt.Errorf("previous OTP hasher %q: want %s:<pepper id>:<pepper>", algorithm, HashAlgorithmHMACSHA256)
+		}
+		if algorithm != HashAlgorithmHMACSHA256 && rest != "" {
+			return nil, fmt.Errorf("previous OTP hasher %q takes no pepper", algorithm)
+		}
+		hasher, err := NewHasher(algorithm, []byte(pepper), pepperID)
+		if err != nil {
+			return nil, err
+		}
+		hashers = append(hashers, hasher)
+	}
+	return hashers, nil
+}
+
+// NewHashers registers primary and any previously used hashers. A default
+// bcrypt hasher is always registered so hashes stored before hashing became
+// configurable keep verifying. The peppers of several HMAC hashers are
+// combined, with primary's pepper used for new hashes.
+func NewHashers(primary OTPHasher, previous ...OTPHasher) *Hashers {
+	h := &Hashers{
+		primary:     primary,
+		byAlgorithm: map[string]OTPHasher{HashAlgorithmBcrypt: NewBcryptHasher(bcrypt.DefaultCost)},
+	}
+	var peppers *HMACHasher
+	for _, hasher := range append(previous[:len(previous):len(previous)], primary) {
+		if hmacHasher, ok := hasher.(*HMACHasher); ok {
+			peppers = hmacHasher.withPeppers(peppers)
+			hasher = peppers
+		}
+		h.byAlgorithm[hasher.Algorithm()] = hasher
+	}
+	if _, ok := primary.(*HMACHasher); ok {
+		h.primary = peppers
+	}
+	return h
+}
+
+func (h *Hashers) Hash(code string) (string, error) {
+	return h.primary.Hash(code)
+}
+
+// Verify checks code against encoded and reports whether the stored hash
+// should be replaced with one from the primary hasher.
+func (h *Hashers) Verify(code, encoded string) (ok bool, rehash bool, err error) {
+	algorithm, err := hashAlgorithm(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	hasher, found := h.byAlgorithm[algorithm]
+	if !found {
+		return false, false, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, algorithm)
+	}
+	ok, err = hasher.Verify(code, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	rehash = algorithm != h.primary.Algorithm() || h.primary.NeedsRehash(encoded)
+	return true, rehash, nil
+}
+
+// hashAlgorithm extracts the algorithm identifier from an encoded hash.
+// Bare bcrypt hashes ("$2a$...") written by earlier versions map to bcrypt.
+func hashAlgorithm(encoded string) (string, error) {
+	if isBareBcrypt(encoded) {
+		return HashAlgorithmBcrypt, nil
+	}
+	algorithm, _, _, err := splitEncoded(encoded)
+	return algorithm, err
+}
+
+func isBareBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func splitEncoded(encoded string) (algorithm, params, hash string, err error) {
+	parts := strings.SplitN(encoded, "$", 4)
+	if len(parts) != 4 || parts[0] != "" || parts[1] == "" || parts[3] == "" {
+		return "", "", "", ErrMalformedHash
+	}
+	return parts[1], parts[2], parts[3], nil
+}
+
+func parseParams(params string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(params, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if found {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// BcryptHasher stores codes as "$bcrypt$cost=<n>$<bcrypt hash>".
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (b *BcryptHasher) Algorithm() string {
+	return HashAlgorithmBcrypt
+}
+
+func (b *BcryptHasher) Hash(code string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(code), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$cost=%d$%s", HashAlgorithmBcrypt, b.cost, hashed), nil
+}
+
+func (b *BcryptHasher) Verify(code, encoded string) (bool, error) {
+	hashed, err := b.bcryptHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(code))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+	hashed, err := b.bcryptHash(encoded)
+	if err != nil || isBareBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return err != nil || cost != b.cost
+}
+
+func (b *BcryptHasher) bcryptHash(encoded string) (string, error) {
+	if isBareBcrypt(encoded) {
+		return encoded, nil
+	}
+	algorithm, _, hashed, err := splitEncoded(encoded)
+	if err != nil {
+		return "", err
+	}
+	if algorithm != HashAlgorithmBcrypt {
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrMalformedHash, HashAlgorithmBcrypt, algorithm)
+	}
+	return hashed, nil
+}
+
+// HMACHasher stores codes as "$hmac-sha256$kid=<pepper id>$<mac>", keyed by a
+// server-side pepper. Older peppers can be kept for verification while a new
+// one is rolled out.
+type HMACHasher struct {
+	pepperID string
+	peppers  map[string][]byte
+}
+
+// NewHMACHasher creates an HMAC-SHA256 hasher using pepper as the current key.
+func NewHMACHasher(pepperID string, pepper []byte) (*HMACHasher, error) {
+	if len(pepper) == 0 {
+		return nil, errors.New("HMAC OTP hasher requires a non-empty pepper")
+	}
+	if pepperID == "" {
+		pepperID = "default"
+	}
+	return &HMACHasher{pepperID: pepperID, peppers: map[string][]byte{pepperID: pepper}}, nil
+}
+
+// AddPepper registers a retired pepper that is still accepted for verification.
+func (h *HMACHasher) AddPepper(pepperID string, pepper []byte) {
+	if pepperID == h.pepperID {
+		return
+	}
+	h.peppers[pepperID] = pepper
+}
+
+// withPeppers returns a copy of h that also accepts the peppers of other,
+// which may be nil.
+func (h *HMACHasher) withPeppers(other *HMACHasher) *HMACHasher {
+	merged := &HMACHasher{pepperID: h.pepperID, peppers: make(map[string][]byte)}
+	if other != nil {
+		for pepperID, pepper := range other.peppers {
+			merged.peppers[pepperID] = pepper
+		}
+	}
+	for pepperID, pepper := range h.peppers {
+		merged.peppers[pepperID] = pepper
+	}
+	return merged
+}
+
+func (h *HMACHasher) Algorithm() string {
+	return HashAlgorithmHMACSHA256
+}
+
+func (h *HMACHasher) Hash(code string) (string, error) {
+	mac := h.mac(h.peppers[h.pepperID], code)
+	return fmt.Sprintf("$%s$kid=%s$%s", HashAlgorithmHMACSHA256, h.pepperID, base64.RawStdEncoding.EncodeToString(mac)), nil
+}
+
+func (h *HMACHasher) Verify(code, encoded string) (bool, error) {
+	algorithm, params, hashed, err := splitEncoded(encoded)
+	if err != nil {
+		return false, err
+	}
+	if algorithm != HashAlgorithmHMACSHA256 {
+		return false, fmt.Errorf("%w: expected %s, got %s", ErrMalformedHash, HashAlgorithmHMACSHA256, algorithm)
+	}
+	pepperID := parseParams(params)["kid"]
+	pepper, ok := h.peppers[pepperID]
+	if !ok {
+		return false, fmt.Errorf("unknown OTP hash pepper %q", pepperID)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(hashed)
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return hmac.Equal(h.mac(pepper, code), expected), nil
+}
+
+func (h *HMACHasher) NeedsRehash(encoded string) bool {
+	_, params, _, err := splitEncoded(encoded)
+	return err != nil || parseParams(params)["kid"] != h.pepperID
+}
+
+func (h *HMACHasher) mac(pepper []byte, code string) []byte {
+	m := hmac.New(sha256.New, pepper)
+	m.Write([]byte(code))
+	return m.Sum(nil)
+}
+
+// Argon2idParams are the tunable argon2id cost parameters.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  1,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher stores codes as "$argon2id$v=19,m=<kib>,t=<n>,p=<n>$<salt>$<key>".
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (a *Argon2idHasher) Algorithm() string {
+	return HashAlgorithmArgon2id
+}
+
+func (a *Argon2idHasher) Hash(code string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(code), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+	return fmt.Sprintf("$%s$v=%d,m=%d,t=%d,p=%d$%s$%s", HashAlgorithmArgon2id, argon2.Version,
+		a.params.Memory, a.params.Iterations, a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2idHasher) Verify(code, encoded string) (bool, error) {
+	params, salt, key, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(code), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := a.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.params.Memory || params.Iterations != a.params.Iterations ||
+		params.Parallelism != a.params.Parallelism || len(salt) != a.params.SaltLength ||
+		uint32(len(key)) != a.params.KeyLength
+}
+
+func (a *Argon2idHasher) decode(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	algorithm, rawParams, rest, err := splitEncoded(encoded)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if algorithm != HashAlgorithmArgon2id {
+		return params, nil, nil, fmt.Errorf("%w: expected %s, got %s", ErrMalformedHash, HashAlgorithmArgon2id, algorithm)
+	}
+
+	values := parseParams(rawParams)
+	if values["v"] != strconv.Itoa(argon2.Version) {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version %q", ErrMalformedHash, values["v"])
+	}
+	memory, errM := strconv.ParseUint(values["m"], 10, 32)
+	iterations, errT := strconv.ParseUint(values["t"], 10, 32)
+	parallelism, errP := strconv.ParseUint(values["p"], 10, 8)
+	if errM != nil || errT != nil || errP != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	params = Argon2idParams{Memory: uint32(memory), Iterations: uint32(iterations), Parallelism: uint8(parallelism)}
+
+	rawSalt, rawKey, found := strings.Cut(rest, "$")
+	if !found {
+		return params, nil, nil, ErrMalformedHash
+	}
+	salt, errS := base64.RawStdEncoding.DecodeString(rawSalt)
+	key, errK := base64.RawStdEncoding.DecodeString(rawKey)
+	if errS != nil || errK != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,198 @@
+package otp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2idParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestHMACHasher(t *testing.T, pepperID, pepper string) *HMACHasher {
+	t.Helper()
+	hasher, err := NewHMACHasher(pepperID, []byte(pepper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	tests := []struct {
+		hasher OTPHasher
+		prefix string
+	}{
+		{NewBcryptHasher(bcrypt.MinCost), "$bcrypt$cost=4$"},
+		{newTestHMACHasher(t, "2024-06", "pepper"), "$hmac-sha256$kid=2024-06$"},
+		{NewArgon2idHasher(testArgon2idParams), "$argon2id$v=19,m=64,t=1,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hasher.Algorithm(), func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("123456")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Errorf("Hash = %q, want prefix %q", encoded, tt.prefix)
+			}
+			if algorithm, err := hashAlgorithm(encoded); err != nil || algorithm != tt.hasher.Algorithm() {
+				t.Errorf("hashAlgorithm(%q) = %q, %v", encoded, algorithm, err)
+			}
+			if ok, err := tt.hasher.Verify("123456", encoded); !ok || err != nil {
+				t.Errorf("Verify(right code) = %v, %v", ok, err)
+			}
+			if ok, err := tt.hasher.Verify("654321", encoded); ok || err != nil {
+				t.Errorf("Verify(wrong code) = %v, %v", ok, err)
+			}
+			if tt.hasher.NeedsRehash(encoded) {
+				t.Error("NeedsRehash of a fresh hash = true")
+			}
+		})
+	}
+}
+
+func TestHMACHasherPeppers(t *testing.T) {
+	old := newTestHMACHasher(t, "2024-01", "old pepper")
+	encoded, err := old.Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := newTestHMACHasher(t, "2024-06", "new pepper")
+	if _, err := current.Verify("123456", encoded); err == nil {
+		t.Error("Verify with an unknown pepper ID succeeded")
+	}
+	current.AddPepper("2024-01", []byte("old pepper"))
+	if ok, err := current.Verify("123456", encoded); !ok || err != nil {
+		t.Errorf("Verify with a retired pepper = %v, %v", ok, err)
+	}
+
+	// A hash claiming the current pepper ID but made with another pepper
+	// does not verify.
+	forged := strings.Replace(encoded, "kid=2024-01", "kid=2024-06", 1)
+	if ok, err := current.Verify("123456", forged); ok || err != nil {
+		t.Errorf("Verify(forged pepper ID) = %v, %v", ok, err)
+	}
+}
+
+func TestHasherMalformed(t *testing.T) {
+	hashers := []OTPHasher{
+		NewBcryptHasher(bcrypt.MinCost),
+		newTestHMACHasher(t, "default", "pepper"),
+		NewArgon2idHasher(testArgon2idParams),
+	}
+	malformed := []string{
+		"",
+		"123456",
+		"$",
+		"$bcrypt$cost=4",
+		"$hmac-sha256$kid=default$not base64!",
+		"$argon2id$v=19,m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18,m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19,m=x,t=1,p=1$c2FsdA$a2V5",
+		"$unknown$x$y",
+	}
+	for _, hasher := range hashers {
+		for _, encoded := range malformed {
+			if ok, err := hasher.Verify("123456", encoded); ok || err == nil {
+				t.Errorf("%s Verify(%q) = %v, %v; want an error", hasher.Algorithm(), encoded, ok, err)
+			}
+		}
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NewBcryptHasher(bcrypt.MinCost + 1).NeedsRehash(bcryptHash) {
+		t.Error("bcrypt NeedsRehash after a cost change = false")
+	}
+	bare, err := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NewBcryptHasher(bcrypt.MinCost).NeedsRehash(string(bare)) {
+		t.Error("bcrypt NeedsRehash of a bare bcrypt hash = false")
+	}
+
+	hmacHash, err := newTestHMACHasher(t, "2024-01", "old pepper").Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newTestHMACHasher(t, "2024-06", "new pepper").NeedsRehash(hmacHash) {
+		t.Error("HMAC NeedsRehash after a pepper change = false")
+	}
+
+	argonHash, err := NewArgon2idHasher(testArgon2idParams).Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := testArgon2idParams
+	stronger.Iterations++
+	if !NewArgon2idHasher(stronger).NeedsRehash(argonHash) {
+		t.Error("argon2id NeedsRehash after a parameter change = false")
+	}
+}
+
+func TestHashers(t *testing.T) {
+	old := newTestHMACHasher(t, "2024-01", "old pepper")
+	argon := NewArgon2idHasher(testArgon2idParams)
+	hashers := NewHashers(newTestHMACHasher(t, "2024-06", "new pepper"), old, argon)
+
+	stored := make(map[string]string)
+	for name, hasher := range map[string]OTPHasher{"old pepper": old, "argon2id": argon, "bcrypt": NewBcryptHasher(bcrypt.MinCost)} {
+		encoded, err := hasher.Hash("123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[name] = encoded
+	}
+	current, err := hashers.Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored["current"] = current
+
+	for name, encoded := range stored {
+		ok, rehash, err := hashers.Verify("123456", encoded)
+		if !ok || err != nil {
+			t.Errorf("Verify(%s) = %v, %v", name, ok, err)
+		}
+		if rehash != (name != "current") {
+			t.Errorf("Verify(%s) rehash = %v", name, rehash)
+		}
+	}
+	if _, _, err := NewHashers(NewBcryptHasher(bcrypt.MinCost)).Verify("123456", current); !errors.Is(err, ErrUnknownHashAlgorithm) {
+		t.Errorf("Verify without the HMAC hasher error = %v, want ErrUnknownHashAlgorithm", err)
+	}
+}
+
+func TestParsePreviousHashers(t *testing.T) {
+	hashers, err := ParsePreviousHashers("argon2id, hmac-sha256:2024-01:old:pepper,bcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var algorithms []string
+	for _, hasher := range hashers {
+		algorithms = append(algorithms, hasher.Algorithm())
+	}
+	if got := strings.Join(algorithms, ","); got != "argon2id,hmac-sha256,bcrypt" {
+		t.Errorf("algorithms = %s", got)
+	}
+	if hmacHasher := hashers[1].(*HMACHasher); string(hmacHasher.peppers["2024-01"]) != "old:pepper" {
+		t.Errorf("peppers = %q", hmacHasher.peppers)
+	}
+
+	if hashers, err := ParsePreviousHashers(""); err != nil || len(hashers) != 0 {
+		t.Errorf(`ParsePreviousHashers("") = %v, %v`, hashers, err)
+	}
+	for _, spec := range []string{"md5", "hmac-sha256", "hmac-sha256:2024-01", "bcrypt:2024-01:pepper"} {
+		if _, err := ParsePreviousHashers(spec); err == nil {
+			t.Errorf("ParsePreviousHashers(%q) succeeded", spec)
+		}
+	}
+}
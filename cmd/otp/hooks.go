@@ -0,0 +1,190 @@
+package otp
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// LifecycleEvent describes a step in the lifecycle of an OTP. It is passed
+// to Hooks and recorded in the audit log.
+type LifecycleEvent struct {
+	Type AuditEventType
+	Time time.Time
+	// OTP is a copy of the record as of the event.
+	OTP OTP
+	// Delivery is the channel of delivery events.
+	Delivery string
+	// Reason explains failed attempts and expiries.
+	Reason ErrorCode
+	// RemainingAttempts is set for failed attempts.
+	RemainingAttempts int
+	// MessageID is the provider's ID for a delivered message, if any.
+	MessageID string
+	// Err is the provider error of a failed delivery.
+	Err error
+}
+
+// Hooks observes OTP lifecycle events. Embed NoopHooks to implement only
+// the methods of interest. Hooks must not modify the event's OTP.
+type Hooks interface {
+	// OnIssued is called after a code was generated and stored, including
+	// the new code of a resend.
+	OnIssued(ctx context.Context, event LifecycleEvent)
+	OnDelivered(ctx context.Context, event LifecycleEvent)
+	OnDeliveryFailed(ctx context.Context, event LifecycleEvent)
+	OnAttemptFailed(ctx context.Context, event LifecycleEvent)
+	OnVerified(ctx context.Context, event LifecycleEvent)
+	// OnExpired is called when the service marks an OTP expired, because
+	// its time ran out, its attempts were used up or its code could not be
+	// delivered.
+	OnExpired(ctx context.Context, event LifecycleEvent)
+}
+
+// NoopHooks implements Hooks by doing nothing.
+type NoopHooks struct{}
+
+func (NoopHooks) OnIssued(context.Context, LifecycleEvent)         {}
+func (NoopHooks) OnDelivered(context.Context, LifecycleEvent)      {}
+func (NoopHooks) OnDeliveryFailed(context.Context, LifecycleEvent) {}
+func (NoopHooks) OnAttemptFailed(context.Context, LifecycleEvent)  {}
+func (NoopHooks) OnVerified(context.Context, LifecycleEvent)       {}
+func (NoopHooks) OnExpired(context.Context, LifecycleEvent)        {}
+
+// WithHooks calls hooks synchronously, in order, before the service method
+// that caused an event returns. Slow hooks delay the caller.
+func WithHooks(hooks ...Hooks) Option {
+	return func(s *OTPService) {
+		s.hooks = append(s.hooks, hooks...)
+	}
+}
+
+// WithAsyncHooks calls hooks in a new goroutine per event, with a context
+// that is not canceled when the request is. Use WaitForHooks to let them
+// finish on shutdown.
+func WithAsyncHooks(hooks ...Hooks) Option {
+	return func(s *OTPService) {
+		s.asyncHooks = append(s.asyncHooks, hooks...)
+	}
+}
+
+// WaitForHooks waits until running asynchronous hooks return, or until ctx
+// is done.
+func (s *OTPService) WaitForHooks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.hooksWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// emit reports event to the audit log, metrics and hooks.
+func (s *OTPService) emit(ctx context.Context, event LifecycleEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	s.logEvent(ctx, event)
+	s.audit(ctx, event)
+	if s.metrics != nil {
+		switch event.Type {
+		case AuditIssued:
+			s.metrics.OTPIssued(event.OTP.Purpose, event.OTP.Delivery)
+		case AuditVerified:
+			s.metrics.OTPVerified(event.OTP.Purpose, event.Time.Sub(event.OTP.LastSentAt))
+		}
+	}
+
+	for _, hooks := range s.hooks {
+		s.callHook(ctx, hooks, event)
+	}
+	if len(s.asyncHooks) > 0 {
+		s.hooksWG.Add(1)
+		go func(ctx context.Context) {
+			defer s.hooksWG.Done()
+			for _, hooks := range s.asyncHooks {
+				s.callHook(ctx, hooks, event)
+			}
+		}(context.WithoutCancel(ctx))
+	}
+}
+
+// callHook dispatches event to the matching method of hooks. A panicking
+// hook is logged and otherwise ignored, so it cannot break the OTP flow.
+func (s *OTPService) callHook(ctx context.Context, hooks Hooks, event LifecycleEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.ErrorContext(ctx, "OTP hook panicked",
+				"otp_ref", event.OTP.ID, "purpose", event.OTP.Purpose, "event", event.Type,
+				"panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	switch event.Type {
+	case AuditIssued:
+		hooks.OnIssued(ctx, event)
+	case AuditDelivered:
+		hooks.OnDelivered(ctx, event)
+	case AuditDeliveryFailed:
+		hooks.OnDeliveryFailed(ctx, event)
+	case AuditAttemptFailed:
+		hooks.OnAttemptFailed(ctx, event)
+	case AuditVerified:
+		hooks.OnVerified(ctx, event)
+	case AuditExpired:
+		hooks.OnExpired(ctx, event)
+	}
+}
+
+// logEvent logs event with the fields shared by every OTP log line.
+func (s *OTPService) logEvent(ctx context.Context, event LifecycleEvent) {
+	level, message := slog.LevelInfo, ""
+	switch event.Type {
+	case AuditIssued:
+		message = "OTP issued"
+	case AuditDelivered:
+		message = "OTP delivered"
+	case AuditDeliveryFailed:
+		level, message = slog.LevelWarn, "OTP delivery failed"
+	case AuditAttemptFailed:
+		message = "OTP attempt failed"
+	case AuditVerified:
+		message = "OTP verified"
+	case AuditExpired:
+		message = "OTP expired"
+	case AuditRevoked:
+		message = "OTP revoked"
+	}
+	if !s.logger.Enabled(ctx, level) {
+		return
+	}
+
+	channel := event.Delivery
+	if channel == "" {
+		channel = event.OTP.Delivery
+	}
+	attrs := []slog.Attr{
+		slog.String("otp_ref", event.OTP.ID.String()),
+		slog.String("purpose", event.OTP.Purpose),
+		slog.String("channel", channel),
+	}
+	if event.Reason != "" {
+		attrs = append(attrs, slog.String("reason", string(event.Reason)))
+	}
+	if event.Type == AuditAttemptFailed {
+		attrs = append(attrs, slog.Int("remaining_attempts", event.RemainingAttempts))
+	}
+	if event.MessageID != "" {
+		attrs = append(attrs, slog.String("provider_message_id", event.MessageID))
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+	s.logger.LogAttrs(ctx, level, message, attrs...)
+}
@@ -0,0 +1,372 @@
+package otp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+	"github.com/google/uuid"
+)
+
+// PayloadAlgorithm is the first segment of encrypted payloads.
+const PayloadAlgorithm = "aes-256-gcm"
+
+// PayloadKeySize is the length of payload encryption keys in bytes.
+const PayloadKeySize = 32
+
+var (
+	ErrUnknownPayloadKey = errors.New("unknown payload encryption key")
+	ErrMalformedPayload  = errors.New("malformed encrypted payload")
+)
+
+// Keyring holds the key-encryption keys for transaction payloads. Payloads
+// are sealed with envelope encryption: each one gets a fresh AES-256 data
+// key, which is itself sealed with the keyring's primary key. Every key in
+// the ring can open payloads, so retired keys stay until rows are rotated.
+// It is safe for concurrent use.
+type Keyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring that seals with the key named primary. Keys
+// must be 32 bytes long, for AES-256.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if err := k.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.SetPrimary(primary); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add registers a key. Adding an existing ID replaces its key.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.ContainsAny(id, ":,$") {
+		return fmt.Errorf("invalid payload key ID %q", id)
+	}
+	if len(key) != PayloadKeySize {
+		return fmt.Errorf("payload key %q is %d bytes, want %d", id, len(key), PayloadKeySize)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("payload key %q: %w", id, err)
+	}
+	k.mu.Lock()
+	k.keys[id] = aead
+	k.mu.Unlock()
+	return nil
+}
+
+// SetPrimary selects the key new payloads are sealed with.
+func (k *Keyring) SetPrimary(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownPayloadKey, id)
+	}
+	k.primary = id
+	return nil
+}
+
+// Primary returns the ID of the key new payloads are sealed with.
+func (k *Keyring) Primary() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// KeyIDs returns the IDs of all keys in the ring, sorted.
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Seal encrypts plaintext for the OTP with otpID and returns the ID of the
+// key used and the encoded "$aes-256-gcm$<sealed data key>$<sealed data>"
+// string. The OTP ID is authenticated, so the result cannot be moved to
+// another row.
+func (k *Keyring) Seal(otpID uuid.UUID, plaintext []byte) (keyID, sealed string, err error) {
+	k.mu.RLock()
+	keyID, kek := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+
+	dataKey := make([]byte, PayloadKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", "", err
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	wrapped, err := seal(kek, dataKey, []byte(keyID))
+	if err != nil {
+		return "", "", err
+	}
+	data, err := seal(dek, plaintext, otpID[:])
+	if err != nil {
+		return "", "", err
+	}
+	return keyID, fmt.Sprintf("$%s$%s$%s", PayloadAlgorithm,
+		base64.RawStdEncoding.EncodeToString(wrapped), base64.RawStdEncoding.EncodeToString(data)), nil
+}
+
+// Open decrypts a payload sealed by Seal with the key keyID for otpID.
+func (k *Keyring) Open(otpID uuid.UUID, keyID, sealed string) ([]byte, error) {
+	k.mu.RLock()
+	kek, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPayloadKey, keyID)
+	}
+
+	parts := strings.Split(sealed, "$")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != PayloadAlgorithm {
+		return nil, ErrMalformedPayload
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedPayload
+	}
+	data, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, ErrMalformedPayload
+	}
+
+	dataKey, err := open(kek, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap payload data key: %w", err)
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return nil, ErrMalformedPayload
+	}
+	plaintext, err := open(dek, data, otpID[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedPayload
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// ParseKeyring builds a keyring from keys written as comma-separated
+// "<id>:<base64 key>" pairs, as used in environment variables. An empty
+// primary selects the first key.
+func ParseKeyring(primary, spec string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("invalid payload key: want <id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("payload key %q is not valid base64: %w", id, err)
+		}
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+	return NewKeyring(primary, keys)
+}
+
+// keyringFile is the JSON form read by LoadKeyring.
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a keyring from a JSON document mapping key IDs to
+// base64 keys:
+//
+//	{"primary": "2024-06", "keys": {"2024-01": "...", "2024-06": "..."}}
+func LoadKeyring(r io.Reader) (*Keyring, error) {
+	var file keyringFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode keyring: %w", err)
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("payload key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(file.Primary, keys)
+}
+
+// LoadKeyringFile reads a keyring with LoadKeyring from the file at path.
+func LoadKeyringFile(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadKeyring(f)
+}
+
+// NewKeyringFromConfig loads the keyring from file if set, otherwise parses
+// it from spec as ParseKeyring does. A non-empty primary overrides the
+// primary key named in file. It returns a nil keyring when neither is set.
+func NewKeyringFromConfig(file, primary, spec string) (*Keyring, error) {
+	switch {
+	case file != "":
+		keyring, err := LoadKeyringFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if primary != "" {
+			if err := keyring.SetPrimary(primary); err != nil {
+				return nil, err
+			}
+		}
+		return keyring, nil
+	case spec != "":
+		return ParseKeyring(primary, spec)
+	default:
+		return nil, nil
+	}
+}
+
+// WithPayloadKeyring encrypts the transaction payloads of new OTPs with
+// keyring and decrypts stored ones. Payloads stored before a keyring was
+// configured remain readable.
+func WithPayloadKeyring(keyring *Keyring) Option {
+	return func(s *OTPService) {
+		s.keyring = keyring
+	}
+}
+
+func sealPayload(keyring *Keyring, id uuid.UUID, payload map[string]interface{}) (keyID, sealed string, err error) {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", "", err
+	}
+	return keyring.Seal(id, plaintext)
+}
+
+// openPayload decodes otp's stored payload: sealed with keyring when it has
+// a key ID, plain base64 JSON otherwise.
+func openPayload(keyring *Keyring, otp *OTP) (map[string]interface{}, error) {
+	if otp.TransactionPayload == "" {
+		return nil, nil
+	}
+	if otp.PayloadKeyID == "" {
+		return utils.DecodeBase64(otp.TransactionPayload)
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("%w: %q, no keyring configured", ErrUnknownPayloadKey, otp.PayloadKeyID)
+	}
+	plaintext, err := keyring.Open(otp.ID, otp.PayloadKeyID, otp.TransactionPayload)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// RotationStats summarizes a RotatePayloadKeys run.
+type RotationStats struct {
+	Scanned int
+	Rotated int
+	Failed  int
+}
+
+// RotatePayloadKeys re-encrypts every payload in store that is not sealed
+// with the keyring's primary key, including unencrypted ones, batchSize
+// records at a time. Records that fail to re-encrypt are skipped and their
+// errors returned together once the scan completes.
+func RotatePayloadKeys(ctx context.Context, store PayloadRotationStore, keyring *Keyring, batchSize int) (RotationStats, error) {
+	var stats RotationStats
+	var errs []error
+	primary := keyring.Primary()
+	after := uuid.Nil
+	for {
+		records, err := store.ListPayloadsToRotate(ctx, primary, after, batchSize)
+		if err != nil {
+			return stats, errors.Join(append(errs, err)...)
+		}
+		if len(records) == 0 {
+			return stats, errors.Join(errs...)
+		}
+		for _, record := range records {
+			after = record.ID
+			stats.Scanned++
+			rotated, err := rotatePayload(ctx, store, keyring, record)
+			switch {
+			case err != nil:
+				stats.Failed++
+				errs = append(errs, fmt.Errorf("OTP %s: %w", record.ID, err))
+			case rotated:
+				stats.Rotated++
+			}
+		}
+	}
+}
+
+func rotatePayload(ctx context.Context, store PayloadRotationStore, keyring *Keyring, record *OTP) (bool, error) {
+	payload, err := openPayload(keyring, record)
+	if err != nil {
+		return false, err
+	}
+	keyID, sealed, err := sealPayload(keyring, record.ID, payload)
+	if err != nil {
+		return false, err
+	}
+	return store.UpdatePayload(ctx, record.ID, record.PayloadKeyID, keyID, sealed)
+}
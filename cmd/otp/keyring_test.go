@@ -0,0 +1,197 @@
+package otp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+	"github.com/google/uuid"
+)
+
+// testKey returns a payload key made of b repeated.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, otp.PayloadKeySize)
+}
+
+func newKeyring(t *testing.T, primary string, keys map[string][]byte) *otp.Keyring {
+	t.Helper()
+	keyring, err := otp.NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	id := uuid.New()
+	plaintext := []byte(`{"amount":"100.00"}`)
+
+	keyID, sealed, err := keyring.Seal(id, plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if keyID != "k1" || !strings.HasPrefix(sealed, "$"+otp.PayloadAlgorithm+"$") {
+		t.Errorf("Seal = %q, %q", keyID, sealed)
+	}
+	if bytes.Contains([]byte(sealed), plaintext) {
+		t.Error("sealed payload contains the plaintext")
+	}
+	opened, err := keyring.Open(id, keyID, sealed)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open = %q, %v", opened, err)
+	}
+	if _, err := keyring.Open(uuid.New(), keyID, sealed); err == nil {
+		t.Error("Open for another OTP succeeded")
+	}
+}
+
+func TestKeyringRetiredKey(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	id := uuid.New()
+	_, sealed, err := keyring.Seal(id, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Add("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetPrimary("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _, err := keyring.Seal(id, []byte("payload")); err != nil || keyID != "k2" {
+		t.Errorf("Seal after the primary change used %q, %v", keyID, err)
+	}
+	if opened, err := keyring.Open(id, "k1", sealed); err != nil || string(opened) != "payload" {
+		t.Errorf("Open with the retired key = %q, %v", opened, err)
+	}
+
+	withoutOld := newKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+	if _, err := withoutOld.Open(id, "k1", sealed); !errors.Is(err, otp.ErrUnknownPayloadKey) {
+		t.Errorf("Open without the key error = %v, want ErrUnknownPayloadKey", err)
+	}
+	// A payload cannot be opened by claiming another key ID.
+	if _, err := keyring.Open(id, "k2", sealed); err == nil {
+		t.Error("Open with the wrong key ID succeeded")
+	}
+}
+
+func TestKeyringTampered(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	id := uuid.New()
+	_, sealed, err := keyring.Seal(id, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(sealed, "$")
+
+	flip := func(segment string) string {
+		raw, err := base64.RawStdEncoding.DecodeString(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		return base64.RawStdEncoding.EncodeToString(raw)
+	}
+	tampered := map[string]string{
+		"data key":  strings.Join([]string{"", parts[1], flip(parts[2]), parts[3]}, "$"),
+		"data":      strings.Join([]string{"", parts[1], parts[2], flip(parts[3])}, "$"),
+		"algorithm": strings.Join([]string{"", "aes-128-gcm", parts[2], parts[3]}, "$"),
+		"truncated": strings.Join(parts[:3], "$"),
+	}
+	for name, sealed := range tampered {
+		if opened, err := keyring.Open(id, "k1", sealed); err == nil {
+			t.Errorf("Open(tampered %s) = %q", name, opened)
+		}
+	}
+}
+
+func TestKeyringKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 24, 31, 33} {
+		if _, err := otp.NewKeyring("k1", map[string][]byte{"k1": make([]byte, size)}); err == nil {
+			t.Errorf("NewKeyring accepted a %d-byte key", size)
+		}
+	}
+	spec := "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16))
+	if _, err := otp.ParseKeyring("", spec); err == nil {
+		t.Error("ParseKeyring accepted a 16-byte key")
+	}
+}
+
+func TestRotatePayloadKeys(t *testing.T) {
+	store := repository.NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	payload := map[string]interface{}{"amount": "100.00"}
+
+	old := newKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	sealed := newRecord()
+	keyID, encrypted, err := old.Seal(sealed.ID, []byte(`{"amount":"100.00"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed.PayloadKeyID, sealed.TransactionPayload = keyID, encrypted
+	plain := newRecord()
+	if plain.TransactionPayload, err = utils.EncodeBase64(payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []*otp.OTP{sealed, plain} {
+		if err := store.SaveOTP(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyring := newKeyring(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	stats, err := otp.RotatePayloadKeys(ctx, store, keyring, 1)
+	if err != nil {
+		t.Fatalf("RotatePayloadKeys: %v", err)
+	}
+	if stats.Rotated != 2 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 2 rotated", stats)
+	}
+
+	// The rotated payloads open without the retired key.
+	current := newKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+	for _, id := range []uuid.UUID{sealed.ID, plain.ID} {
+		record, err := store.GetOTPByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.PayloadKeyID != "k2" {
+			t.Errorf("OTP %s payload key = %q, want k2", id, record.PayloadKeyID)
+			continue
+		}
+		opened, err := current.Open(id, "k2", record.TransactionPayload)
+		if err != nil || string(opened) != `{"amount":"100.00"}` {
+			t.Errorf("OTP %s payload = %q, %v", id, opened, err)
+		}
+	}
+
+	if stats, err := otp.RotatePayloadKeys(ctx, store, keyring, 1); err != nil || stats.Scanned != 0 {
+		t.Errorf("second rotation = %+v, %v; want nothing to rotate", stats, err)
+	}
+}
+
+// newRecord returns an unsaved pending OTP.
+func newRecord() *otp.OTP {
+	now := time.Now()
+	return &otp.OTP{
+		ID:           uuid.New(),
+		Purpose:      "transaction",
+		HashedOTP:    "$bcrypt$cost=4$unused",
+		Delivery:     otp.DeliverySMS,
+		MobileNumber: "+15551234567",
+		Status:       otp.OTPStatusPending,
+		RetryLimit:   3,
+		LastSentAt:   now,
+		ExpiresAt:    now.Add(5 * time.Minute),
+	}
+}
@@ -0,0 +1,111 @@
+package otp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Payload keys shown to the user in messages for OTPs bound to a payload.
+const (
+	PayloadAmount   = "amount"
+	PayloadCurrency = "currency"
+	PayloadPayee    = "payee"
+)
+
+// CanonicalJSON encodes payload with sorted object keys, no insignificant
+// whitespace and no HTML escaping, so equal payloads always encode to the
+// same bytes whichever Go types hold their values. Numbers are normalized
+// through float64; pass amounts as strings when they need more precision.
+func CanonicalJSON(payload map[string]interface{}) ([]byte, error) {
+	// Round-trip first so structs, typed maps and integers of any width
+	// all reach the final encoding as plain JSON values.
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(normalized); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// PayloadDigest returns the unpadded base64url SHA-256 of payload's
+// canonical JSON. OTPs sent with a payload are bound to this digest.
+func PayloadDigest(payload map[string]interface{}) (string, error) {
+	canonical, err := CanonicalJSON(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// boundCode is the input hashed for a code bound to digest. Unbound codes
+// hash as themselves.
+func boundCode(code, digest string) string {
+	if digest == "" {
+		return code
+	}
+	return code + ":" + digest
+}
+
+// renderMessage fills the <otp> placeholder of template and, for bound
+// OTPs, the <amount>, <currency> and <payee> placeholders from payload. When
+// the payload has an amount or payee that the template does not show, they
+// are appended so the user always sees what they approve.
+func renderMessage(template, code string, payload map[string]interface{}) string {
+	message := strings.ReplaceAll(template, "<otp>", code)
+	if payload == nil {
+		return message
+	}
+
+	showsAmount := strings.Contains(message, "<amount>")
+	showsPayee := strings.Contains(message, "<payee>")
+	message = strings.NewReplacer(
+		"<amount>", payloadString(payload, PayloadAmount),
+		"<currency>", payloadString(payload, PayloadCurrency),
+		"<payee>", payloadString(payload, PayloadPayee),
+	).Replace(message)
+	return appendPayloadDetails(message, payload, showsAmount, showsPayee)
+}
+
+// appendPayloadDetails appends the amount and payee of payload to message
+// unless it already shows them.
+func appendPayloadDetails(message string, payload map[string]interface{}, showsAmount, showsPayee bool) string {
+	amount := strings.TrimSpace(payloadString(payload, PayloadAmount) + " " + payloadString(payload, PayloadCurrency))
+	payee := payloadString(payload, PayloadPayee)
+	var details []string
+	if amount != "" && !showsAmount {
+		details = append(details, "Amount: "+amount)
+	}
+	if payee != "" && !showsPayee {
+		details = append(details, "Payee: "+payee)
+	}
+	if len(details) > 0 {
+		message += "\n" + strings.Join(details, "\n")
+	}
+	return message
+}
+
+func payloadString(payload map[string]interface{}, key string) string {
+	value, ok := payload[key]
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,49 @@
+package otp
+
+import (
+	"errors"
+	"time"
+)
+
+// Outcomes reported to Metrics.VerificationCompleted besides error codes.
+const (
+	OutcomeVerified = "verified"
+	OutcomeError    = "error"
+)
+
+// Metrics receives measurements from OTPService. The metrics package
+// provides a Prometheus implementation; any other collector can be plugged
+// in. Implementations must be safe for concurrent use and fast.
+type Metrics interface {
+	// OTPIssued counts a code stored for purpose and delivery method,
+	// including codes generated by resends.
+	OTPIssued(purpose, delivery string)
+	// DeliveryCompleted records a provider call for channel and how long it
+	// took; err is nil if the provider accepted the message.
+	DeliveryCompleted(provider, channel string, latency time.Duration, err error)
+	// VerificationCompleted counts a verification by outcome:
+	// OutcomeVerified, the code of the *Error returned, or OutcomeError for
+	// other errors. purpose is empty if the OTP could not be loaded.
+	VerificationCompleted(purpose, outcome string)
+	// OTPVerified observes the time from the last send to verification.
+	OTPVerified(purpose string, timeToVerify time.Duration)
+}
+
+// WithMetrics reports measurements to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(s *OTPService) {
+		s.metrics = metrics
+	}
+}
+
+// verificationOutcome classifies the result of a verification for Metrics.
+func verificationOutcome(err error) string {
+	if err == nil {
+		return OutcomeVerified
+	}
+	var otpErr *Error
+	if errors.As(err, &otpErr) {
+		return string(otpErr.Code)
+	}
+	return OutcomeError
+}
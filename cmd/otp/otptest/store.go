@@ -0,0 +1,886 @@
+// Package otptest implements support for testing implementations of the otp
+// package interfaces.
+package otptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+)
+
+// TestStore runs the conformance checks every otp.OTPStore backend must
+// pass, plus those of otp.PayloadRotationStore, otp.MaintenanceStore,
+// otp.RecipientDataStore and otp.OutboxStore if the store implements them,
+// each as a subtest of t with a store from newStore. Stores may already
+// contain records; the checks only inspect the ones they create. The
+// concurrency checks live in TestConcurrency.
+func TestStore(t *testing.T, newStore func(t *testing.T) otp.OTPStore) {
+	run(t, newStore, "SaveAndGet", (*storeTester).testSaveAndGet)
+	run(t, newStore, "NotFound", (*storeTester).testNotFound)
+	run(t, newStore, "StatusTransitions", (*storeTester).testStatusTransitions)
+	run(t, newStore, "RetryIncrement", (*storeTester).testRetryIncrement)
+	run(t, newStore, "HashUpdate", (*storeTester).testHashUpdate)
+	run(t, newStore, "LookupByRecipient", (*storeTester).testLookupByRecipient)
+	run(t, newStore, "Copies", (*storeTester).testCopies)
+	run(t, newStore, "Consume", (*storeTester).testConsume)
+	run(t, newStore, "Expire", (*storeTester).testExpire)
+	run(t, newStore, "FailedAttempts", (*storeTester).testFailedAttempts)
+	run(t, newStore, "Resend", (*storeTester).testResend)
+	run(t, newStore, "RevertResend", (*storeTester).testRevertResend)
+	run(t, newStore, "CanceledContext", (*storeTester).testCanceledContext)
+	run(t, newStore, "PayloadRotation", func(t *storeTester) {
+		rotation, ok := t.store.(otp.PayloadRotationStore)
+		if !ok {
+			t.Skip("store does not implement otp.PayloadRotationStore")
+		}
+		t.testPayloadRotation(rotation)
+	})
+	run(t, newStore, "Maintenance", func(t *storeTester) {
+		maintenance, ok := t.store.(otp.MaintenanceStore)
+		if !ok {
+			t.Skip("store does not implement otp.MaintenanceStore")
+		}
+		t.testMaintenance(maintenance)
+	})
+	run(t, newStore, "RecipientData", func(t *storeTester) {
+		recipientData, ok := t.store.(otp.RecipientDataStore)
+		if !ok {
+			t.Skip("store does not implement otp.RecipientDataStore")
+		}
+		t.testRecipientData(recipientData)
+	})
+	run(t, newStore, "Outbox", func(t *storeTester) {
+		outbox, ok := t.store.(otp.OutboxStore)
+		if !ok {
+			t.Skip("store does not implement otp.OutboxStore")
+		}
+		t.testOutbox(outbox)
+	})
+}
+
+// TestConcurrency checks, as subtests of t, that ConsumeOTP, ExpireOTP and
+// RecordFailedAttempt stay atomic under Parallelism concurrent callers. Run
+// it with the race detector enabled.
+func TestConcurrency(t *testing.T, newStore func(t *testing.T) otp.OTPStore) {
+	run(t, newStore, "Consume", (*storeTester).testConcurrentConsume)
+	run(t, newStore, "VerifyAndExpire", (*storeTester).testConcurrentVerifyAndExpire)
+	run(t, newStore, "FailedAttempts", (*storeTester).testConcurrentFailedAttempts)
+}
+
+// Parallelism is the number of concurrent callers used by the race checks.
+var Parallelism = 20
+
+// storeTester runs one check against store, reporting failures to T.
+type storeTester struct {
+	*testing.T
+	ctx   context.Context
+	store otp.OTPStore
+}
+
+func run(t *testing.T, newStore func(t *testing.T) otp.OTPStore, name string, check func(t *storeTester)) {
+	t.Run(name, func(t *testing.T) {
+		check(&storeTester{T: t, ctx: context.Background(), store: newStore(t)})
+	})
+}
+
+func (t *storeTester) errorf(format string, args ...interface{}) {
+	t.Helper()
+	t.Errorf(format, args...)
+}
+
+// newOTP returns a unique pending record addressed to fresh recipients.
+func newOTP(purpose string) *otp.OTP {
+	suffix := uuid.NewString()[:8]
+	return &otp.OTP{
+		Purpose:      purpose,
+		HashedOTP:    "$bcrypt$cost=4$hash-" + suffix,
+		Delivery:     "SMS",
+		MobileNumber: "+1555" + suffix,
+		Email:        suffix + "@example.com",
+		RetryLimit:   3,
+		MaxResends:   2,
+		ExpiresAt:    time.Now().Add(5 * time.Minute).Truncate(time.Second),
+		Status:       otp.OTPStatusPending,
+	}
+}
+
+func (t *storeTester) save(record *otp.OTP) bool {
+	if err := t.store.SaveOTP(t.ctx, record); err != nil {
+		t.errorf("SaveOTP: %v", err)
+		return false
+	}
+	return true
+}
+
+func (t *storeTester) get(id uuid.UUID) *otp.OTP {
+	record, err := t.store.GetOTPByID(t.ctx, id)
+	if err != nil {
+		t.errorf("GetOTPByID(%s): %v", id, err)
+		return nil
+	}
+	return record
+}
+
+func (t *storeTester) testSaveAndGet() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	if record.ID == uuid.Nil {
+		t.errorf("SaveOTP did not assign an ID")
+	}
+	if record.CreatedAt.IsZero() || record.UpdatedAt.IsZero() {
+		t.errorf("SaveOTP did not set timestamps")
+	}
+
+	found := t.get(record.ID)
+	if found == nil {
+		return
+	}
+	if found.Purpose != record.Purpose || found.HashedOTP != record.HashedOTP ||
+		found.MobileNumber != record.MobileNumber || found.Email != record.Email ||
+		found.RetryLimit != record.RetryLimit || found.Status != record.Status {
+		t.errorf("GetOTPByID returned %+v, want %+v", found, record)
+	}
+	if !found.ExpiresAt.Equal(record.ExpiresAt) {
+		t.errorf("GetOTPByID ExpiresAt = %v, want %v", found.ExpiresAt, record.ExpiresAt)
+	}
+
+	preset := newOTP("login")
+	preset.ID = uuid.New()
+	if t.save(preset) && t.get(preset.ID) == nil {
+		t.errorf("SaveOTP did not keep a caller-assigned ID")
+	}
+}
+
+func (t *storeTester) testNotFound() {
+	missing := uuid.New()
+	if _, err := t.store.GetOTPByID(t.ctx, missing); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("GetOTPByID(missing) error = %v, want otp.ErrNotFound", err)
+	}
+	if _, err := t.store.GetValidOTPByPurpose(t.ctx, "nobody-"+missing.String(), "login"); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("GetValidOTPByPurpose(missing) error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+func (t *storeTester) testStatusTransitions() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	for _, status := range []string{otp.OTPStatusVerified, otp.OTPStatusExpired} {
+		if err := t.store.UpdateOTPStatus(t.ctx, record.ID, status); err != nil {
+			t.errorf("UpdateOTPStatus(%s): %v", status, err)
+			continue
+		}
+		if found := t.get(record.ID); found != nil && found.Status != status {
+			t.errorf("status after UpdateOTPStatus(%s) = %s", status, found.Status)
+		}
+	}
+}
+
+func (t *storeTester) testRetryIncrement() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		if err := t.store.IncrementRetryCount(t.ctx, record.ID); err != nil {
+			t.errorf("IncrementRetryCount: %v", err)
+			return
+		}
+	}
+	if found := t.get(record.ID); found != nil && found.RetryCount != 2 {
+		t.errorf("RetryCount after two increments = %d, want 2", found.RetryCount)
+	}
+}
+
+func (t *storeTester) testHashUpdate() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	if err := t.store.UpdateHashedOTP(t.ctx, record.ID, "$argon2id$upgraded"); err != nil {
+		t.errorf("UpdateHashedOTP: %v", err)
+		return
+	}
+	if found := t.get(record.ID); found != nil && found.HashedOTP != "$argon2id$upgraded" {
+		t.errorf("HashedOTP after UpdateHashedOTP = %q", found.HashedOTP)
+	}
+}
+
+func (t *storeTester) testLookupByRecipient() {
+	older := newOTP("register")
+	if !t.save(older) {
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	newer := newOTP("register")
+	newer.MobileNumber = older.MobileNumber
+	newer.Email = older.Email
+	if !t.save(newer) {
+		return
+	}
+
+	for _, recipient := range []string{older.MobileNumber, older.Email} {
+		found, err := t.store.GetValidOTPByPurpose(t.ctx, recipient, "register")
+		if err != nil {
+			t.errorf("GetValidOTPByPurpose(%s): %v", recipient, err)
+			continue
+		}
+		if found.ID != newer.ID {
+			t.errorf("GetValidOTPByPurpose(%s) returned %s, want most recent %s", recipient, found.ID, newer.ID)
+		}
+	}
+
+	if _, err := t.store.GetValidOTPByPurpose(t.ctx, older.MobileNumber, "transaction"); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("GetValidOTPByPurpose with other purpose error = %v, want otp.ErrNotFound", err)
+	}
+
+	for _, record := range []*otp.OTP{older, newer} {
+		if err := t.store.UpdateOTPStatus(t.ctx, record.ID, otp.OTPStatusVerified); err != nil {
+			t.errorf("UpdateOTPStatus: %v", err)
+		}
+	}
+	if _, err := t.store.GetValidOTPByPurpose(t.ctx, older.MobileNumber, "register"); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("GetValidOTPByPurpose returned a non-pending OTP, error = %v", err)
+	}
+}
+
+func (t *storeTester) testCopies() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	record.Status = otp.OTPStatusUsed
+	found := t.get(record.ID)
+	if found == nil {
+		return
+	}
+	if found.Status != otp.OTPStatusPending {
+		t.errorf("mutating the saved record changed the stored copy")
+	}
+	found.Status = otp.OTPStatusUsed
+	if again := t.get(record.ID); again != nil && again.Status != otp.OTPStatusPending {
+		t.errorf("mutating a returned record changed the stored copy")
+	}
+}
+
+func (t *storeTester) testConsume() {
+	now := time.Now()
+
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	if err := t.store.ConsumeOTP(t.ctx, record.ID, otp.OTPStatusVerified, now); err != nil {
+		t.errorf("ConsumeOTP(pending): %v", err)
+	}
+	if found := t.get(record.ID); found != nil && found.Status != otp.OTPStatusVerified {
+		t.errorf("status after ConsumeOTP = %s, want %s", found.Status, otp.OTPStatusVerified)
+	}
+	if err := t.store.ConsumeOTP(t.ctx, record.ID, otp.OTPStatusVerified, now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("second ConsumeOTP error = %v, want otp.ErrNoLongerValid", err)
+	}
+
+	expired := newOTP("login")
+	if t.save(expired) {
+		if err := t.store.ConsumeOTP(t.ctx, expired.ID, otp.OTPStatusVerified, expired.ExpiresAt.Add(time.Second)); !errors.Is(err, otp.ErrNoLongerValid) {
+			t.errorf("ConsumeOTP after expiry error = %v, want otp.ErrNoLongerValid", err)
+		}
+		if found := t.get(expired.ID); found != nil && found.Status != otp.OTPStatusPending {
+			t.errorf("rejected ConsumeOTP changed status to %s", found.Status)
+		}
+	}
+
+	if err := t.store.ConsumeOTP(t.ctx, uuid.New(), otp.OTPStatusVerified, now); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("ConsumeOTP(missing) error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+func (t *storeTester) testExpire() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	if err := t.store.ExpireOTP(t.ctx, record.ID); err != nil {
+		t.errorf("ExpireOTP(pending): %v", err)
+	}
+	if found := t.get(record.ID); found != nil && found.Status != otp.OTPStatusExpired {
+		t.errorf("status after ExpireOTP = %s, want %s", found.Status, otp.OTPStatusExpired)
+	}
+	if err := t.store.ExpireOTP(t.ctx, record.ID); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("second ExpireOTP error = %v, want otp.ErrNoLongerValid", err)
+	}
+
+	verified := newOTP("login")
+	if t.save(verified) {
+		if err := t.store.ConsumeOTP(t.ctx, verified.ID, otp.OTPStatusVerified, time.Now()); err != nil {
+			t.errorf("ConsumeOTP: %v", err)
+		}
+		if err := t.store.ExpireOTP(t.ctx, verified.ID); !errors.Is(err, otp.ErrNoLongerValid) {
+			t.errorf("ExpireOTP(verified) error = %v, want otp.ErrNoLongerValid", err)
+		}
+		if found := t.get(verified.ID); found != nil && found.Status != otp.OTPStatusVerified {
+			t.errorf("rejected ExpireOTP changed status to %s", found.Status)
+		}
+	}
+
+	if err := t.store.ExpireOTP(t.ctx, uuid.New()); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("ExpireOTP(missing) error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+func (t *storeTester) testFailedAttempts() {
+	now := time.Now()
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	for attempt := 1; attempt <= record.RetryLimit; attempt++ {
+		updated, err := t.store.RecordFailedAttempt(t.ctx, record.ID, now)
+		if err != nil {
+			t.errorf("RecordFailedAttempt #%d: %v", attempt, err)
+			return
+		}
+		if updated.RetryCount != attempt {
+			t.errorf("RetryCount after attempt #%d = %d", attempt, updated.RetryCount)
+		}
+		wantStatus := otp.OTPStatusPending
+		if attempt == record.RetryLimit {
+			wantStatus = otp.OTPStatusExpired
+		}
+		if updated.Status != wantStatus {
+			t.errorf("status after attempt #%d = %s, want %s", attempt, updated.Status, wantStatus)
+		}
+	}
+	if _, err := t.store.RecordFailedAttempt(t.ctx, record.ID, now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("RecordFailedAttempt past the limit error = %v, want otp.ErrNoLongerValid", err)
+	}
+	if err := t.store.ConsumeOTP(t.ctx, record.ID, otp.OTPStatusVerified, now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("ConsumeOTP after the limit error = %v, want otp.ErrNoLongerValid", err)
+	}
+}
+
+func (t *storeTester) testResend() {
+	now := time.Now()
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	for resend := 1; resend <= record.MaxResends; resend++ {
+		hashed := fmt.Sprintf("$bcrypt$cost=4$resend-%d", resend)
+		updated, err := t.store.RecordResend(t.ctx, record.ID, resend-1, hashed, "EMAIL", now)
+		if err != nil {
+			t.errorf("RecordResend #%d: %v", resend, err)
+			return
+		}
+		if updated.ResendCount != resend || updated.HashedOTP != hashed || updated.Delivery != "EMAIL" {
+			t.errorf("after RecordResend #%d got ResendCount %d, HashedOTP %q, Delivery %q",
+				resend, updated.ResendCount, updated.HashedOTP, updated.Delivery)
+		}
+		if updated.LastSentAt.Sub(now).Abs() > time.Second {
+			t.errorf("LastSentAt after RecordResend #%d = %v, want %v", resend, updated.LastSentAt, now)
+		}
+	}
+	if _, err := t.store.RecordResend(t.ctx, record.ID, record.MaxResends, "$bcrypt$cost=4$over", "SMS", now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("RecordResend past MaxResends error = %v, want otp.ErrNoLongerValid", err)
+	}
+
+	stale := newOTP("login")
+	if t.save(stale) {
+		if _, err := t.store.RecordResend(t.ctx, stale.ID, 1, "$bcrypt$cost=4$stale", "SMS", now); !errors.Is(err, otp.ErrNoLongerValid) {
+			t.errorf("RecordResend with a stale resend count error = %v, want otp.ErrNoLongerValid", err)
+		}
+		if found := t.get(stale.ID); found != nil && (found.ResendCount != 0 || found.HashedOTP != stale.HashedOTP) {
+			t.errorf("rejected RecordResend changed the record")
+		}
+	}
+
+	if _, err := t.store.RecordResend(t.ctx, uuid.New(), 0, "$bcrypt$cost=4$missing", "SMS", now); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("RecordResend(missing) error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+func (t *storeTester) testRevertResend() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	previous := t.get(record.ID)
+	if previous == nil {
+		return
+	}
+	updated, err := t.store.RecordResend(t.ctx, record.ID, 0, "$bcrypt$cost=4$undelivered", "EMAIL", time.Now())
+	if err != nil {
+		t.errorf("RecordResend: %v", err)
+		return
+	}
+	if err := t.store.RevertResend(t.ctx, record.ID, updated.ResendCount, previous); err != nil {
+		t.errorf("RevertResend: %v", err)
+		return
+	}
+	found := t.get(record.ID)
+	if found == nil {
+		return
+	}
+	if found.HashedOTP != previous.HashedOTP || found.Delivery != previous.Delivery ||
+		found.ResendCount != previous.ResendCount || !found.LastSentAt.Equal(previous.LastSentAt) {
+		t.errorf("after RevertResend got HashedOTP %q, Delivery %q, ResendCount %d, LastSentAt %v; want %q, %q, %d, %v",
+			found.HashedOTP, found.Delivery, found.ResendCount, found.LastSentAt,
+			previous.HashedOTP, previous.Delivery, previous.ResendCount, previous.LastSentAt)
+	}
+
+	// A resend that happened in between wins over the revert.
+	if _, err := t.store.RecordResend(t.ctx, record.ID, 0, "$bcrypt$cost=4$second", "SMS", time.Now()); err != nil {
+		t.errorf("RecordResend after RevertResend: %v", err)
+		return
+	}
+	if err := t.store.RevertResend(t.ctx, record.ID, 2, previous); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.errorf("RevertResend with a stale resend count error = %v, want otp.ErrNoLongerValid", err)
+	}
+	if found := t.get(record.ID); found != nil && found.HashedOTP != "$bcrypt$cost=4$second" {
+		t.errorf("rejected RevertResend changed the record")
+	}
+	if err := t.store.RevertResend(t.ctx, uuid.New(), 1, previous); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("RevertResend(missing) error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+// testConcurrentConsume checks that exactly one of many parallel
+// verifications of the same OTP wins.
+func (t *storeTester) testConcurrentConsume() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+
+	now := time.Now()
+	var wins, losses int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := t.store.ConsumeOTP(t.ctx, record.ID, otp.OTPStatusVerified, now)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				wins++
+			case errors.Is(err, otp.ErrNoLongerValid):
+				losses++
+			default:
+				t.errorf("concurrent ConsumeOTP: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if wins != 1 {
+		t.errorf("%d of %d concurrent ConsumeOTP calls succeeded, want exactly 1", wins, Parallelism)
+	}
+	if wins+losses != Parallelism {
+		t.errorf("%d concurrent ConsumeOTP calls neither won nor lost", Parallelism-wins-losses)
+	}
+}
+
+// testConcurrentVerifyAndExpire races verifications against expiries of the
+// same OTP: exactly one call wins and the stored status is the winner's.
+func (t *storeTester) testConcurrentVerifyAndExpire() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+
+	now := time.Now()
+	var winners []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < Parallelism; i++ {
+		status := otp.OTPStatusVerified
+		if i%2 == 1 {
+			status = otp.OTPStatusExpired
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var err error
+			if status == otp.OTPStatusVerified {
+				err = t.store.ConsumeOTP(t.ctx, record.ID, otp.OTPStatusVerified, now)
+			} else {
+				err = t.store.ExpireOTP(t.ctx, record.ID)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				winners = append(winners, status)
+			case !errors.Is(err, otp.ErrNoLongerValid):
+				t.errorf("concurrent set to %s: %v", status, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.errorf("%d of %d concurrent ConsumeOTP and ExpireOTP calls succeeded, want exactly 1", len(winners), Parallelism)
+		return
+	}
+	if found := t.get(record.ID); found != nil && found.Status != winners[0] {
+		t.errorf("status after the race = %s, want the winner's %s", found.Status, winners[0])
+	}
+}
+
+// testConcurrentFailedAttempts checks that parallel wrong guesses cannot
+// exceed the retry limit.
+func (t *storeTester) testConcurrentFailedAttempts() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+
+	now := time.Now()
+	var recorded int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := t.store.RecordFailedAttempt(t.ctx, record.ID, now)
+			if err != nil && !errors.Is(err, otp.ErrNoLongerValid) {
+				t.errorf("concurrent RecordFailedAttempt: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				recorded++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if recorded != record.RetryLimit {
+		t.errorf("%d concurrent failed attempts were recorded, want the retry limit %d", recorded, record.RetryLimit)
+	}
+	if found := t.get(record.ID); found != nil {
+		if found.RetryCount != record.RetryLimit || found.Status != otp.OTPStatusExpired {
+			t.errorf("after concurrent failed attempts RetryCount = %d, Status = %s; want %d, %s",
+				found.RetryCount, found.Status, record.RetryLimit, otp.OTPStatusExpired)
+		}
+	}
+}
+
+func (t *storeTester) testCanceledContext() {
+	record := newOTP("login")
+	if !t.save(record) {
+		return
+	}
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+
+	if _, err := t.store.GetOTPByID(ctx, record.ID); !errors.Is(err, context.Canceled) {
+		t.errorf("GetOTPByID with canceled context error = %v, want context.Canceled", err)
+	}
+	if err := t.store.ConsumeOTP(ctx, record.ID, otp.OTPStatusVerified, time.Now()); !errors.Is(err, context.Canceled) {
+		t.errorf("ConsumeOTP with canceled context error = %v, want context.Canceled", err)
+	}
+	if found := t.get(record.ID); found != nil && found.Status != otp.OTPStatusPending {
+		t.errorf("ConsumeOTP with canceled context changed status to %s", found.Status)
+	}
+}
+
+func (t *storeTester) testPayloadRotation(store otp.PayloadRotationStore) {
+	plain, old, current, none := newOTP("transaction"), newOTP("transaction"), newOTP("transaction"), newOTP("login")
+	plain.TransactionPayload = "e30="
+	old.TransactionPayload, old.PayloadKeyID = "$aes-256-gcm$old$data", "old"
+	current.TransactionPayload, current.PayloadKeyID = "$aes-256-gcm$current$data", "current"
+	for _, record := range []*otp.OTP{plain, old, current, none} {
+		if !t.save(record) {
+			return
+		}
+	}
+
+	want := map[uuid.UUID]bool{plain.ID: true, old.ID: true}
+	found := make(map[uuid.UUID]bool)
+	after := uuid.Nil
+	for {
+		records, err := store.ListPayloadsToRotate(t.ctx, "current", after, 2)
+		if err != nil {
+			t.errorf("ListPayloadsToRotate: %v", err)
+			return
+		}
+		if len(records) == 0 {
+			break
+		}
+		if len(records) > 2 {
+			t.errorf("ListPayloadsToRotate returned %d records, want at most the limit 2", len(records))
+		}
+		for _, record := range records {
+			if record.ID.String() <= after.String() {
+				t.errorf("ListPayloadsToRotate returned %s out of order after %s", record.ID, after)
+			}
+			if record.TransactionPayload == "" || record.PayloadKeyID == "current" {
+				t.errorf("ListPayloadsToRotate returned %s, which needs no rotation", record.ID)
+			}
+			found[record.ID] = true
+			after = record.ID
+		}
+	}
+	for id := range want {
+		if !found[id] {
+			t.errorf("ListPayloadsToRotate did not return %s", id)
+		}
+	}
+	if found[current.ID] || found[none.ID] {
+		t.errorf("ListPayloadsToRotate returned a record that needs no rotation")
+	}
+
+	if updated, err := store.UpdatePayload(t.ctx, old.ID, "stale", "current", "$aes-256-gcm$new$data"); err != nil || updated {
+		t.errorf("UpdatePayload with a stale key ID = %v, %v; want false, nil", updated, err)
+	}
+	if updated, err := store.UpdatePayload(t.ctx, old.ID, "old", "current", "$aes-256-gcm$new$data"); err != nil || !updated {
+		t.errorf("UpdatePayload = %v, %v; want true, nil", updated, err)
+	}
+	if record := t.get(old.ID); record != nil && (record.PayloadKeyID != "current" || record.TransactionPayload != "$aes-256-gcm$new$data") {
+		t.errorf("after UpdatePayload got key ID %q, payload %q", record.PayloadKeyID, record.TransactionPayload)
+	}
+	if updated, err := store.UpdatePayload(t.ctx, plain.ID, "", "current", "$aes-256-gcm$plain$data"); err != nil || !updated {
+		t.errorf("UpdatePayload of an unencrypted payload = %v, %v; want true, nil", updated, err)
+	}
+}
+
+func (t *storeTester) testMaintenance(store otp.MaintenanceStore) {
+	overdue, fresh := newOTP("login"), newOTP("login")
+	overdue.ExpiresAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+	if !t.save(overdue) || !t.save(fresh) {
+		return
+	}
+	found := false
+	for {
+		expired, err := store.ExpireOverdue(t.ctx, time.Now(), 100)
+		if err != nil {
+			t.errorf("ExpireOverdue: %v", err)
+			return
+		}
+		for _, record := range expired {
+			if record.Status != otp.OTPStatusExpired {
+				t.errorf("ExpireOverdue returned %s with status %q, want %q", record.ID, record.Status, otp.OTPStatusExpired)
+			}
+			if record.ID == fresh.ID {
+				t.errorf("ExpireOverdue returned an unexpired OTP")
+			}
+			found = found || record.ID == overdue.ID
+		}
+		if len(expired) < 100 {
+			break
+		}
+	}
+	if !found {
+		t.errorf("ExpireOverdue did not return the overdue OTP")
+	}
+	// Stores may already hide records once they expire.
+	record, err := t.store.GetOTPByID(t.ctx, overdue.ID)
+	switch {
+	case err != nil && !errors.Is(err, otp.ErrNotFound):
+		t.errorf("GetOTPByID(%s): %v", overdue.ID, err)
+	case err == nil && record.Status != otp.OTPStatusExpired:
+		t.errorf("after ExpireOverdue overdue OTP has status %q, want %q", record.Status, otp.OTPStatusExpired)
+	}
+	if record := t.get(fresh.ID); record != nil && record.Status != otp.OTPStatusPending {
+		t.errorf("ExpireOverdue changed an unexpired OTP to %q", record.Status)
+	}
+
+	// A status of its own keeps records of other checks out of the batches.
+	status := "test-" + uuid.NewString()[:8]
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		record := newOTP("login")
+		record.Status = status
+		if !t.save(record) {
+			return
+		}
+		ids = append(ids, record.ID)
+	}
+	if records, err := store.ListPurgeable(t.ctx, status, time.Now().Add(-time.Hour), 10); err != nil || len(records) != 0 {
+		t.errorf("ListPurgeable before the records' creation = %d records, %v; want none", len(records), err)
+	}
+	records, err := store.ListPurgeable(t.ctx, status, time.Now().Add(time.Minute), 2)
+	if err != nil {
+		t.errorf("ListPurgeable: %v", err)
+		return
+	}
+	if len(records) != 2 {
+		t.errorf("ListPurgeable returned %d records, want the limit 2", len(records))
+	}
+	for _, record := range records {
+		if record.Status != status {
+			t.errorf("ListPurgeable returned status %q, want %q", record.Status, status)
+		}
+	}
+
+	if deleted, err := store.DeleteOTPs(t.ctx, append(ids, uuid.New())); err != nil || deleted != len(ids) {
+		t.errorf("DeleteOTPs = %d, %v; want %d, nil", deleted, err, len(ids))
+	}
+	for _, id := range ids {
+		if _, err := t.store.GetOTPByID(t.ctx, id); !errors.Is(err, otp.ErrNotFound) {
+			t.errorf("GetOTPByID after DeleteOTPs: got %v, want ErrNotFound", err)
+		}
+	}
+	if deleted, err := store.DeleteOTPs(t.ctx, nil); err != nil || deleted != 0 {
+		t.errorf("DeleteOTPs with no IDs = %d, %v; want 0, nil", deleted, err)
+	}
+}
+
+func (t *storeTester) testRecipientData(store otp.RecipientDataStore) {
+	pending, used, other := newOTP("login"), newOTP("transaction"), newOTP("login")
+	used.MobileNumber, used.Email = pending.MobileNumber, "other-"+pending.Email
+	used.TransactionPayload, used.PayloadDigest, used.PayloadKeyID = "$aes-256-gcm$key$data", "digest", "key"
+	used.SMSBody = "Approve <amount> with <otp>"
+	for _, record := range []*otp.OTP{pending, used, other} {
+		if !t.save(record) {
+			return
+		}
+	}
+	if err := t.store.UpdateOTPStatus(t.ctx, used.ID, otp.OTPStatusUsed); err != nil {
+		t.errorf("UpdateOTPStatus: %v", err)
+		return
+	}
+
+	for _, recipient := range []string{pending.MobileNumber, pending.Email} {
+		records, err := store.ListByRecipient(t.ctx, recipient)
+		if err != nil {
+			t.errorf("ListByRecipient(%q): %v", recipient, err)
+			return
+		}
+		found := make(map[uuid.UUID]bool)
+		for _, record := range records {
+			found[record.ID] = true
+		}
+		if !found[pending.ID] || found[other.ID] {
+			t.errorf("ListByRecipient(%q) = %d records, want %s and not %s", recipient, len(records), pending.ID, other.ID)
+		}
+		if recipient == pending.MobileNumber && !found[used.ID] {
+			t.errorf("ListByRecipient(%q) did not return used OTP %s", recipient, used.ID)
+		}
+	}
+
+	for _, record := range []*otp.OTP{pending, used} {
+		if err := store.RedactOTP(t.ctx, record.ID, "anon:mobile", ""); err != nil {
+			t.errorf("RedactOTP: %v", err)
+			return
+		}
+	}
+	if record := t.get(pending.ID); record != nil {
+		if record.MobileNumber != "anon:mobile" || record.Email != "" {
+			t.errorf("after RedactOTP got mobile number %q, email %q", record.MobileNumber, record.Email)
+		}
+		if record.Status != otp.OTPStatusExpired {
+			t.errorf("after RedactOTP pending OTP has status %q, want %q", record.Status, otp.OTPStatusExpired)
+		}
+	}
+	if record := t.get(used.ID); record != nil {
+		if record.TransactionPayload != "" || record.PayloadDigest != "" || record.PayloadKeyID != "" || record.SMSBody != "" {
+			t.errorf("RedactOTP kept the payload or message of %s", used.ID)
+		}
+		if record.Status != otp.OTPStatusUsed || record.Purpose != "transaction" {
+			t.errorf("RedactOTP changed status %q or purpose %q of %s", record.Status, record.Purpose, used.ID)
+		}
+	}
+	if records, err := store.ListByRecipient(t.ctx, pending.MobileNumber); err != nil || len(records) != 0 {
+		t.errorf("ListByRecipient after RedactOTP = %d records, %v; want none", len(records), err)
+	}
+	if _, err := t.store.GetValidOTPByPurpose(t.ctx, pending.Email, "login"); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("GetValidOTPByPurpose after RedactOTP: got %v, want ErrNotFound", err)
+	}
+	if err := store.RedactOTP(t.ctx, uuid.New(), "", ""); !errors.Is(err, otp.ErrNotFound) {
+		t.errorf("RedactOTP of a missing record: got %v, want ErrNotFound", err)
+	}
+}
+
+func (t *storeTester) testOutbox(store otp.OutboxStore) {
+	// Messages due at a random time in the past keep other pending
+	// messages in the store out of the claims.
+	due := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(uuid.New().ID()%1e6) * time.Second)
+	record := newOTP("login")
+	message := &otp.OutboxMessage{
+		ID:            uuid.New(),
+		Mode:          otp.RouteFallback,
+		Channels:      otp.DeliverySMS,
+		SealedCode:    "$aes-256-gcm$key$data",
+		KeyID:         "key",
+		Status:        otp.OutboxPending,
+		NextAttemptAt: due,
+	}
+	if err := store.SaveOTPWithMessage(t.ctx, record, message); err != nil {
+		t.errorf("SaveOTPWithMessage: %v", err)
+		return
+	}
+	if message.OTPID != record.ID {
+		t.errorf("SaveOTPWithMessage set message OTP ID %s, want %s", message.OTPID, record.ID)
+	}
+	if t.get(record.ID) == nil {
+		return
+	}
+
+	claim := func(now time.Time) *otp.OutboxMessage {
+		messages, err := store.ClaimMessages(t.ctx, now, time.Minute, 100)
+		if err != nil {
+			t.errorf("ClaimMessages: %v", err)
+			return nil
+		}
+		for _, claimed := range messages {
+			if claimed.ID == message.ID {
+				return claimed
+			}
+		}
+		return nil
+	}
+	if claimed := claim(due.Add(-time.Second)); claimed != nil {
+		t.errorf("ClaimMessages claimed a message before it was due")
+	}
+	claimed := claim(due)
+	if claimed == nil {
+		t.errorf("ClaimMessages did not claim a due message")
+		return
+	}
+	if claimed.Attempts != 1 || !claimed.NextAttemptAt.Equal(due.Add(time.Minute)) {
+		t.errorf("claimed message has %d attempts, next at %s; want 1, %s", claimed.Attempts, claimed.NextAttemptAt, due.Add(time.Minute))
+	}
+	if claimed.SealedCode != message.SealedCode || claimed.OTPID != record.ID {
+		t.errorf("claimed message lost its code or OTP ID")
+	}
+	if again := claim(due.Add(30 * time.Second)); again != nil {
+		t.errorf("ClaimMessages claimed a message during its lease")
+	}
+
+	claimed.Status, claimed.SealedCode = otp.OutboxSent, ""
+	if err := store.UpdateMessage(t.ctx, claimed); err != nil {
+		t.errorf("UpdateMessage: %v", err)
+		return
+	}
+	if record := t.get(record.ID); record != nil && record.DeliveryStatus != otp.OutboxSent {
+		t.errorf("after UpdateMessage OTP has delivery status %q, want %q", record.DeliveryStatus, otp.OutboxSent)
+	}
+	if again := claim(due.Add(2 * time.Minute)); again != nil {
+		t.errorf("ClaimMessages claimed a sent message")
+	}
+}
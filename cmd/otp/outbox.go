@@ -0,0 +1,403 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultOutboxWorkers      = 4
+	DefaultOutboxBatchSize    = 50
+	DefaultOutboxPollInterval = time.Second
+	DefaultOutboxMaxAttempts  = 5
+	DefaultOutboxBaseBackoff  = 2 * time.Second
+	DefaultOutboxMaxBackoff   = 5 * time.Minute
+	DefaultOutboxLease        = time.Minute
+)
+
+// Statuses of outbox messages, also stored as the DeliveryStatus of their
+// OTP. OTPs delivered inline have no delivery status.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	// OutboxDead marks messages that exhausted their attempts.
+	OutboxDead = "dead"
+	// OutboxCanceled marks messages whose OTP was verified, expired or
+	// re-sent before they were delivered.
+	OutboxCanceled = "canceled"
+)
+
+// OutboxMessage is a queued delivery of an OTP code. The code is sealed
+// with the payload keyring and cleared once the message is settled.
+type OutboxMessage struct {
+	ID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OTPID uuid.UUID `gorm:"type:uuid;not null;index"`
+	// ResendCount is the OTP's resend count when the message was queued;
+	// a later resend supersedes the message.
+	ResendCount int         `gorm:"not null;default:0"`
+	Mode        RoutingMode `gorm:"type:varchar(20);not null"`
+	// Channels is the comma-separated list of channels to route through.
+	Channels      string    `gorm:"type:varchar(100);not null"`
+	SealedCode    string    `gorm:"type:text;not null"`
+	KeyID         string    `gorm:"type:varchar(64);not null"`
+	Status        string    `gorm:"type:varchar(20);not null"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null"`
+	LastError     string    `gorm:"type:text"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (OutboxMessage) TableName() string {
+	return "otp_outbox"
+}
+
+// OutboxStore is implemented by stores that can queue deliveries in the
+// same transaction as the OTP they belong to.
+type OutboxStore interface {
+	// SaveOTPWithMessage saves record and message atomically.
+	SaveOTPWithMessage(ctx context.Context, record *OTP, message *OutboxMessage) error
+	// ClaimMessages returns up to limit pending messages due at now, counts
+	// an attempt for each and defers them until now+lease, so concurrent
+	// workers skip them and a crashed worker's messages are retried once
+	// the lease runs out.
+	ClaimMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxMessage, error)
+	// UpdateMessage stores message's status, attempts, next attempt, last
+	// error and sealed code, and sets the DeliveryStatus of its OTP to the
+	// message's status.
+	UpdateMessage(ctx context.Context, message *OutboxMessage) error
+}
+
+// WithOutbox makes Send queue deliveries in store, in the same transaction
+// as the OTP, for an OutboxWorker to deliver. Codes are sealed with the
+// payload keyring, which must be configured. ResendOTP still delivers
+// inline.
+func WithOutbox(store OutboxStore) Option {
+	return func(s *OTPService) {
+		s.outbox = store
+	}
+}
+
+// newOutboxMessage seals code for otp and builds its pending message.
+func (s *OTPService) newOutboxMessage(otp *OTP, code string, mode RoutingMode, channels []string) (*OutboxMessage, error) {
+	if s.keyring == nil {
+		return nil, errors.New("outbox delivery requires a payload keyring to seal codes")
+	}
+	keyID, sealed, err := s.keyring.Seal(otp.ID, []byte(code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal OTP: %w", err)
+	}
+	return &OutboxMessage{
+		ID:            uuid.New(),
+		OTPID:         otp.ID,
+		ResendCount:   otp.ResendCount,
+		Mode:          mode,
+		Channels:      strings.Join(channels, ","),
+		SealedCode:    sealed,
+		KeyID:         keyID,
+		Status:        OutboxPending,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
+
+type OutboxConfig struct {
+	// Workers is how many messages are delivered concurrently;
+	// DefaultOutboxWorkers if zero.
+	Workers int
+	// BatchSize is how many messages are claimed at once;
+	// DefaultOutboxBatchSize if zero.
+	BatchSize int
+	// PollInterval is the wait after a run that found no full batch;
+	// DefaultOutboxPollInterval if zero.
+	PollInterval time.Duration
+	// MaxAttempts is how many times a message is tried before it is
+	// dead-lettered; DefaultOutboxMaxAttempts if zero.
+	MaxAttempts int
+	// BaseBackoff is the delay after the first failed attempt, doubled
+	// after each further one up to MaxBackoff, with jitter;
+	// DefaultOutboxBaseBackoff and DefaultOutboxMaxBackoff if zero.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Lease is how long a claimed message is hidden from other workers;
+	// DefaultOutboxLease if zero. It must exceed the time providers take.
+	Lease time.Duration
+}
+
+// OutboxStats counts the messages an OutboxWorker settled so far.
+type OutboxStats struct {
+	Sent     int
+	Retried  int
+	Dead     int
+	Canceled int
+	// Failures counts store errors; LastError is the latest of them.
+	Failures  int
+	LastError error
+}
+
+// OutboxWorker delivers queued messages with a pool of goroutines,
+// retrying failures with exponential backoff. Several workers, also in
+// different processes, may share a store.
+type OutboxWorker struct {
+	service *OTPService
+	store   OutboxStore
+	config  OutboxConfig
+
+	mu      sync.Mutex
+	stats   OutboxStats
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running bool
+}
+
+// NewOutboxWorker creates a stopped worker delivering the messages in store
+// with the providers of service.
+func NewOutboxWorker(service *OTPService, store OutboxStore, config OutboxConfig) *OutboxWorker {
+	if config.Workers <= 0 {
+		config.Workers = DefaultOutboxWorkers
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultOutboxBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultOutboxPollInterval
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultOutboxMaxAttempts
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultOutboxBaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultOutboxMaxBackoff
+	}
+	if config.Lease <= 0 {
+		config.Lease = DefaultOutboxLease
+	}
+	return &OutboxWorker{service: service, store: store, config: config}
+}
+
+// Start delivers messages in the background until Stop is called. Starting
+// a running worker does nothing.
+func (w *OutboxWorker) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel, w.done, w.running = cancel, make(chan struct{}), true
+
+	go func(done chan struct{}) {
+		defer close(done)
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+			// A full batch suggests more are waiting.
+			if claimed, err := w.RunOnce(ctx); err == nil && claimed == w.config.BatchSize {
+				timer.Reset(0)
+			} else {
+				timer.Reset(w.config.PollInterval)
+			}
+		}
+	}(w.done)
+}
+
+// Stop cancels running deliveries and waits for the worker to finish, or
+// for ctx to be done. Canceled deliveries are retried later.
+func (w *OutboxWorker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.running {
+		w.mu.Unlock()
+		return nil
+	}
+	cancel, done := w.cancel, w.done
+	w.running = false
+	w.mu.Unlock()
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the counts of settled messages so far.
+func (w *OutboxWorker) Stats() OutboxStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// RunOnce claims one batch of due messages, delivers them and returns how
+// many were claimed.
+func (w *OutboxWorker) RunOnce(ctx context.Context) (int, error) {
+	messages, err := w.store.ClaimMessages(ctx, time.Now(), w.config.Lease, w.config.BatchSize)
+	if err != nil {
+		err = fmt.Errorf("failed to claim outbox messages: %w", err)
+		w.recordFailure(err)
+		return 0, err
+	}
+
+	queue := make(chan *OutboxMessage)
+	var wg sync.WaitGroup
+	for i := 0; i < min(w.config.Workers, len(messages)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range queue {
+				w.process(ctx, message)
+			}
+		}()
+	}
+	for _, message := range messages {
+		queue <- message
+	}
+	close(queue)
+	wg.Wait()
+	return len(messages), nil
+}
+
+// process delivers message and records the outcome.
+func (w *OutboxWorker) process(ctx context.Context, message *OutboxMessage) {
+	s := w.service
+	otp, err := s.repo.GetOTPByID(ctx, message.OTPID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.settle(ctx, message, OutboxCanceled, nil)
+		return
+	case err != nil:
+		w.retry(ctx, message, fmt.Errorf("failed to load OTP: %w", err))
+		return
+	}
+	if otp.Status != OTPStatusPending || !time.Now().Before(otp.ExpiresAt) || otp.ResendCount != message.ResendCount {
+		w.settle(ctx, message, OutboxCanceled, nil)
+		return
+	}
+
+	if s.keyring == nil {
+		w.settle(ctx, message, OutboxDead, errors.New("no payload keyring to open the code with"))
+		return
+	}
+	code, err := s.keyring.Open(otp.ID, message.KeyID, message.SealedCode)
+	if err != nil {
+		w.settle(ctx, message, OutboxDead, fmt.Errorf("failed to open code: %w", err))
+		return
+	}
+
+	if _, err := s.deliver(ctx, otp, string(code), message.Mode, strings.Split(message.Channels, ",")); err != nil {
+		w.retry(ctx, message, err)
+		return
+	}
+	w.settle(ctx, message, OutboxSent, nil)
+}
+
+// retry schedules message for another attempt after cause, or
+// dead-letters it if it has none left.
+func (w *OutboxWorker) retry(ctx context.Context, message *OutboxMessage, cause error) {
+	if ctx.Err() != nil {
+		// Stopped mid-delivery: give the attempt back.
+		message.Attempts--
+		message.NextAttemptAt = time.Now()
+		w.update(ctx, message)
+		return
+	}
+	if message.Attempts >= w.config.MaxAttempts {
+		w.settle(ctx, message, OutboxDead, cause)
+		return
+	}
+	message.NextAttemptAt = time.Now().Add(w.backoff(message.Attempts))
+	message.LastError = cause.Error()
+	if w.update(ctx, message) {
+		w.count(func(stats *OutboxStats) { stats.Retried++ })
+	}
+}
+
+// settle gives message its final status and clears its code.
+func (w *OutboxWorker) settle(ctx context.Context, message *OutboxMessage, status string, cause error) {
+	message.Status = status
+	message.SealedCode = ""
+	if cause != nil {
+		message.LastError = cause.Error()
+	}
+	if !w.update(ctx, message) {
+		return
+	}
+	switch status {
+	case OutboxSent:
+		w.count(func(stats *OutboxStats) { stats.Sent++ })
+	case OutboxCanceled:
+		w.count(func(stats *OutboxStats) { stats.Canceled++ })
+	case OutboxDead:
+		w.count(func(stats *OutboxStats) { stats.Dead++ })
+		w.service.logger.ErrorContext(ctx, "OTP delivery dead-lettered",
+			"otp_ref", message.OTPID, "attempts", message.Attempts, "error", message.LastError)
+		w.expireUndelivered(ctx, message)
+	}
+}
+
+// expireUndelivered expires the OTP of a dead-lettered message as Send does
+// when no channel delivers its code, unless a resend has superseded the
+// message.
+func (w *OutboxWorker) expireUndelivered(ctx context.Context, message *OutboxMessage) {
+	s := w.service
+	otp, err := s.repo.GetOTPByID(context.WithoutCancel(ctx), message.OTPID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.logger.WarnContext(ctx, "failed to expire undelivered OTP", "otp_ref", message.OTPID, "error", err)
+		}
+		return
+	}
+	if otp.Status != OTPStatusPending || otp.ResendCount != message.ResendCount {
+		return
+	}
+	s.expireUndelivered(ctx, otp)
+}
+
+func (w *OutboxWorker) update(ctx context.Context, message *OutboxMessage) bool {
+	// Record the outcome even when the worker is being stopped.
+	if err := w.store.UpdateMessage(context.WithoutCancel(ctx), message); err != nil {
+		w.recordFailure(fmt.Errorf("failed to update outbox message %s: %w", message.ID, err))
+		return false
+	}
+	return true
+}
+
+// backoff returns the delay before the attempt after attempts failed ones:
+// BaseBackoff doubled per earlier failure, capped at MaxBackoff, of which
+// the second half is random.
+func (w *OutboxWorker) backoff(attempts int) time.Duration {
+	d := w.config.BaseBackoff
+	for i := 1; i < attempts && d < w.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, w.config.MaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+func (w *OutboxWorker) count(apply func(stats *OutboxStats)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	apply(&w.stats)
+}
+
+func (w *OutboxWorker) recordFailure(err error) {
+	w.service.logger.Error("outbox worker failed", "error", err)
+	w.count(func(stats *OutboxStats) {
+		stats.Failures++
+		stats.LastError = err
+	})
+}
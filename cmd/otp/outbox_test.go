@@ -0,0 +1,56 @@
+package otp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func TestOutboxDeadLetterExpiresOTP(t *testing.T) {
+	repo := repository.NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { repo.Close() })
+	sms := &stubSMS{err: errors.New("provider down")}
+	sink := &memorySink{}
+	var expired []otp.LifecycleEvent
+	service := otp.NewOTPService(repo, sms, nil,
+		otp.WithHasher(otp.NewBcryptHasher(4)),
+		otp.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		otp.WithPayloadKeyring(newKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})),
+		otp.WithOutbox(repo),
+		otp.WithHooks(expiredHooks{events: &expired}),
+		otp.WithAuditLog(otp.NewAuditLog(sink)))
+	ctx := context.Background()
+
+	result, err := service.Send(ctx, sendRequest())
+	if err != nil || !result.Queued {
+		t.Fatalf("Send = %+v, %v", result, err)
+	}
+	worker := otp.NewOutboxWorker(service, repo, otp.OutboxConfig{MaxAttempts: 1})
+	if n, err := worker.RunOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("RunOnce = %d, %v", n, err)
+	}
+	if stats := worker.Stats(); stats.Dead != 1 {
+		t.Fatalf("stats = %+v, want 1 dead", stats)
+	}
+
+	record, err := repo.GetOTPByID(ctx, result.OTPRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != otp.OTPStatusExpired || record.DeliveryStatus != otp.OutboxDead {
+		t.Errorf("OTP status = %s, delivery %s; want expired and dead", record.Status, record.DeliveryStatus)
+	}
+	if len(expired) != 1 || expired[0].OTP.ID != result.OTPRef || expired[0].Reason != otp.CodeDeliveryFailed {
+		t.Errorf("expired events = %+v, want one with reason %s", expired, otp.CodeDeliveryFailed)
+	}
+	last := sink.events[len(sink.events)-1]
+	if last.Type != otp.AuditExpired || last.OTPID != result.OTPRef || last.Metadata["reason"] != string(otp.CodeDeliveryFailed) {
+		t.Errorf("last audit event = %+v, want the expiry", last)
+	}
+}
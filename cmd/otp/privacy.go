@@ -0,0 +1,203 @@
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErasureMode selects how EraseRecipientData removes a recipient.
+type ErasureMode string
+
+const (
+	// ErasureRedact blanks the recipient's mobile number and email.
+	ErasureRedact ErasureMode = "redact"
+	// ErasurePseudonymize replaces them with keyed pseudonyms, so records of
+	// the same recipient stay linkable without revealing who it was.
+	ErasurePseudonymize ErasureMode = "pseudonymize"
+)
+
+// pseudonymPrefix marks pseudonymized values. With the 15 characters of
+// the keyed hash they fit the 20-character mobile number column.
+const pseudonymPrefix = "anon:"
+
+var (
+	ErrPrivacyUnsupported = errors.New("store does not support privacy requests")
+	ErrNoPseudonymKey     = errors.New("no pseudonym key configured")
+)
+
+// RecipientExport is everything stored about a recipient, as returned by
+// ExportRecipientData.
+type RecipientExport struct {
+	Recipient  string            `json:"recipient"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Records    []RecipientRecord `json:"records"`
+}
+
+// RecipientRecord is an OTP record in a RecipientExport. Code hashes are
+// left out; transaction payloads are decrypted.
+type RecipientRecord struct {
+	ID           uuid.UUID              `json:"id"`
+	Purpose      string                 `json:"purpose"`
+	Delivery     string                 `json:"delivery"`
+	MobileNumber string                 `json:"mobile_number,omitempty"`
+	Email        string                 `json:"email,omitempty"`
+	Payload      map[string]interface{} `json:"payload,omitempty"`
+	SMSBody      string                 `json:"sms_body,omitempty"`
+	EmailSubject string                 `json:"email_subject,omitempty"`
+	EmailBody    string                 `json:"email_body,omitempty"`
+	Status       string                 `json:"status"`
+	RetryCount   int                    `json:"retry_count"`
+	ResendCount  int                    `json:"resend_count"`
+	LastSentAt   time.Time              `json:"last_sent_at"`
+	ExpiresAt    time.Time              `json:"expires_at"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// WithPseudonymKey sets the HMAC key used by ErasurePseudonymize. Without it
+// that mode is unavailable, since unkeyed hashes of phone numbers are easy
+// to reverse.
+func WithPseudonymKey(key []byte) Option {
+	return func(s *OTPService) {
+		s.pseudonymKey = key
+	}
+}
+
+// WithPrivacyStores adds stores holding copies of OTP records, such as an
+// archive, to those searched by ExportRecipientData and EraseRecipientData.
+// The service's own store is always included if it supports them.
+func WithPrivacyStores(stores ...RecipientDataStore) Option {
+	return func(s *OTPService) {
+		s.privacyStores = append(s.privacyStores, stores...)
+	}
+}
+
+func (s *OTPService) recipientStores() ([]RecipientDataStore, error) {
+	stores := s.privacyStores
+	if store, ok := s.repo.(RecipientDataStore); ok {
+		stores = append([]RecipientDataStore{store}, stores...)
+	}
+	if len(stores) == 0 {
+		return nil, ErrPrivacyUnsupported
+	}
+	return stores, nil
+}
+
+// ExportRecipientData returns every OTP record stored for recipient, a
+// mobile number or email.
+func (s *OTPService) ExportRecipientData(ctx context.Context, recipient string) (*RecipientExport, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return nil, newError(CodeInvalidRequest, "recipient is required", nil)
+	}
+	stores, err := s.recipientStores()
+	if err != nil {
+		return nil, err
+	}
+
+	export := &RecipientExport{Recipient: recipient, ExportedAt: time.Now(), Records: []RecipientRecord{}}
+	seen := make(map[uuid.UUID]bool)
+	for _, store := range stores {
+		records, err := store.ListByRecipient(ctx, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list OTPs: %w", err)
+		}
+		for _, record := range records {
+			if seen[record.ID] {
+				continue
+			}
+			seen[record.ID] = true
+			payload, err := openPayload(s.keyring, record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode payload of OTP %s: %w", record.ID, err)
+			}
+			export.Records = append(export.Records, RecipientRecord{
+				ID:           record.ID,
+				Purpose:      record.Purpose,
+				Delivery:     record.Delivery,
+				MobileNumber: record.MobileNumber,
+				Email:        record.Email,
+				Payload:      payload,
+				SMSBody:      record.SMSBody,
+				EmailSubject: record.EmailSubject,
+				EmailBody:    record.EmailBody,
+				Status:       record.Status,
+				RetryCount:   record.RetryCount,
+				ResendCount:  record.ResendCount,
+				LastSentAt:   record.LastSentAt,
+				ExpiresAt:    record.ExpiresAt,
+				CreatedAt:    record.CreatedAt,
+				UpdatedAt:    record.UpdatedAt,
+			})
+		}
+	}
+	return export, nil
+}
+
+// EraseRecipientData removes recipient from every record stored for it and
+// returns how many records were changed. Records are kept, with their
+// purpose, delivery method, status and counters, so aggregate statistics
+// are unaffected; their transaction payloads and message contents are
+// cleared, and pending OTPs are expired.
+func (s *OTPService) EraseRecipientData(ctx context.Context, recipient string, mode ErasureMode) (int, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return 0, newError(CodeInvalidRequest, "recipient is required", nil)
+	}
+	switch mode {
+	case ErasureRedact:
+	case ErasurePseudonymize:
+		if len(s.pseudonymKey) == 0 {
+			return 0, ErrNoPseudonymKey
+		}
+	default:
+		return 0, newError(CodeInvalidRequest, fmt.Sprintf("unknown erasure mode %q", mode), nil)
+	}
+	stores, err := s.recipientStores()
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, store := range stores {
+		records, err := store.ListByRecipient(ctx, recipient)
+		if err != nil {
+			return erased, fmt.Errorf("failed to list OTPs: %w", err)
+		}
+		for _, record := range records {
+			var mobileNumber, email string
+			if mode == ErasurePseudonymize {
+				mobileNumber = pseudonym(s.pseudonymKey, record.MobileNumber)
+				email = pseudonym(s.pseudonymKey, record.Email)
+			}
+			if err := store.RedactOTP(ctx, record.ID, mobileNumber, email); err != nil {
+				return erased, fmt.Errorf("failed to erase OTP %s: %w", record.ID, err)
+			}
+			erased++
+		}
+	}
+	return erased, nil
+}
+
+// pseudonym returns a stable keyed pseudonym for value, or "" for "". Emails
+// are compared case-insensitively.
+func pseudonym(key []byte, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.HasPrefix(value, pseudonymPrefix) {
+		return value
+	}
+	if strings.Contains(value, "@") {
+		value = strings.ToLower(value)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return pseudonymPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:15]
+}
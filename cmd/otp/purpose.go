@@ -0,0 +1,237 @@
+package otp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// PurposeResult selects what ValidateOTP returns for a verified code.
+type PurposeResult string
+
+const (
+	// ResultStatus returns {"status": "OTP verified"}.
+	ResultStatus PurposeResult = "status"
+	// ResultPayload returns the sanitized transaction payload.
+	ResultPayload PurposeResult = "payload"
+)
+
+// ErrUnknownPurpose is wrapped by the invalid_request error returned when
+// an OTP is requested for a purpose that is not registered.
+var ErrUnknownPurpose = errors.New("unknown OTP purpose")
+
+// PurposePolicy describes how OTPs for one purpose are issued and what
+// verifying them returns. Non-zero CodeLength, Expiry and RetryLimit take
+// precedence over the values in a request.
+type PurposePolicy struct {
+	Name       string
+	CodeLength int
+	// Alphabet is a name accepted by ResolveAlphabet; empty uses the
+	// service's code generator.
+	Alphabet   string
+	Expiry     time.Duration
+	RetryLimit int
+	// Channels lists the names of the allowed channels, e.g. DeliverySMS;
+	// empty allows all of them.
+	Channels []string
+	// Routing overrides the service's routing policy for the purpose.
+	Routing        RoutingPolicy
+	RequirePayload bool
+	// Template names the message template for the purpose; empty uses the
+	// template named after the purpose, or DefaultTemplate.
+	Template string
+	// Result defaults to ResultStatus.
+	Result PurposeResult
+}
+
+// AllowsChannel reports whether codes for the purpose may be delivered
+// through channel.
+func (p PurposePolicy) AllowsChannel(channel string) bool {
+	if len(p.Channels) == 0 {
+		return true
+	}
+	for _, allowed := range p.Channels {
+		if allowed == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPurposePolicies returns the built-in purposes: login and register
+// return a status, transaction requires a payload and returns it.
+func DefaultPurposePolicies() []PurposePolicy {
+	return []PurposePolicy{
+		{Name: "login", Result: ResultStatus},
+		{Name: "register", Result: ResultStatus},
+		{Name: "transaction", RequirePayload: true, Result: ResultPayload},
+	}
+}
+
+// PurposeRegistry holds the purposes OTPs may be issued for. It is safe for
+// concurrent use.
+type PurposeRegistry struct {
+	mu       sync.RWMutex
+	purposes map[string]purposeEntry
+}
+
+type purposeEntry struct {
+	policy    PurposePolicy
+	generator CodeGenerator
+}
+
+// NewPurposeRegistry creates a registry holding policies.
+func NewPurposeRegistry(policies ...PurposePolicy) (*PurposeRegistry, error) {
+	r := &PurposeRegistry{purposes: make(map[string]purposeEntry)}
+	for _, policy := range policies {
+		if err := r.Register(policy); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register validates policy and adds it, replacing any policy with the same
+// name.
+func (r *PurposeRegistry) Register(policy PurposePolicy) error {
+	if policy.Name == "" {
+		return errors.New("purpose policy has no name")
+	}
+	if policy.CodeLength < 0 || policy.Expiry < 0 || policy.RetryLimit < 0 {
+		return fmt.Errorf("purpose %q: negative code length, expiry or retry limit", policy.Name)
+	}
+	for _, channel := range policy.Channels {
+		if !validChannelName(channel) {
+			return fmt.Errorf("purpose %q: invalid delivery channel name %q", policy.Name, channel)
+		}
+	}
+	if !policy.Routing.IsZero() {
+		if err := policy.Routing.Validate(); err != nil {
+			return fmt.Errorf("purpose %q: %w", policy.Name, err)
+		}
+	}
+	switch policy.Result {
+	case "":
+		policy.Result = ResultStatus
+	case ResultStatus, ResultPayload:
+	default:
+		return fmt.Errorf("purpose %q: unsupported result %q", policy.Name, policy.Result)
+	}
+
+	entry := purposeEntry{policy: policy}
+	if policy.Alphabet != "" {
+		generator, err := NewCodeGenerator(ResolveAlphabet(policy.Alphabet))
+		if err != nil {
+			return fmt.Errorf("purpose %q: %w", policy.Name, err)
+		}
+		entry.generator = generator
+	}
+
+	r.mu.Lock()
+	r.purposes[policy.Name] = entry
+	r.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the policy registered for name.
+func (r *PurposeRegistry) Lookup(name string) (PurposePolicy, bool) {
+	entry, ok := r.lookup(name)
+	return entry.policy, ok
+}
+
+func (r *PurposeRegistry) lookup(name string) (purposeEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.purposes[name]
+	return entry, ok
+}
+
+// CheckGrouping returns an error naming the first purpose whose alphabet
+// shares a character with separator; see CheckGrouping.
+func (r *PurposeRegistry) CheckGrouping(separator string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, entry := range r.purposes {
+		if entry.policy.Alphabet == "" {
+			continue
+		}
+		if err := CheckGrouping(ResolveAlphabet(entry.policy.Alphabet), separator); err != nil {
+			return fmt.Errorf("purpose %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Names returns the registered purpose names in no particular order.
+func (r *PurposeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.purposes))
+	for name := range r.purposes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// purposePolicyJSON is the configuration file form of a PurposePolicy.
+type purposePolicyJSON struct {
+	Name           string        `json:"name"`
+	CodeLength     int           `json:"code_length"`
+	Alphabet       string        `json:"alphabet"`
+	ExpirySeconds  int           `json:"expiry_seconds"`
+	RetryLimit     int           `json:"retry_limit"`
+	Channels       []string      `json:"channels"`
+	Routing        *routingJSON  `json:"routing"`
+	RequirePayload bool          `json:"require_payload"`
+	Template       string        `json:"template"`
+	Result         PurposeResult `json:"result"`
+}
+
+type routingJSON struct {
+	Mode      RoutingMode `json:"mode"`
+	Primary   string      `json:"primary"`
+	Fallbacks []string    `json:"fallbacks"`
+}
+
+// LoadPurposePolicies decodes a JSON array of purpose policies:
+//
+//	[{"name": "transaction", "code_length": 8, "expiry_seconds": 120,
+//	  "retry_limit": 3, "channels": ["SMS", "VOICE"], "require_payload": true,
+//	  "routing": {"mode": "fallback", "primary": "SMS", "fallbacks": ["VOICE"]},
+//	  "result": "payload"}]
+func LoadPurposePolicies(r io.Reader) ([]PurposePolicy, error) {
+	var decoded []purposePolicyJSON
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode purpose policies: %w", err)
+	}
+	policies := make([]PurposePolicy, len(decoded))
+	for i, p := range decoded {
+		policies[i] = PurposePolicy{
+			Name:           p.Name,
+			CodeLength:     p.CodeLength,
+			Alphabet:       p.Alphabet,
+			Expiry:         time.Duration(p.ExpirySeconds) * time.Second,
+			RetryLimit:     p.RetryLimit,
+			Channels:       p.Channels,
+			RequirePayload: p.RequirePayload,
+			Template:       p.Template,
+			Result:         p.Result,
+		}
+		if p.Routing != nil {
+			policies[i].Routing = RoutingPolicy{Mode: p.Routing.Mode, Primary: p.Routing.Primary, Fallbacks: p.Routing.Fallbacks}
+		}
+	}
+	return policies, nil
+}
+
+// WithPurposes replaces the built-in purposes with registry.
+func WithPurposes(registry *PurposeRegistry) Option {
+	return func(s *OTPService) {
+		s.purposes = registry
+	}
+}
@@ -0,0 +1,323 @@
+package otp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests requests per Window. It is enforced as a token
+// bucket holding up to Requests tokens that refills continuously over Window,
+// so bursts are capped at Requests and the long-run rate at Requests/Window.
+// The zero Limit is unlimited.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Unlimited reports whether l places no limit.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Window <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return strconv.Itoa(l.Requests) + "/" + l.Window.String()
+}
+
+// ParseLimit parses a limit written as "<requests>/<window>", e.g. "5/1h" or
+// "30/10m". An empty string, "0" or "unlimited" yields the zero Limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "unlimited" {
+		return Limit{}, nil
+	}
+	requests, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: want <requests>/<window>", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad request count", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad window", s)
+	}
+	return Limit{Requests: n, Window: d}, nil
+}
+
+// Bucket is the persisted state of one token bucket.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Take refills b up to now and tries to take one token from it. It returns
+// the new bucket state, which stores must persist whether or not the request
+// was allowed, and the decision. A zero Bucket is a full one.
+func (l Limit) Take(b Bucket, now time.Time) (Bucket, RateLimitResult) {
+	capacity := float64(l.Requests)
+	rate := capacity / float64(l.Window)
+
+	tokens := capacity
+	if !b.UpdatedAt.IsZero() {
+		tokens = b.Tokens
+		if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+			tokens = math.Min(capacity, tokens+float64(elapsed)*rate)
+		}
+	}
+
+	if tokens < 1 {
+		wait := time.Duration(math.Ceil((1 - tokens) / rate))
+		return Bucket{Tokens: tokens, UpdatedAt: now}, RateLimitResult{RetryAfter: wait}
+	}
+	tokens--
+	return Bucket{Tokens: tokens, UpdatedAt: now}, RateLimitResult{Allowed: true, Remaining: int(tokens)}
+}
+
+// Refund refills b up to now and returns the token taken by an allowed Take,
+// without exceeding the bucket's capacity.
+func (l Limit) Refund(b Bucket, now time.Time) Bucket {
+	if b.UpdatedAt.IsZero() {
+		return b
+	}
+	capacity := float64(l.Requests)
+	tokens := b.Tokens
+	if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		tokens += float64(elapsed) * capacity / float64(l.Window)
+	}
+	return Bucket{Tokens: math.Min(capacity, tokens+1), UpdatedAt: now}
+}
+
+// FullAt returns when b will have refilled completely, after which its state
+// no longer matters and stores may drop it.
+func (l Limit) FullAt(b Bucket) time.Time {
+	missing := float64(l.Requests) - b.Tokens
+	if missing <= 0 {
+		return b.UpdatedAt
+	}
+	return b.UpdatedAt.Add(time.Duration(missing * float64(l.Window) / float64(l.Requests)))
+}
+
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is how many more requests are allowed right away.
+	Remaining int
+	// RetryAfter is how long until the next request is allowed; only set
+	// when the request was denied.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore persists token buckets. Implementations must apply
+// Limit.Take and Limit.Refund to the stored bucket atomically, so concurrent
+// callers sharing a key cannot both take the last token.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitResult, error)
+	// Refund returns a token taken by Allow, for requests denied by
+	// another bucket.
+	Refund(ctx context.Context, key string, limit Limit, now time.Time) error
+}
+
+// RateLimits are the limits applied to one purpose. Zero limits are unlimited.
+type RateLimits struct {
+	// SendPerRecipient bounds how many codes, including resends, a mobile
+	// number or email receives.
+	SendPerRecipient Limit
+	// SendPerClient bounds how many codes one client may request.
+	SendPerClient Limit
+	// ValidatePerClient bounds how many codes one client may submit.
+	ValidatePerClient Limit
+}
+
+// RateLimiter decides whether send and validate requests may proceed.
+type RateLimiter struct {
+	store    RateLimitStore
+	defaults RateLimits
+
+	mu       sync.RWMutex
+	purposes map[string]RateLimits
+}
+
+// NewRateLimiter creates a limiter that keeps its buckets in store and
+// applies defaults to purposes without their own limits.
+func NewRateLimiter(store RateLimitStore, defaults RateLimits) *RateLimiter {
+	return &RateLimiter{store: store, defaults: defaults, purposes: make(map[string]RateLimits)}
+}
+
+// SetPurposeLimits replaces the limits applied to purpose.
+func (l *RateLimiter) SetPurposeLimits(purpose string, limits RateLimits) {
+	l.mu.Lock()
+	l.purposes[purpose] = limits
+	l.mu.Unlock()
+}
+
+// Limits returns the limits applied to purpose.
+func (l *RateLimiter) Limits(purpose string) RateLimits {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if limits, ok := l.purposes[purpose]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+// AllowSend takes a token from the bucket of every recipient and of the
+// client, if known. It returns an error with CodeRateLimited when any bucket
+// is empty, refunding the tokens already taken so a denied send costs none.
+func (l *RateLimiter) AllowSend(ctx context.Context, purpose, client string, recipients ...string) error {
+	limits := l.Limits(purpose)
+	var buckets []rateBucket
+	for _, recipient := range recipients {
+		if recipient != "" {
+			buckets = append(buckets, rateBucket{"send:recipient:" + purpose + ":" + recipient, limits.SendPerRecipient})
+		}
+	}
+	if client != "" {
+		buckets = append(buckets, rateBucket{"send:client:" + purpose + ":" + client, limits.SendPerClient})
+	}
+
+	for i, bucket := range buckets {
+		if err := l.allow(ctx, bucket.key, bucket.limit); err != nil {
+			l.refund(ctx, buckets[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+type rateBucket struct {
+	key   string
+	limit Limit
+}
+
+// refund returns the tokens taken from buckets. A failed refund only leaves
+// a bucket charged, so errors are ignored.
+func (l *RateLimiter) refund(ctx context.Context, buckets []rateBucket) {
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now()
+	for _, bucket := range buckets {
+		if !bucket.limit.Unlimited() {
+			_ = l.store.Refund(ctx, bucket.key, bucket.limit, now)
+		}
+	}
+}
+
+// AllowValidate takes a token from the client's validation bucket. Requests
+// without a known client are not limited.
+func (l *RateLimiter) AllowValidate(ctx context.Context, purpose, client string) error {
+	if client == "" {
+		return nil
+	}
+	return l.allow(ctx, "validate:client:"+purpose+":"+client, l.Limits(purpose).ValidatePerClient)
+}
+
+func (l *RateLimiter) allow(ctx context.Context, key string, limit Limit) error {
+	if limit.Unlimited() {
+		return nil
+	}
+	result, err := l.store.Allow(ctx, key, limit, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if !result.Allowed {
+		return &Error{Code: CodeRateLimited, Message: ErrRateLimited.Message, RetryAfter: result.RetryAfter}
+	}
+	return nil
+}
+
+// WithRateLimiter makes the service consult limiter before sending,
+// re-sending and validating codes.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(s *OTPService) {
+		s.limiter = limiter
+	}
+}
+
+// allowSend consults the limiter, if any, before otp's code is sent through
+// channels. Only the recipients those channels deliver to are charged.
+func (s *OTPService) allowSend(ctx context.Context, otp *OTP, channels []string) error {
+	if s.limiter == nil {
+		return nil
+	}
+	var mobile, email string
+	for _, channel := range channels {
+		if s.channels[channel].kind == RecipientEmail {
+			email = s.limitedRecipient(otp.Email)
+		} else {
+			mobile = s.limitedRecipient(otp.MobileNumber)
+		}
+	}
+	return s.limiter.AllowSend(ctx, otp.Purpose, ClientFromContext(ctx), mobile, email)
+}
+
+// limitedRecipient returns how recipient appears in rate-limit bucket keys:
+// as its pseudonym when a pseudonym key is configured, so the buckets hold
+// no mobile numbers or emails that erasure would have to remove.
+func (s *OTPService) limitedRecipient(recipient string) string {
+	if len(s.pseudonymKey) == 0 {
+		return recipient
+	}
+	return pseudonym(s.pseudonymKey, recipient)
+}
+
+type clientKey struct{}
+
+// ContextWithClient returns a copy of ctx carrying the identity of the caller
+// on whose behalf the service is used, typically its IP address. It is the
+// key for per-client rate limits.
+func ContextWithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client stored by ContextWithClient, or "".
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// ParseRateLimitOverrides parses per-purpose limits written as
+// "<purpose>.<rule>=<limit>", where rule is send_recipient, send_client or
+// validate_client, e.g. "login.send_recipient=3/15m". Rules not overridden
+// for a purpose keep their value from defaults.
+func ParseRateLimitOverrides(defaults RateLimits, overrides []string) (map[string]RateLimits, error) {
+	purposes := make(map[string]RateLimits)
+	for _, override := range overrides {
+		override = strings.TrimSpace(override)
+		if override == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(override, "=")
+		purpose, rule, dotted := strings.Cut(name, ".")
+		if !ok || !dotted || purpose == "" {
+			return nil, fmt.Errorf("invalid rate limit override %q: want <purpose>.<rule>=<limit>", override)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+
+		limits, seen := purposes[purpose]
+		if !seen {
+			limits = defaults
+		}
+		switch rule {
+		case "send_recipient":
+			limits.SendPerRecipient = limit
+		case "send_client":
+			limits.SendPerClient = limit
+		case "validate_client":
+			limits.ValidatePerClient = limit
+		default:
+			return nil, fmt.Errorf("invalid rate limit override %q: unknown rule %q", override, rule)
+		}
+		purposes[purpose] = limits
+	}
+	return purposes, nil
+}
@@ -0,0 +1,112 @@
+package otp_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func newRateLimiter(t *testing.T, limits otp.RateLimits) *otp.RateLimiter {
+	t.Helper()
+	store := repository.NewMemoryRateLimitStore()
+	t.Cleanup(func() { store.Close() })
+	return otp.NewRateLimiter(store, limits)
+}
+
+func TestSendRejectedRequestsAreNotCharged(t *testing.T) {
+	limiter := newRateLimiter(t, otp.RateLimits{SendPerRecipient: otp.Limit{Requests: 1, Window: time.Hour}})
+	service, _ := newService(t, &stubSMS{}, otp.WithRateLimiter(limiter))
+	ctx := context.Background()
+
+	invalid := sendRequest()
+	invalid.Length = 100
+	for i := 0; i < 3; i++ {
+		if _, err := service.Send(ctx, invalid); !errors.Is(err, otp.ErrInvalidRequest) {
+			t.Fatalf("Send(invalid) error = %v, want ErrInvalidRequest", err)
+		}
+	}
+	if _, err := service.Send(ctx, sendRequest()); err != nil {
+		t.Fatalf("Send after rejected requests: %v", err)
+	}
+	if _, err := service.Send(ctx, sendRequest()); !errors.Is(err, otp.ErrRateLimited) {
+		t.Errorf("second Send error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestAllowSendRefundsOnDenial(t *testing.T) {
+	limiter := newRateLimiter(t, otp.RateLimits{
+		SendPerRecipient: otp.Limit{Requests: 2, Window: time.Hour},
+		SendPerClient:    otp.Limit{Requests: 1, Window: time.Hour},
+	})
+	ctx := context.Background()
+	const recipient = "+15551234567"
+
+	if err := limiter.AllowSend(ctx, "login", "10.0.0.1", recipient); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	// The client bucket denies, so the recipient keeps its second token.
+	if err := limiter.AllowSend(ctx, "login", "10.0.0.1", recipient); !errors.Is(err, otp.ErrRateLimited) {
+		t.Fatalf("second send from the same client error = %v, want ErrRateLimited", err)
+	}
+	if err := limiter.AllowSend(ctx, "login", "10.0.0.2", recipient); err != nil {
+		t.Fatalf("send from another client: %v", err)
+	}
+	if err := limiter.AllowSend(ctx, "login", "10.0.0.3", recipient); !errors.Is(err, otp.ErrRateLimited) {
+		t.Errorf("third send to the recipient error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestLimitRefund(t *testing.T) {
+	limit := otp.Limit{Requests: 2, Window: time.Hour}
+	now := time.Now()
+
+	bucket, _ := limit.Take(otp.Bucket{}, now)
+	if refunded := limit.Refund(bucket, now); refunded.Tokens != 2 {
+		t.Errorf("Tokens after Take and Refund = %v, want 2", refunded.Tokens)
+	}
+	// Refunds never overfill the bucket.
+	later := now.Add(45 * time.Minute)
+	if refunded := limit.Refund(bucket, later); refunded.Tokens != 2 || !refunded.UpdatedAt.Equal(later) {
+		t.Errorf("Refund after a refill = %+v, want 2 tokens at %v", refunded, later)
+	}
+	if refunded := limit.Refund(otp.Bucket{}, now); refunded != (otp.Bucket{}) {
+		t.Errorf("Refund of a full bucket = %+v", refunded)
+	}
+}
+
+// keyRecorder is a RateLimitStore that allows every request and records the
+// bucket keys it is asked about.
+type keyRecorder struct {
+	keys []string
+}
+
+func (r *keyRecorder) Allow(ctx context.Context, key string, limit otp.Limit, now time.Time) (otp.RateLimitResult, error) {
+	r.keys = append(r.keys, key)
+	return otp.RateLimitResult{Allowed: true}, nil
+}
+
+func (r *keyRecorder) Refund(ctx context.Context, key string, limit otp.Limit, now time.Time) error {
+	return nil
+}
+
+func TestSendRateLimitKeysArePseudonymous(t *testing.T) {
+	store := &keyRecorder{}
+	limiter := otp.NewRateLimiter(store, otp.RateLimits{SendPerRecipient: otp.Limit{Requests: 5, Window: time.Hour}})
+	service, _ := newService(t, &stubSMS{}, otp.WithRateLimiter(limiter), otp.WithPseudonymKey([]byte("pseudonym key")))
+
+	req := sendRequest()
+	if _, err := service.Send(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != 1 {
+		t.Fatalf("keys = %q, want one recipient bucket", store.keys)
+	}
+	if key := store.keys[0]; strings.Contains(key, *req.MobileNumber) || !strings.Contains(key, "anon:") {
+		t.Errorf("bucket key %q is not keyed by the recipient's pseudonym", key)
+	}
+}
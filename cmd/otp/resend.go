@@ -0,0 +1,169 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultResendCooldown = 30 * time.Second
+	DefaultMaxResends     = 3
+)
+
+const (
+	DeliverySMS   = "SMS"
+	DeliveryEmail = "EMAIL"
+	// DeliveryVoice calls the mobile number and reads the SMS message.
+	DeliveryVoice = "VOICE"
+)
+
+// WithResendPolicy sets the minimum time between two sends of the same OTP
+// and how many times it may be re-sent. The resend limit is stored on each
+// OTP when it is issued.
+func WithResendPolicy(cooldown time.Duration, maxResends int) Option {
+	return func(s *OTPService) {
+		s.cooldown = cooldown
+		s.maxResends = maxResends
+	}
+}
+
+type ResendOTPRequest struct {
+	// Token is the token returned by SendOTP.
+	Token string
+	// Channel optionally restricts delivery to the named channel, e.g.
+	// DeliverySMS. When empty the purpose's routing policy applies, as
+	// for the original send.
+	Channel string
+}
+
+type ResendResult struct {
+	Delivery string
+	// Attempts lists the channels tried, in order.
+	Attempts     []DeliveryAttempt
+	ResendsLeft  int
+	NextResendAt time.Time
+	ExpiresAt    time.Time
+}
+
+// ResendOTP generates a new code for the OTP referenced by req.Token and
+// delivers it, invalidating the previous code. The reference, token, expiry
+// and retry count are unchanged. If no channel delivers, the previous code
+// stays valid and the resend is not counted.
+func (s *OTPService) ResendOTP(ctx context.Context, req ResendOTPRequest) (*ResendResult, error) {
+	otpRef, err := otpRefFromToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	otp, err := s.repo.GetOTPByID(ctx, otpRef)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load OTP: %w", err)
+	}
+
+	now := time.Now()
+	if err := s.checkResendable(otp, now); err != nil {
+		return nil, err
+	}
+
+	policy, ok := s.purposes.Lookup(otp.Purpose)
+	if !ok {
+		// OTPs whose purpose has since been removed use the default routing.
+		policy = PurposePolicy{Name: otp.Purpose}
+	}
+	delivery := otp.Delivery
+	if req.Channel != "" {
+		if _, ok := s.channels[req.Channel]; !ok {
+			return nil, newError(CodeInvalidRequest, fmt.Sprintf("unsupported delivery channel %q", req.Channel), nil)
+		}
+		if s.recipientFor(otp, req.Channel) == "" {
+			return nil, newError(CodeInvalidRequest, fmt.Sprintf("OTP has no recipient to resend to by %s", req.Channel), nil)
+		}
+		if !policy.AllowsChannel(req.Channel) {
+			return nil, newError(CodeInvalidRequest, fmt.Sprintf("purpose %q does not allow %s delivery", otp.Purpose, req.Channel), nil)
+		}
+		delivery = req.Channel
+	}
+	mode, channels := s.route(policy, otp, req.Channel)
+	if len(channels) == 0 {
+		return nil, newError(CodeInvalidRequest, "OTP has no stored message template or provider to resend with", nil)
+	}
+
+	if err := s.allowSend(ctx, otp, channels); err != nil {
+		return nil, err
+	}
+
+	length := otp.CodeLength
+	if length == 0 {
+		length = s.minLength
+	}
+	rawOTP, err := s.generatorFor(otp.Purpose).Generate(length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate OTP: %w", err)
+	}
+	hashedOTP, err := s.hashers.Hash(boundCode(rawOTP, otp.PayloadDigest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash OTP: %w", err)
+	}
+
+	updated, err := s.repo.RecordResend(ctx, otpRef, otp.ResendCount, hashedOTP, delivery, now)
+	if err != nil {
+		if !errors.Is(err, ErrNoLongerValid) {
+			return nil, fmt.Errorf("failed to record resend: %w", err)
+		}
+		// Either the OTP stopped being valid or a concurrent resend won;
+		// reload to report which.
+		if current, loadErr := s.repo.GetOTPByID(ctx, otpRef); loadErr == nil {
+			if err := s.checkResendable(current, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNoLongerValid
+	}
+
+	s.emit(ctx, LifecycleEvent{Type: AuditIssued, OTP: *updated})
+
+	attempts, err := s.deliver(ctx, updated, rawOTP, mode, channels)
+	if err != nil {
+		// The new code reached nobody: keep the previous one valid and the
+		// resend unspent.
+		if revertErr := s.repo.RevertResend(context.WithoutCancel(ctx), otpRef, updated.ResendCount, otp); revertErr != nil {
+			s.logger.WarnContext(ctx, "failed to revert undelivered resend", "otp_ref", otpRef, "error", revertErr)
+		}
+		return nil, err
+	}
+
+	return &ResendResult{
+		Delivery:     updated.Delivery,
+		Attempts:     attempts,
+		ResendsLeft:  updated.MaxResends - updated.ResendCount,
+		NextResendAt: updated.LastSentAt.Add(s.cooldown),
+		ExpiresAt:    updated.ExpiresAt,
+	}, nil
+}
+
+// checkResendable returns the error explaining why otp cannot be re-sent at
+// now, or nil.
+func (s *OTPService) checkResendable(otp *OTP, now time.Time) error {
+	if otp.Status != OTPStatusPending {
+		return ErrNoLongerValid
+	}
+	if !now.Before(otp.ExpiresAt) {
+		return ErrExpired
+	}
+	if otp.ResendCount >= otp.MaxResends {
+		return ErrMaxResends
+	}
+	if next := otp.LastSentAt.Add(s.cooldown); now.Before(next) {
+		return &Error{
+			Code:       CodeResendCooldown,
+			Message:    ErrResendCooldown.Message,
+			RetryAfter: next.Sub(now),
+		}
+	}
+	return nil
+}
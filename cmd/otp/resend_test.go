@@ -0,0 +1,107 @@
+package otp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+// stubSMS records the messages it is asked to send and fails with err
+// when set.
+type stubSMS struct {
+	mu       sync.Mutex
+	err      error
+	messages []string
+}
+
+func (p *stubSMS) SendSMS(ctx context.Context, phone, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func (p *stubSMS) fail(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// lastCode returns the code in the last message sent.
+func (p *stubSMS) lastCode(t *testing.T) string {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.messages) == 0 {
+		t.Fatal("no SMS was sent")
+	}
+	return strings.TrimPrefix(p.messages[len(p.messages)-1], "Your code is ")
+}
+
+func newService(t *testing.T, sms *stubSMS, opts ...otp.Option) (*otp.OTPService, *repository.MemoryOTPRepository) {
+	t.Helper()
+	repo := repository.NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { repo.Close() })
+	opts = append([]otp.Option{
+		otp.WithHasher(otp.NewBcryptHasher(4)),
+		otp.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	return otp.NewOTPService(repo, sms, nil, opts...), repo
+}
+
+func sendRequest() otp.SendOTPRequest {
+	mobile, body := "+15551234567", "Your code is <otp>"
+	return otp.SendOTPRequest{
+		Purpose:      "login",
+		Length:       6,
+		RetryLimit:   3,
+		Expiration:   5 * time.Minute,
+		MobileNumber: &mobile,
+		SMSBody:      &body,
+	}
+}
+
+func TestResendOTPDeliveryFailure(t *testing.T) {
+	sms := &stubSMS{}
+	service, repo := newService(t, sms, otp.WithResendPolicy(0, 3))
+	ctx := context.Background()
+
+	sent, err := service.Send(ctx, sendRequest())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	code := sms.lastCode(t)
+	before, err := repo.GetOTPByID(ctx, sent.OTPRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sms.fail(errors.New("gateway down"))
+	if _, err := service.ResendOTP(ctx, otp.ResendOTPRequest{Token: sent.Token}); !errors.Is(err, otp.ErrDeliveryFailed) {
+		t.Fatalf("ResendOTP error = %v, want ErrDeliveryFailed", err)
+	}
+
+	after, err := repo.GetOTPByID(ctx, sent.OTPRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.HashedOTP != before.HashedOTP || after.ResendCount != before.ResendCount ||
+		!after.LastSentAt.Equal(before.LastSentAt) || after.Delivery != before.Delivery {
+		t.Errorf("failed resend changed the OTP: got %+v, want %+v", after, before)
+	}
+
+	if _, err := service.ValidateOTP(ctx, code, sent.Token); err != nil {
+		t.Errorf("ValidateOTP with the original code after a failed resend: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/client"
+	"github.com/google/uuid"
+)
+
+// RoutingMode selects how a RoutingPolicy uses its channels.
+type RoutingMode string
+
+const (
+	// RouteFallback sends through the first usable channel and moves on to
+	// the next only when its provider fails.
+	RouteFallback RoutingMode = "fallback"
+	// RouteAll sends through every usable channel.
+	RouteAll RoutingMode = "all"
+)
+
+// RoutingPolicy decides which channels deliver a code. Channels are used
+// in the order Primary, Fallbacks; a channel is skipped when the OTP has no
+// recipient or message template for it, its purpose does not allow it or
+// it is not registered with the service.
+type RoutingPolicy struct {
+	// Mode defaults to RouteFallback.
+	Mode      RoutingMode
+	Primary   string
+	Fallbacks []string
+}
+
+// DefaultRoutingPolicy sends to the mobile number and the email, whichever
+// the request has.
+func DefaultRoutingPolicy() RoutingPolicy {
+	return RoutingPolicy{Mode: RouteAll, Primary: DeliverySMS, Fallbacks: []string{DeliveryEmail}}
+}
+
+// IsZero reports whether p is unset.
+func (p RoutingPolicy) IsZero() bool {
+	return p.Mode == "" && p.Primary == "" && len(p.Fallbacks) == 0
+}
+
+// Channels returns Primary followed by Fallbacks.
+func (p RoutingPolicy) Channels() []string {
+	return append([]string{p.Primary}, p.Fallbacks...)
+}
+
+// Validate checks that p names a known mode and distinct, known channels.
+func (p RoutingPolicy) Validate() error {
+	switch p.Mode {
+	case "", RouteFallback, RouteAll:
+	default:
+		return fmt.Errorf("unsupported routing mode %q", p.Mode)
+	}
+	if p.Primary == "" {
+		return errors.New("routing has no primary channel")
+	}
+	seen := make(map[string]bool)
+	for _, channel := range p.Channels() {
+		if !validChannelName(channel) {
+			return fmt.Errorf("invalid delivery channel name %q", channel)
+		}
+		if seen[channel] {
+			return fmt.Errorf("delivery channel %q is listed twice", channel)
+		}
+		seen[channel] = true
+	}
+	return nil
+}
+
+// ParseRoutingPolicy parses a policy written as "<mode>:<channels>", e.g.
+// "fallback:SMS,VOICE,EMAIL" or "all:SMS,EMAIL". The first channel is the
+// primary. An empty string returns the zero policy.
+func ParseRoutingPolicy(s string) (RoutingPolicy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return RoutingPolicy{}, nil
+	}
+	mode, list, ok := strings.Cut(s, ":")
+	if !ok {
+		return RoutingPolicy{}, fmt.Errorf("invalid routing %q, want <mode>:<channels>", s)
+	}
+	var channels []string
+	for _, channel := range strings.Split(list, ",") {
+		if channel = strings.ToUpper(strings.TrimSpace(channel)); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		return RoutingPolicy{}, fmt.Errorf("invalid routing %q: no channels", s)
+	}
+	policy := RoutingPolicy{
+		Mode:      RoutingMode(strings.ToLower(strings.TrimSpace(mode))),
+		Primary:   channels[0],
+		Fallbacks: channels[1:],
+	}
+	if err := policy.Validate(); err != nil {
+		return RoutingPolicy{}, fmt.Errorf("invalid routing %q: %w", s, err)
+	}
+	return policy, nil
+}
+
+// WithRouting routes codes of purposes without their own routing by
+// policy instead of DefaultRoutingPolicy.
+func WithRouting(policy RoutingPolicy) Option {
+	return func(s *OTPService) {
+		if !policy.IsZero() {
+			s.routing = policy
+		}
+	}
+}
+
+// WithVoiceProvider registers provider as the DeliveryVoice channel.
+func WithVoiceProvider(provider client.VoiceProvider) Option {
+	return func(s *OTPService) {
+		if provider == nil {
+			s.registerChannel(DeliveryVoice, RecipientPhone, nil)
+			return
+		}
+		s.registerChannel(DeliveryVoice, RecipientPhone, client.VoiceChannel(provider))
+	}
+}
+
+// DeliveryAttempt is the outcome of sending a code through one channel.
+type DeliveryAttempt struct {
+	Channel  string
+	Provider string
+	// MessageID is the provider's ID for a delivered message, if any.
+	MessageID string
+	// Err is the provider error, or nil if the channel delivered.
+	Err error
+}
+
+// SendResult describes an issued OTP and how it was delivered.
+type SendResult struct {
+	// Token references the OTP in ValidateOTP and ResendOTP.
+	Token     string
+	OTPRef    uuid.UUID
+	ExpiresAt time.Time
+	// Attempts lists the channels tried, in order.
+	Attempts []DeliveryAttempt
+	// Queued reports that delivery was left to an OutboxWorker; Attempts
+	// is then empty.
+	Queued bool
+}
+
+// Delivered returns the channels that delivered the code.
+func (r *SendResult) Delivered() []string {
+	return deliveredChannels(r.Attempts)
+}
+
+func deliveredChannels(attempts []DeliveryAttempt) []string {
+	var channels []string
+	for _, attempt := range attempts {
+		if attempt.Err == nil {
+			channels = append(channels, attempt.Channel)
+		}
+	}
+	return channels
+}
+
+// route returns the mode and the usable channels, in order, for delivering
+// otp under policy. A non-empty only restricts delivery to that channel.
+func (s *OTPService) route(policy PurposePolicy, otp *OTP, only string) (RoutingMode, []string) {
+	routing := policy.Routing
+	if routing.IsZero() {
+		routing = s.routing
+	}
+	mode, channels := routing.Mode, routing.Channels()
+	if mode == "" {
+		mode = RouteFallback
+	}
+	if only != "" {
+		mode, channels = RouteAll, []string{only}
+	}
+
+	var usable []string
+	for _, channel := range channels {
+		if policy.AllowsChannel(channel) && s.canDeliver(otp, channel) {
+			usable = append(usable, channel)
+		}
+	}
+	return mode, usable
+}
+
+// deliver sends code through channels as mode directs, using the record's
+// message templates. Messages for OTPs bound to a payload show its amount
+// and payee. It fails with CodeDeliveryFailed unless a channel delivered.
+func (s *OTPService) deliver(ctx context.Context, otp *OTP, code string, mode RoutingMode, channels []string) ([]DeliveryAttempt, error) {
+	displayOTP := FormatCode(code, s.groupSize, s.groupSep)
+
+	var boundPayload map[string]interface{}
+	if otp.PayloadDigest != "" {
+		var err error
+		if boundPayload, err = s.transactionPayload(otp); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction payload: %w", err)
+		}
+	}
+
+	var attempts []DeliveryAttempt
+	var deliveryErrs []error
+	delivered := false
+	for _, channel := range channels {
+		if err := ctx.Err(); err != nil {
+			deliveryErrs = append(deliveryErrs, err)
+			break
+		}
+		attempt := s.sendTo(ctx, otp, channel, displayOTP, boundPayload)
+		attempts = append(attempts, attempt)
+		if attempt.Err != nil {
+			deliveryErrs = append(deliveryErrs, fmt.Errorf("%s: %w", channel, attempt.Err))
+			continue
+		}
+		delivered = true
+		if mode == RouteFallback {
+			break
+		}
+	}
+
+	if !delivered {
+		return attempts, newError(CodeDeliveryFailed, ErrDeliveryFailed.Message, errors.Join(deliveryErrs...))
+	}
+	return attempts, nil
+}
@@ -1,74 +1,247 @@
 package otp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Zaman-R/otp-validator/cmd/client"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Zaman-R/otp-validator/cmd/utils"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // OTPService handles OTP generation, validation, and sending.
 type OTPService struct {
-	repo          *OTPRepository
-	smsProvider   client.SMSProvider
-	emailProvider client.EmailProvider
+	repo          OTPStore
+	channels      map[string]channelEntry
+	routing       RoutingPolicy
+	outbox        OutboxStore
+	hashers       *Hashers
+	generator     CodeGenerator
+	minLength     int
+	maxLength     int
+	groupSize     int
+	groupSep      string
+	cooldown      time.Duration
+	maxResends    int
+	limiter       *RateLimiter
+	purposes      *PurposeRegistry
+	keyring       *Keyring
+	templates     *TemplateRegistry
+	appName       string
+	pseudonymKey  []byte
+	privacyStores []RecipientDataStore
+	auditLog      *AuditLog
+	hooks         []Hooks
+	asyncHooks    []Hooks
+	hooksWG       sync.WaitGroup
+	metrics       Metrics
+	logger        *slog.Logger
+}
+
+// Option configures optional OTPService behaviour.
+type Option func(*OTPService)
+
+// WithHasher hashes new codes with primary. Hashers listed in previous are
+// only used to verify codes that were stored before primary was introduced;
+// such hashes are upgraded to primary once their code verifies.
+func WithHasher(primary OTPHasher, previous ...OTPHasher) Option {
+	return func(s *OTPService) {
+		s.hashers = NewHashers(primary, previous...)
+	}
+}
+
+// WithCodeGenerator replaces the default numeric code generator.
+func WithCodeGenerator(generator CodeGenerator) Option {
+	return func(s *OTPService) {
+		s.generator = generator
+	}
+}
+
+// WithLengthBounds rejects requested code lengths outside [min, max].
+// Non-positive values keep the defaults. Validate configured bounds with
+// CheckLengthBounds first.
+func WithLengthBounds(min, max int) Option {
+	return func(s *OTPService) {
+		if min > 0 {
+			s.minLength = min
+		}
+		if max > 0 {
+			s.maxLength = max
+		}
+	}
+}
+
+// WithCodeGrouping displays codes in messages as groups of size characters
+// joined by separator (e.g. "123-456"). Users may type the code with or
+// without the separator, so it must not share characters with the code
+// alphabets; see CheckGrouping.
+func WithCodeGrouping(size int, separator string) Option {
+	return func(s *OTPService) {
+		s.groupSize = size
+		s.groupSep = separator
+	}
+}
+
+// WithLogger logs through logger. Attributes naming codes, secrets or
+// recipients are redacted unless logger already redacts.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *OTPService) {
+		if logger != nil {
+			s.logger = utils.Redacting(logger)
+		}
+	}
 }
 
 // NewOTPService initializes a new OTPService.
-func NewOTPService(repo *OTPRepository, smsProvider client.SMSProvider, emailProvider client.EmailProvider) *OTPService {
-	return &OTPService{
-		repo:          repo,
-		smsProvider:   smsProvider,
-		emailProvider: emailProvider,
+func NewOTPService(repo OTPStore, smsProvider client.SMSProvider, emailProvider client.EmailProvider, opts ...Option) *OTPService {
+	s := &OTPService{
+		repo:       repo,
+		channels:   make(map[string]channelEntry),
+		hashers:    NewHashers(NewBcryptHasher(bcrypt.DefaultCost)),
+		minLength:  DefaultMinLength,
+		maxLength:  DefaultMaxLength,
+		cooldown:   DefaultResendCooldown,
+		maxResends: DefaultMaxResends,
+		routing:    DefaultRoutingPolicy(),
+		logger:     utils.DefaultLogger(),
 	}
+	if smsProvider != nil {
+		s.registerChannel(DeliverySMS, RecipientPhone, client.SMSChannel(smsProvider))
+	}
+	if emailProvider != nil {
+		s.registerChannel(DeliveryEmail, RecipientEmail, client.EmailChannel(emailProvider))
+	}
+	s.generator, _ = NewCodeGenerator(AlphabetNumeric)
+	s.purposes, _ = NewPurposeRegistry(DefaultPurposePolicies()...)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *OTPService) IsOTPExpired(otp OTP) bool {
 	return time.Now().After(otp.ExpiresAt)
 }
 
-func (s *OTPService) GenerateOTP(email, phone, purpose string, retryLimit, expiryMinutes int, transactionPayload map[string]interface{}) (*OTP, string, error) {
-	rawOTP, err := utils.GenerateSecureOTP()
+func (s *OTPService) GenerateOTP(ctx context.Context, email, phone, purpose string, length, retryLimit, expiryMinutes int, transactionPayload map[string]interface{}) (*OTP, string, error) {
+	entry, err := s.purposeFor(purpose)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate OTP: %v", err)
+		return nil, "", err
 	}
-
-	hashedOTP, err := utils.HashOTP(rawOTP)
+	otp, rawOTP, err := s.newOTP(entry, email, phone, length, retryLimit, time.Duration(expiryMinutes)*time.Minute, transactionPayload)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to hash OTP: %v", err)
+		return nil, "", err
+	}
+
+	if err := s.repo.SaveOTP(ctx, otp); err != nil {
+		return nil, "", fmt.Errorf("failed to save OTP: %w", err)
+	}
+
+	return otp, rawOTP, nil
+}
+
+// purposeFor returns the registered policy for purpose.
+func (s *OTPService) purposeFor(purpose string) (purposeEntry, error) {
+	entry, ok := s.purposes.lookup(purpose)
+	if !ok {
+		return purposeEntry{}, newError(CodeInvalidRequest, fmt.Sprintf("unknown purpose %q", purpose), ErrUnknownPurpose)
+	}
+	return entry, nil
+}
+
+// generatorFor returns the code generator for OTPs issued for purpose.
+func (s *OTPService) generatorFor(purpose string) CodeGenerator {
+	if entry, ok := s.purposes.lookup(purpose); ok && entry.generator != nil {
+		return entry.generator
+	}
+	return s.generator
+}
+
+// newOTP generates a code and builds the unsaved PENDING record for it,
+// applying the purpose's policy to the requested parameters.
+func (s *OTPService) newOTP(entry purposeEntry, email, phone string, length, retryLimit int, expiry time.Duration, transactionPayload map[string]interface{}) (*OTP, string, error) {
+	policy := entry.policy
+	if policy.CodeLength > 0 {
+		length = policy.CodeLength
+	} else if length == 0 {
+		length = s.minLength
+	}
+	if policy.RetryLimit > 0 {
+		retryLimit = policy.RetryLimit
+	}
+	if policy.Expiry > 0 {
+		expiry = policy.Expiry
+	}
+
+	if phone != "" && !s.allowsRecipient(policy, RecipientPhone) {
+		return nil, "", newError(CodeInvalidRequest, fmt.Sprintf("purpose %q has no channel for mobile numbers", policy.Name), nil)
+	}
+	if email != "" && !s.allowsRecipient(policy, RecipientEmail) {
+		return nil, "", newError(CodeInvalidRequest, fmt.Sprintf("purpose %q has no channel for email addresses", policy.Name), nil)
+	}
+	if policy.RequirePayload && len(transactionPayload) == 0 {
+		return nil, "", newError(CodeInvalidRequest, fmt.Sprintf("purpose %q requires a transaction payload", policy.Name), nil)
+	}
+
+	if length < s.minLength || length > s.maxLength {
+		return nil, "", newError(CodeInvalidRequest, fmt.Sprintf("OTP length must be between %d and %d", s.minLength, s.maxLength),
+			fmt.Errorf("%w: %d", ErrInvalidLength, length))
 	}
 
 	deliveryMethod := utils.DetermineDeliveryMethod(email, phone)
-	if deliveryMethod == "" {
-		return nil, "", errors.New("no valid delivery method provided (email or phone required)")
+	if deliveryMethod == "UNKNOWN" {
+		return nil, "", newError(CodeInvalidRequest, "no valid delivery method provided (email or phone required)", nil)
 	}
 
-	var encodedPayload string
-	if purpose == "transaction" && transactionPayload != nil {
-		encodedPayload, err = utils.EncodeBase64(transactionPayload)
+	// A stored payload binds the code: its digest is hashed with the code,
+	// so the code only verifies together with the same payload.
+	id := uuid.New()
+	var encodedPayload, payloadKeyID, digest string
+	if transactionPayload != nil && (policy.RequirePayload || policy.Result == ResultPayload) {
+		var err error
+		payloadKeyID, encodedPayload, err = s.encodePayload(id, transactionPayload)
+		if err != nil {
+			return nil, "", newError(CodeInvalidRequest, "failed to encode transaction payload", err)
+		}
+		digest, err = PayloadDigest(transactionPayload)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to encode transaction payload: %v", err)
+			return nil, "", newError(CodeInvalidRequest, "failed to digest transaction payload", err)
 		}
 	}
 
+	rawOTP, err := s.generatorFor(policy.Name).Generate(length)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate OTP: %w", err)
+	}
+
+	hashedOTP, err := s.hashers.Hash(boundCode(rawOTP, digest))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to hash OTP: %w", err)
+	}
+
+	now := time.Now()
 	otp := &OTP{
-		ID:                 uuid.New(),
-		Purpose:            purpose,
+		ID:                 id,
+		Purpose:            policy.Name,
 		HashedOTP:          hashedOTP,
+		CodeLength:         length,
 		Delivery:           deliveryMethod,
 		MobileNumber:       phone,
 		Email:              email,
 		RetryLimit:         retryLimit,
-		ExpiresAt:          time.Now().Add(time.Duration(expiryMinutes) * time.Minute),
+		MaxResends:         s.maxResends,
+		LastSentAt:         now,
+		ExpiresAt:          now.Add(expiry),
 		Status:             OTPStatusPending,
 		TransactionPayload: encodedPayload,
-	}
-
-	if err := s.repo.SaveOTP(otp); err != nil {
-		return nil, "", fmt.Errorf("failed to save OTP: %v", err)
+		PayloadDigest:      digest,
+		PayloadKeyID:       payloadKeyID,
 	}
 
 	return otp, rawOTP, nil
@@ -79,6 +252,9 @@ func (s *OTPService) CanRetry(otp OTP) bool {
 }
 
 type SendOTPRequest struct {
+	// Purpose names a registered purpose policy. FromAccount is used when
+	// it is empty, as earlier versions took the purpose from there.
+	Purpose      string
 	FromAccount  string
 	Payload      map[string]interface{}
 	Length       int
@@ -89,10 +265,17 @@ type SendOTPRequest struct {
 	SMSBody      *string
 	EmailSubject *string
 	EmailBody    *string
+	// Template names a registered message template to use instead of the
+	// purpose's. SMSBody, EmailSubject and EmailBody, when given, override
+	// the template for their channels; they fill the <otp> placeholder.
+	Template string
+	// Locale is passed to channels, e.g. "en" or "fr-CA".
+	Locale string
 }
 
-func (s *OTPService) SendOTPFromParams(params map[string]interface{}) (string, error) {
+func (s *OTPService) SendOTPFromParams(ctx context.Context, params map[string]interface{}) (string, error) {
 	request := SendOTPRequest{
+		Purpose:      utils.GetString(params, "purpose"),
 		FromAccount:  utils.GetString(params, "from_account"),
 		Payload:      utils.GetMap(params, "payload"),
 		Length:       utils.GetInt(params, "length", 6),
@@ -103,120 +286,367 @@ func (s *OTPService) SendOTPFromParams(params map[string]interface{}) (string, e
 		SMSBody:      utils.GetStringPtr(params, "sms_body"),
 		EmailSubject: utils.GetStringPtr(params, "email_subject"),
 		EmailBody:    utils.GetStringPtr(params, "email_body"),
+		Template:     utils.GetString(params, "template"),
+		Locale:       utils.GetString(params, "locale"),
 	}
+	if clientIP := utils.GetString(params, "client_ip"); clientIP != "" {
+		ctx = ContextWithClient(ctx, clientIP)
+	}
+
+	return s.SendOTP(ctx, request)
+}
 
-	return s.SendOTP(request)
+// SendOTP issues and delivers an OTP and returns its token. See Send.
+func (s *OTPService) SendOTP(ctx context.Context, req SendOTPRequest) (string, error) {
+	result, err := s.Send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return result.Token, nil
 }
 
-func (s *OTPService) SendOTP(req SendOTPRequest) (string, error) {
+// Send issues an OTP and delivers it through the channels chosen by the
+// routing policy of its purpose. It fails unless at least one channel
+// delivered, expiring the OTP; the result reports every channel tried. With WithOutbox the
+// delivery is queued instead and Send returns once the OTP is saved.
+func (s *OTPService) Send(ctx context.Context, req SendOTPRequest) (*SendResult, error) {
 	if req.MobileNumber == nil && req.Email == nil {
-		return "", errors.New("please provide a valid mobile_number, email, or both")
+		return nil, newError(CodeInvalidRequest, "please provide a valid mobile_number, email, or both", nil)
 	}
-	if req.MobileNumber != nil && (req.SMSBody == nil || !utils.Contains(*req.SMSBody, "<otp>")) {
-		return "", errors.New("invalid SMS body format, missing `<otp>` placeholder")
+	if req.MobileNumber != nil && !s.validBody(req.SMSBody) {
+		return nil, newError(CodeInvalidRequest, "invalid SMS body format, missing `<otp>` placeholder", nil)
+	}
+	if req.Email != nil && !s.validBody(req.EmailBody) {
+		return nil, newError(CodeInvalidRequest, "invalid Email body format, missing `<otp>` placeholder", nil)
+	}
+	if req.Template != "" && (s.templates == nil || !s.templates.Has(req.Template)) {
+		return nil, newError(CodeInvalidRequest, fmt.Sprintf("unknown message template %q", req.Template), nil)
+	}
+
+	purpose := req.Purpose
+	if purpose == "" {
+		purpose = req.FromAccount
 	}
-	if req.Email != nil && (req.EmailBody == nil || !utils.Contains(*req.EmailBody, "<otp>")) {
-		return "", errors.New("invalid Email body format, missing `<otp>` placeholder")
+	entry, err := s.purposeFor(purpose)
+	if err != nil {
+		return nil, err
 	}
 
-	otp, rawOTP, err := s.GenerateOTP(
+	otp, rawOTP, err := s.newOTP(
+		entry,
 		utils.GetStringValue(req.Email),
 		utils.GetStringValue(req.MobileNumber),
-		req.FromAccount,
+		req.Length,
 		req.RetryLimit,
-		int(req.Expiration.Minutes()),
+		req.Expiration,
 		req.Payload,
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate OTP: %v", err)
+		return nil, err
+	}
+	// Templates are kept so the code can be re-sent for the same reference.
+	otp.SMSBody = utils.GetStringValue(req.SMSBody)
+	otp.EmailSubject = utils.GetStringValue(req.EmailSubject)
+	otp.EmailBody = utils.GetStringValue(req.EmailBody)
+	otp.Template = req.Template
+	otp.Locale = req.Locale
+
+	mode, channels := s.route(entry.policy, otp, "")
+	if len(channels) == 0 {
+		return nil, newError(CodeInvalidRequest, "no delivery channel is available for the given recipients", nil)
 	}
+	otp.Delivery = channels[0]
 
-	smsBody := utils.ReplacePlaceholder(req.SMSBody, rawOTP)
-	emailBody := utils.ReplacePlaceholder(req.EmailBody, rawOTP)
+	if err := s.allowSend(ctx, otp, channels); err != nil {
+		return nil, err
+	}
 
+	if s.outbox != nil {
+		message, err := s.newOutboxMessage(otp, rawOTP, mode, channels)
+		if err != nil {
+			return nil, err
+		}
+		otp.DeliveryStatus = OutboxPending
+		if err := s.outbox.SaveOTPWithMessage(ctx, otp, message); err != nil {
+			return nil, fmt.Errorf("failed to save OTP: %w", err)
+		}
+	} else if err := s.repo.SaveOTP(ctx, otp); err != nil {
+		return nil, fmt.Errorf("failed to save OTP: %w", err)
+	}
+	s.emit(ctx, LifecycleEvent{Type: AuditIssued, OTP: *otp})
+
+	// The token lives exactly as long as the OTP.
 	payload := map[string]interface{}{"otp_ref": otp.ID}
-	token, err := utils.GenerateToken(payload, int(req.Expiration.Seconds()))
+	token, err := utils.GenerateToken(payload, int(otp.ExpiresAt.Sub(otp.LastSentAt).Seconds()))
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token: %v", err)
+		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	if req.MobileNumber != nil && s.smsProvider != nil {
-		err = s.smsProvider.SendSMS(*req.MobileNumber, smsBody)
-		if err != nil {
-			fmt.Printf("Failed to send SMS: %v\n", err)
-		}
+	result := &SendResult{
+		Token:     token,
+		OTPRef:    otp.ID,
+		ExpiresAt: otp.ExpiresAt,
+		Queued:    s.outbox != nil,
+	}
+	if result.Queued {
+		return result, nil
+	}
+	if result.Attempts, err = s.deliver(ctx, otp, rawOTP, mode, channels); err != nil {
+		s.expireUndelivered(ctx, otp)
+		return nil, err
 	}
+	return result, nil
+}
 
-	if req.Email != nil && s.emailProvider != nil {
-		err = s.emailProvider.SendEmail(*req.Email, emailBody)
-		if err != nil {
-			fmt.Printf("Failed to send Email: %v\n", err)
-		}
+// expireUndelivered expires an OTP whose code no channel delivered, so it
+// does not linger as pending.
+func (s *OTPService) expireUndelivered(ctx context.Context, otp *OTP) {
+	if err := s.repo.ConsumeOTP(context.WithoutCancel(ctx), otp.ID, OTPStatusExpired, time.Now()); err != nil {
+		s.logger.WarnContext(ctx, "failed to expire undelivered OTP", "otp_ref", otp.ID, "error", err)
+		return
 	}
+	s.emit(ctx, LifecycleEvent{Type: AuditExpired, OTP: *otp, Reason: CodeDeliveryFailed})
+}
 
-	return token, nil
+// validBody reports whether body can render a message: it must show the
+// code, and may only be omitted when templates are configured.
+func (s *OTPService) validBody(body *string) bool {
+	if body == nil {
+		return s.templates != nil
+	}
+	return utils.Contains(*body, "<otp>")
 }
 
-func (s *OTPService) ValidateOTP(otpCode string, payloadToken string) (map[string]interface{}, error) {
-	payload, err := utils.ValidateToken(payloadToken)
-	if err != nil {
-		return nil, fmt.Errorf("invalid token provided: %v", err)
+func (s *OTPService) observeDelivery(provider interface{}, channel string, start time.Time, err error) {
+	if s.metrics != nil {
+		s.metrics.DeliveryCompleted(client.ProviderName(provider), channel, time.Since(start), err)
 	}
-	otpRefStr, ok := payload["otp_ref"].(string)
-	if !ok {
-		return nil, errors.New("invalid otp_ref format")
+}
+
+// encodePayload encodes payload for storage on the OTP with id, encrypted
+// when a keyring is configured. keyID is empty for unencrypted payloads.
+func (s *OTPService) encodePayload(id uuid.UUID, payload map[string]interface{}) (keyID, encoded string, err error) {
+	if s.keyring == nil {
+		encoded, err = utils.EncodeBase64(payload)
+		return "", encoded, err
 	}
+	return sealPayload(s.keyring, id, payload)
+}
 
-	otpRef, err := uuid.Parse(otpRefStr)
+// transactionPayload decodes the payload stored with otp, or returns nil.
+func (s *OTPService) transactionPayload(otp *OTP) (map[string]interface{}, error) {
+	return openPayload(s.keyring, otp)
+}
+
+// ValidateOTPRequest identifies the OTP being verified and, for OTPs sent
+// with a payload, the transaction the user approves.
+type ValidateOTPRequest struct {
+	Code  string
+	Token string
+	// Payload or PayloadDigest must be given for OTPs sent with a payload;
+	// the code only verifies if it matches the payload it was sent for.
+	Payload       map[string]interface{}
+	PayloadDigest string
+}
+
+// ValidateOTP verifies code for the OTP referenced by token. OTPs sent with a
+// payload must be verified with Validate instead.
+func (s *OTPService) ValidateOTP(ctx context.Context, otpCode string, payloadToken string) (map[string]interface{}, error) {
+	return s.Validate(ctx, ValidateOTPRequest{Code: otpCode, Token: payloadToken})
+}
+
+func (s *OTPService) Validate(ctx context.Context, req ValidateOTPRequest) (result map[string]interface{}, err error) {
+	purpose := ""
+	if s.metrics != nil {
+		defer func() {
+			s.metrics.VerificationCompleted(purpose, verificationOutcome(err))
+		}()
+	}
+
+	otpRef, err := otpRefFromToken(req.Token)
 	if err != nil {
-		return nil, errors.New("invalid UUID format for otp_ref")
+		return nil, err
 	}
 
-	otpInstance, err := s.repo.GetOTPByID(otpRef)
-	if err != nil || otpInstance == nil {
-		return nil, errors.New("OTP not found")
+	otpInstance, err := s.repo.GetOTPByID(ctx, otpRef)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load OTP: %w", err)
+	}
+	purpose = otpInstance.Purpose
+
+	if s.limiter != nil {
+		if err := s.limiter.AllowValidate(ctx, otpInstance.Purpose, ClientFromContext(ctx)); err != nil {
+			return nil, err
+		}
 	}
 
+	// The checks below only reject early; the store re-checks every
+	// condition atomically when recording the outcome.
+	now := time.Now()
 	if otpInstance.Status != OTPStatusPending {
-		return nil, errors.New("OTP is no longer valid")
+		return nil, ErrNoLongerValid
 	}
 
 	if otpInstance.RetryCount >= otpInstance.RetryLimit {
-		_ = s.repo.ExpireOTP(otpRef)
-		return nil, errors.New("maximum retry attempts reached, OTP expired")
+		if s.repo.ExpireOTP(ctx, otpRef) == nil {
+			s.emit(ctx, LifecycleEvent{Type: AuditExpired, OTP: *otpInstance, Reason: CodeMaxAttempts})
+		}
+		return nil, ErrMaxAttempts
 	}
 
-	if time.Now().After(otpInstance.ExpiresAt) {
-		_ = s.repo.UpdateRetryLimit(otpRef)
-		return nil, errors.New("OTP expired")
+	if !now.Before(otpInstance.ExpiresAt) {
+		if s.repo.ExpireOTP(ctx, otpRef) == nil {
+			s.emit(ctx, LifecycleEvent{Type: AuditExpired, OTP: *otpInstance, Reason: CodeExpired})
+		}
+		return nil, ErrExpired
 	}
 
-	if !utils.ValidateOTP(otpCode, otpInstance.HashedOTP, otpInstance.CreatedAt, otpInstance.ExpiresAt) {
-		_ = s.repo.UpdateRetryLimit(otpRef)
-		return nil, errors.New("invalid OTP provided")
+	digest := ""
+	if otpInstance.PayloadDigest != "" {
+		if digest, err = requestDigest(req); err != nil {
+			return nil, err
+		}
+		if digest != otpInstance.PayloadDigest {
+			return nil, s.recordFailedAttempt(ctx, otpRef, now, ErrPayloadMismatch)
+		}
+	}
+
+	otpCode := s.normalizeCode(req.Code, s.generatorFor(otpInstance.Purpose))
+	// Codes are single-use, so a hash from a previous hasher is not upgraded.
+	valid, _, err := s.hashers.Verify(boundCode(otpCode, digest), otpInstance.HashedOTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify OTP: %w", err)
+	}
+	if !valid {
+		return nil, s.recordFailedAttempt(ctx, otpRef, now, ErrInvalidCode)
 	}
 
 	var sanitizedPayload map[string]interface{}
 	if otpInstance.TransactionPayload != "" {
-		transactionPayload, err := utils.DecodeBase64(otpInstance.TransactionPayload)
+		transactionPayload, err := s.transactionPayload(otpInstance)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode transaction payload: %v", err)
+			return nil, fmt.Errorf("failed to decode transaction payload: %w", err)
 		}
 
 		sanitizedPayload, err = utils.SanitizePayload(transactionPayload)
 		if err != nil {
-			return nil, fmt.Errorf("failed to sanitize transaction payload: %v", err)
+			return nil, fmt.Errorf("failed to sanitize transaction payload: %w", err)
 		}
 	}
 
-	err = s.repo.UpdateOTPStatus(otpRef, OTPStatusVerified)
+	if err := s.repo.ConsumeOTP(ctx, otpRef, OTPStatusVerified, now); err != nil {
+		if errors.Is(err, ErrNoLongerValid) {
+			return nil, ErrNoLongerValid
+		}
+		return nil, fmt.Errorf("failed to update OTP status: %w", err)
+	}
+	s.emit(ctx, LifecycleEvent{Type: AuditVerified, OTP: *otpInstance})
+
+	// OTPs whose purpose has since been removed only report the status.
+	if policy, ok := s.purposes.Lookup(otpInstance.Purpose); ok && policy.Result == ResultPayload {
+		return sanitizedPayload, nil
+	}
+	return map[string]interface{}{"status": "OTP verified"}, nil
+}
+
+// RevokeOTP invalidates the still valid OTP referenced by token, e.g. when
+// the user cancels the operation it was sent for. It returns
+// ErrNoLongerValid if the OTP was already used or had expired.
+func (s *OTPService) RevokeOTP(ctx context.Context, token string) error {
+	otpRef, err := otpRefFromToken(token)
+	if err != nil {
+		return err
+	}
+	otpInstance, err := s.repo.GetOTPByID(ctx, otpRef)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to load OTP: %w", err)
+	}
+	if err := s.repo.ConsumeOTP(ctx, otpRef, OTPStatusExpired, time.Now()); err != nil {
+		if errors.Is(err, ErrNoLongerValid) {
+			return ErrNoLongerValid
+		}
+		return fmt.Errorf("failed to revoke OTP: %w", err)
+	}
+	s.emit(ctx, LifecycleEvent{Type: AuditRevoked, OTP: *otpInstance})
+	return nil
+}
+
+// recordFailedAttempt counts a failed attempt and returns the error of
+// reason's code reporting the attempts left.
+func (s *OTPService) recordFailedAttempt(ctx context.Context, otpRef uuid.UUID, now time.Time, reason *Error) error {
+	attempted, err := s.repo.RecordFailedAttempt(ctx, otpRef, now)
+	if err != nil {
+		if errors.Is(err, ErrNoLongerValid) {
+			return ErrNoLongerValid
+		}
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	s.emit(ctx, LifecycleEvent{
+		Type:              AuditAttemptFailed,
+		OTP:               *attempted,
+		Reason:            reason.Code,
+		RemainingAttempts: attempted.RetryLimit - attempted.RetryCount,
+	})
+	if attempted.Status == OTPStatusExpired {
+		s.emit(ctx, LifecycleEvent{Type: AuditExpired, OTP: *attempted, Reason: CodeMaxAttempts})
+	}
+	return &Error{
+		Code:              reason.Code,
+		Message:           reason.Message,
+		RemainingAttempts: attempted.RetryLimit - attempted.RetryCount,
+	}
+}
+
+// requestDigest returns the digest of the payload given with req.
+func requestDigest(req ValidateOTPRequest) (string, error) {
+	if req.Payload == nil {
+		if req.PayloadDigest == "" {
+			return "", newError(CodeInvalidRequest, "OTP is bound to a transaction payload, provide the payload or its digest", nil)
+		}
+		return req.PayloadDigest, nil
+	}
+	digest, err := PayloadDigest(req.Payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update OTP status: %v", err)
+		return "", newError(CodeInvalidRequest, "failed to digest transaction payload", err)
+	}
+	if req.PayloadDigest != "" && req.PayloadDigest != digest {
+		return "", newError(CodeInvalidRequest, "payload digest does not match the payload", nil)
+	}
+	return digest, nil
+}
+
+// normalizeCode strips display grouping from user input.
+func (s *OTPService) normalizeCode(code string, generator CodeGenerator) string {
+	if s.groupSep != "" {
+		code = strings.ReplaceAll(code, s.groupSep, "")
 	}
+	return generator.Normalize(code)
+}
 
-	if otpInstance.Purpose == "login" || otpInstance.Purpose == "register" {
-		return map[string]interface{}{"status": "OTP verified"}, nil
+// otpRefFromToken returns the OTP reference carried by a token issued by
+// SendOTP.
+func otpRefFromToken(token string) (uuid.UUID, error) {
+	payload, err := utils.ValidateToken(token)
+	if err != nil {
+		if errors.Is(err, utils.ErrTokenExpired) {
+			return uuid.Nil, newError(CodeExpired, ErrExpired.Message, err)
+		}
+		return uuid.Nil, newError(CodeInvalidRequest, "invalid token provided", err)
+	}
+	otpRefStr, ok := payload["otp_ref"].(string)
+	if !ok {
+		return uuid.Nil, newError(CodeInvalidRequest, "invalid otp_ref format", nil)
 	}
 
-	return sanitizedPayload, nil
+	otpRef, err := uuid.Parse(otpRefStr)
+	if err != nil {
+		return uuid.Nil, newError(CodeInvalidRequest, "invalid UUID format for otp_ref", err)
+	}
+	return otpRef, nil
 }
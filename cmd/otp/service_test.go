@@ -0,0 +1,43 @@
+package otp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+)
+
+// expiredHooks records OnExpired events.
+type expiredHooks struct {
+	otp.NoopHooks
+	events *[]otp.LifecycleEvent
+}
+
+func (h expiredHooks) OnExpired(ctx context.Context, event otp.LifecycleEvent) {
+	*h.events = append(*h.events, event)
+}
+
+func TestSendDeliveryFailure(t *testing.T) {
+	sms := &stubSMS{err: errors.New("gateway down")}
+	var expired []otp.LifecycleEvent
+	service, repo := newService(t, sms, otp.WithHooks(expiredHooks{events: &expired}))
+	ctx := context.Background()
+
+	if _, err := service.Send(ctx, sendRequest()); !errors.Is(err, otp.ErrDeliveryFailed) {
+		t.Fatalf("Send error = %v, want ErrDeliveryFailed", err)
+	}
+	if len(expired) != 1 || expired[0].Reason != otp.CodeDeliveryFailed {
+		t.Fatalf("expired events = %+v, want one for the failed delivery", expired)
+	}
+	record, err := repo.GetOTPByID(ctx, expired[0].OTP.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != otp.OTPStatusExpired {
+		t.Errorf("undelivered OTP has status %s, want %s", record.Status, otp.OTPStatusExpired)
+	}
+	if _, err := repo.GetValidOTPByPurpose(ctx, record.MobileNumber, "login"); !errors.Is(err, otp.ErrNotFound) {
+		t.Errorf("GetValidOTPByPurpose error = %v, want ErrNotFound", err)
+	}
+}
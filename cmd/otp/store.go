@@ -0,0 +1,76 @@
+package otp
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OTPStore persists OTP records. Implementations return ErrNotFound (possibly
+// wrapped) when a record does not exist, must be safe for concurrent use and
+// should abandon work once ctx is done.
+type OTPStore interface {
+	// SaveOTP inserts otp, assigning an ID if it has none and setting its
+	// timestamps.
+	SaveOTP(ctx context.Context, otp *OTP) error
+	GetOTPByID(ctx context.Context, id uuid.UUID) (*OTP, error)
+	// GetValidOTPByPurpose returns the most recent PENDING OTP sent to
+	// recipient (a mobile number or email) for purpose.
+	GetValidOTPByPurpose(ctx context.Context, recipient, purpose string) (*OTP, error)
+	UpdateOTPStatus(ctx context.Context, id uuid.UUID, status string) error
+	UpdateHashedOTP(ctx context.Context, id uuid.UUID, hashedOTP string) error
+	IncrementRetryCount(ctx context.Context, id uuid.UUID) error
+
+	// ConsumeOTP atomically sets status on an OTP that, at now, is still
+	// PENDING, unexpired and below its retry limit, and returns
+	// ErrNoLongerValid otherwise. Of several concurrent calls for the same
+	// OTP at most one succeeds.
+	ConsumeOTP(ctx context.Context, id uuid.UUID, status string, now time.Time) error
+	// ExpireOTP atomically marks an OTP EXPIRED if it is still PENDING,
+	// whatever its expiry and retry count, and returns ErrNoLongerValid
+	// otherwise, so an OTP verified concurrently stays VERIFIED.
+	ExpireOTP(ctx context.Context, id uuid.UUID) error
+	// RecordFailedAttempt atomically increments the retry count of an OTP
+	// satisfying the same conditions as ConsumeOTP, marking it EXPIRED when
+	// the limit is reached, and returns the updated record. It returns
+	// ErrNoLongerValid if the conditions do not hold.
+	RecordFailedAttempt(ctx context.Context, id uuid.UUID, now time.Time) (*OTP, error)
+	// RecordResend atomically replaces the hash of a PENDING, unexpired OTP
+	// whose ResendCount still equals resendCount and is below MaxResends,
+	// incrementing ResendCount and setting Delivery and LastSentAt to now.
+	// It returns ErrNoLongerValid if the conditions do not hold, so two
+	// resends based on the same read cannot both succeed.
+	RecordResend(ctx context.Context, id uuid.UUID, resendCount int, hashedOTP, delivery string, now time.Time) (*OTP, error)
+	// RevertResend undoes a RecordResend whose code was never delivered:
+	// if the OTP's ResendCount still equals resendCount, the count that
+	// RecordResend left, it restores HashedOTP, Delivery, ResendCount and
+	// LastSentAt from previous. It returns ErrNoLongerValid if a later
+	// resend changed the record.
+	RevertResend(ctx context.Context, id uuid.UUID, resendCount int, previous *OTP) error
+}
+
+// PayloadRotationStore is implemented by stores whose transaction payloads
+// can be re-encrypted in bulk by RotatePayloadKeys.
+type PayloadRotationStore interface {
+	// ListPayloadsToRotate returns up to limit records, ordered by ID and
+	// with IDs greater than after, that have a transaction payload not
+	// sealed with keyID. Unencrypted payloads have an empty key ID.
+	ListPayloadsToRotate(ctx context.Context, keyID string, after uuid.UUID, limit int) ([]*OTP, error)
+	// UpdatePayload replaces the payload of a record still sealed with
+	// oldKeyID and reports whether it did.
+	UpdatePayload(ctx context.Context, id uuid.UUID, oldKeyID, keyID, payload string) (bool, error)
+}
+
+// RecipientDataStore is implemented by stores that can serve privacy
+// requests for a recipient.
+type RecipientDataStore interface {
+	// ListByRecipient returns every record whose mobile number or email is
+	// recipient, including used and expired ones.
+	ListByRecipient(ctx context.Context, recipient string) ([]*OTP, error)
+	// RedactOTP replaces the mobile number and email of a record, clears its
+	// transaction payload, payload digest and message contents, and marks it
+	// EXPIRED if it is still PENDING. Other fields, such as purpose, status
+	// and counters, are kept.
+	RedactOTP(ctx context.Context, id uuid.UUID, mobileNumber, email string) error
+}
@@ -0,0 +1,253 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSweepInterval  = time.Minute
+	DefaultSweepBatchSize = 500
+)
+
+// MaintenanceStore is implemented by stores that Sweeper can clean up.
+// Stores whose records expire natively, like the Redis store, need no
+// sweeping.
+type MaintenanceStore interface {
+	// ExpireOverdue marks up to limit PENDING records whose ExpiresAt is
+	// not after now as EXPIRED and returns the records it changed, as
+	// updated.
+	ExpireOverdue(ctx context.Context, now time.Time, limit int) ([]*OTP, error)
+	// ListPurgeable returns up to limit records with status created before
+	// cutoff.
+	ListPurgeable(ctx context.Context, status string, cutoff time.Time, limit int) ([]*OTP, error)
+	// DeleteOTPs deletes the records with the given IDs and returns how many
+	// existed.
+	DeleteOTPs(ctx context.Context, ids []uuid.UUID) (int, error)
+}
+
+// Archiver keeps copies of records before Sweeper deletes them.
+type Archiver interface {
+	Archive(ctx context.Context, records []*OTP) error
+}
+
+type SweeperConfig struct {
+	// Interval between runs; DefaultSweepInterval if zero.
+	Interval time.Duration
+	// BatchSize bounds how many records one statement touches, keeping
+	// locks short; DefaultSweepBatchSize if zero.
+	BatchSize int
+	// Retention maps a status to how long after creation records with it
+	// are kept. Records with other statuses are never purged.
+	Retention map[string]time.Duration
+	// Archiver, if set, receives records before they are deleted. A batch
+	// is only deleted once it was archived.
+	Archiver Archiver
+	// Service, if set, reports every OTP the sweeper expires as an
+	// AuditExpired event, to its hooks, audit log and logger, as it does
+	// for OTPs Validate finds expired.
+	Service *OTPService
+}
+
+// SweepResult counts the records changed by one run.
+type SweepResult struct {
+	Expired  int
+	Archived int
+	Purged   int
+}
+
+// SweepStats describes the runs of a Sweeper so far.
+type SweepStats struct {
+	Runs         int
+	Failures     int
+	Total        SweepResult
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastResult   SweepResult
+	LastError    error
+}
+
+// Sweeper periodically expires overdue PENDING OTPs and purges records
+// older than their status's retention period.
+type Sweeper struct {
+	store  MaintenanceStore
+	config SweeperConfig
+
+	mu      sync.Mutex
+	stats   SweepStats
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running bool
+}
+
+// NewSweeper creates a stopped sweeper for store.
+func NewSweeper(store MaintenanceStore, config SweeperConfig) *Sweeper {
+	if config.Interval <= 0 {
+		config.Interval = DefaultSweepInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultSweepBatchSize
+	}
+	return &Sweeper{store: store, config: config}
+}
+
+// Start runs the sweeper every interval in the background until Stop is
+// called. Starting a running sweeper does nothing.
+func (s *Sweeper) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done, s.running = cancel, make(chan struct{}), true
+
+	go func(done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			s.RunOnce(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(s.done)
+}
+
+// Stop cancels the current run and waits for the sweeper to finish, or for
+// ctx to be done.
+func (s *Sweeper) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	cancel, done := s.cancel, s.done
+	s.running = false
+	s.mu.Unlock()
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the statistics of the runs so far.
+func (s *Sweeper) Stats() SweepStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// RunOnce expires overdue OTPs and purges old records now, batch by batch,
+// and records the run in Stats.
+func (s *Sweeper) RunOnce(ctx context.Context) (SweepResult, error) {
+	start := time.Now()
+	result, err := s.sweep(ctx, start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Runs++
+	if err != nil {
+		s.stats.Failures++
+	}
+	s.stats.Total.Expired += result.Expired
+	s.stats.Total.Archived += result.Archived
+	s.stats.Total.Purged += result.Purged
+	s.stats.LastRun = start
+	s.stats.LastDuration = time.Since(start)
+	s.stats.LastResult = result
+	s.stats.LastError = err
+	return result, err
+}
+
+func (s *Sweeper) sweep(ctx context.Context, now time.Time) (SweepResult, error) {
+	var result SweepResult
+	for {
+		expired, err := s.store.ExpireOverdue(ctx, now, s.config.BatchSize)
+		result.Expired += len(expired)
+		if s.config.Service != nil {
+			for _, record := range expired {
+				s.config.Service.emit(ctx, LifecycleEvent{Type: AuditExpired, OTP: *record, Reason: CodeExpired})
+			}
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to expire overdue OTPs: %w", err)
+		}
+		if len(expired) < s.config.BatchSize {
+			break
+		}
+	}
+
+	var errs []error
+	for status, retention := range s.config.Retention {
+		if err := s.purge(ctx, status, now.Add(-retention), &result); err != nil {
+			errs = append(errs, fmt.Errorf("failed to purge %s OTPs: %w", status, err))
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *Sweeper) purge(ctx context.Context, status string, cutoff time.Time, result *SweepResult) error {
+	for {
+		records, err := s.store.ListPurgeable(ctx, status, cutoff, s.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		if s.config.Archiver != nil {
+			if err := s.config.Archiver.Archive(ctx, records); err != nil {
+				return fmt.Errorf("failed to archive: %w", err)
+			}
+			result.Archived += len(records)
+		}
+		ids := make([]uuid.UUID, len(records))
+		for i, record := range records {
+			ids[i] = record.ID
+		}
+		purged, err := s.store.DeleteOTPs(ctx, ids)
+		result.Purged += purged
+		if err != nil {
+			return err
+		}
+		if purged == 0 || len(records) < s.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// ParseRetention parses retention periods written as comma-separated
+// "<status>=<duration>" pairs, e.g. "verified=720h,EXPIRED=168h".
+func ParseRetention(spec string) (map[string]time.Duration, error) {
+	retention := make(map[string]time.Duration)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		status, value, ok := strings.Cut(pair, "=")
+		if !ok || status == "" {
+			return nil, fmt.Errorf("invalid retention %q: want <status>=<duration>", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid retention %q: bad duration", pair)
+		}
+		retention[strings.TrimSpace(status)] = d
+	}
+	return retention, nil
+}
@@ -0,0 +1,52 @@
+package otp_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func TestSweeperReportsExpiries(t *testing.T) {
+	sink, err := repository.NewJSONLAuditSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	var expired []otp.LifecycleEvent
+	service, repo := newService(t, &stubSMS{},
+		otp.WithHooks(expiredHooks{events: &expired}),
+		otp.WithAuditLog(otp.NewAuditLog(sink)))
+	ctx := context.Background()
+
+	overdue, fresh := newRecord(), newRecord()
+	overdue.ExpiresAt = time.Now().Add(-time.Minute)
+	for _, record := range []*otp.OTP{overdue, fresh} {
+		if err := repo.SaveOTP(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweeper := otp.NewSweeper(repo, otp.SweeperConfig{Service: service})
+	if result, err := sweeper.RunOnce(ctx); err != nil || result.Expired != 1 {
+		t.Fatalf("RunOnce = %+v, %v; want 1 expired", result, err)
+	}
+	if len(expired) != 1 || expired[0].OTP.ID != overdue.ID || expired[0].Reason != otp.CodeExpired {
+		t.Fatalf("expired events = %+v, want one for the overdue OTP", expired)
+	}
+	if expired[0].OTP.Status != otp.OTPStatusExpired {
+		t.Errorf("event OTP status = %s, want %s", expired[0].OTP.Status, otp.OTPStatusExpired)
+	}
+
+	events, err := sink.Read(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != otp.AuditExpired || events[0].OTPID != overdue.ID ||
+		events[0].Metadata["reason"] != string(otp.CodeExpired) {
+		t.Errorf("audit events = %+v, want one expiry of the overdue OTP", events)
+	}
+}
@@ -0,0 +1,338 @@
+package otp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultTemplate names the template used for purposes that have none of
+// their own.
+const DefaultTemplate = "default"
+
+// MessageTemplate is a named, server-side message template. Subject and
+// Text are text/template templates and HTML an html/template template, all
+// executed with TemplateData, e.g. "Your {{.AppName}} code is {{.Code}}".
+//
+// A purpose uses the template named by its policy's Template, or else the
+// template named after the purpose, or else DefaultTemplate. Templates of
+// one name may differ per channel: the one registered for the channel
+// being delivered to is preferred over the one with an empty Channel.
+type MessageTemplate struct {
+	Name string
+	// Channel is a channel name such as DeliverySMS; empty applies to every
+	// channel without a template of its own.
+	Channel string
+	// Subject and HTML are only used by email channels.
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// TemplateData holds the variables available to templates.
+type TemplateData struct {
+	// Code is the code as displayed, i.e. grouped if grouping is enabled.
+	Code string
+	// ExpiryMinutes is the number of minutes left until the code expires,
+	// rounded up.
+	ExpiryMinutes int
+	Purpose       string
+	Channel       string
+	Locale        string
+	AppName       string
+	// Recipient is the masked mobile number or email the message goes to.
+	Recipient string
+	// Amount, Currency and Payee are the transaction fields of a bound
+	// OTP's payload; Transaction holds every top-level payload field.
+	Amount      string
+	Currency    string
+	Payee       string
+	Transaction map[string]string
+}
+
+// sampleTemplateData is what templates are executed with when they are
+// registered. The code is distinctive so templates that do not show it are
+// caught.
+var sampleTemplateData = TemplateData{
+	Code:          "7Q4X-9K2M",
+	ExpiryMinutes: 5,
+	Purpose:       "login",
+	Channel:       DeliverySMS,
+	Locale:        "en",
+	AppName:       "App",
+	Recipient:     "+********89",
+	Amount:        "10.00",
+	Currency:      "EUR",
+	Payee:         "ACME Ltd",
+	Transaction:   map[string]string{"amount": "10.00", "currency": "EUR", "payee": "ACME Ltd"},
+}
+
+type templateKey struct {
+	name    string
+	channel string
+}
+
+// compiledTemplate is a parsed MessageTemplate; subject and html are nil
+// when empty.
+type compiledTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Validate parses t and executes it with sample data. It fails if a
+// template does not parse, refers to an unknown variable or never shows the
+// code.
+func (t MessageTemplate) Validate() error {
+	_, err := compileTemplate(t)
+	return err
+}
+
+func compileTemplate(t MessageTemplate) (*compiledTemplate, error) {
+	if t.Name == "" || strings.ContainsAny(t.Name, " \t\n") {
+		return nil, fmt.Errorf("invalid message template name %q", t.Name)
+	}
+	if t.Channel != "" && !validChannelName(t.Channel) {
+		return nil, fmt.Errorf("template %q: invalid delivery channel name %q", t.Name, t.Channel)
+	}
+	if strings.TrimSpace(t.Text) == "" {
+		return nil, fmt.Errorf("template %q: text is required", t.Name)
+	}
+
+	var compiled compiledTemplate
+	var err error
+	if compiled.text, err = parseText(t.Name+".text", t.Text); err != nil {
+		return nil, fmt.Errorf("template %q: %w", t.Name, err)
+	}
+	if t.Subject != "" {
+		if compiled.subject, err = parseText(t.Name+".subject", t.Subject); err != nil {
+			return nil, fmt.Errorf("template %q: %w", t.Name, err)
+		}
+	}
+	if t.HTML != "" {
+		compiled.html, err = htmltemplate.New(t.Name + ".html").Option("missingkey=zero").Parse(t.HTML)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", t.Name, err)
+		}
+	}
+
+	_, text, html, err := compiled.execute(sampleTemplateData)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", t.Name, err)
+	}
+	if !strings.Contains(text, sampleTemplateData.Code) {
+		return nil, fmt.Errorf("template %q: text does not show {{.Code}}", t.Name)
+	}
+	if compiled.html != nil && !strings.Contains(html, sampleTemplateData.Code) {
+		return nil, fmt.Errorf("template %q: HTML does not show {{.Code}}", t.Name)
+	}
+	return &compiled, nil
+}
+
+// parseText parses a text template. Missing transaction fields render
+// empty rather than as "<no value>".
+func parseText(name, text string) (*texttemplate.Template, error) {
+	return texttemplate.New(name).Option("missingkey=zero").Parse(text)
+}
+
+func (t *compiledTemplate) execute(data TemplateData) (subject, text, html string, err error) {
+	var buf bytes.Buffer
+	if t.subject != nil {
+		if err := t.subject.Execute(&buf, data); err != nil {
+			return "", "", "", err
+		}
+		// Subjects are a single header line.
+		subject = strings.Join(strings.Fields(buf.String()), " ")
+		buf.Reset()
+	}
+	if err := t.text.Execute(&buf, data); err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.Execute(&buf, data); err != nil {
+			return "", "", "", err
+		}
+		html = buf.String()
+	}
+	return subject, text, html, nil
+}
+
+// TemplateRegistry holds the message templates OTPs are rendered with. It
+// is safe for concurrent use.
+type TemplateRegistry struct {
+	mu        sync.RWMutex
+	templates map[templateKey]*compiledTemplate
+}
+
+// NewTemplateRegistry creates a registry holding templates.
+func NewTemplateRegistry(templates ...MessageTemplate) (*TemplateRegistry, error) {
+	r := &TemplateRegistry{templates: make(map[templateKey]*compiledTemplate)}
+	for _, t := range templates {
+		if err := r.Register(t); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register validates t and adds it, replacing any template with the same
+// name and channel.
+func (r *TemplateRegistry) Register(t MessageTemplate) error {
+	compiled, err := compileTemplate(t)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.templates[templateKey{name: t.Name, channel: t.Channel}] = compiled
+	r.mu.Unlock()
+	return nil
+}
+
+// Has reports whether a template named name is registered for any channel.
+func (r *TemplateRegistry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for key := range r.templates {
+		if key.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the registered template names, sorted.
+func (r *TemplateRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var names []string
+	for key := range r.templates {
+		if !seen[key.name] {
+			seen[key.name] = true
+			names = append(names, key.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the first of names registered for channel or for every
+// channel.
+func (r *TemplateRegistry) lookup(names []string, channel string) (*compiledTemplate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, name := range names {
+		if t, ok := r.templates[templateKey{name: name, channel: channel}]; ok {
+			return t, true
+		}
+		if t, ok := r.templates[templateKey{name: name}]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// messageTemplateJSON is the configuration file form of a MessageTemplate.
+type messageTemplateJSON struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// LoadMessageTemplates decodes a JSON array of message templates:
+//
+//	[{"name": "default", "text": "Your {{.AppName}} code is {{.Code}}"},
+//	 {"name": "default", "channel": "EMAIL", "subject": "Your {{.AppName}} code",
+//	  "text": "Your code is {{.Code}}. It expires in {{.ExpiryMinutes}} minutes.",
+//	  "html": "<p>Your code is <b>{{.Code}}</b>.</p>"}]
+func LoadMessageTemplates(r io.Reader) ([]MessageTemplate, error) {
+	var decoded []messageTemplateJSON
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode message templates: %w", err)
+	}
+	templates := make([]MessageTemplate, len(decoded))
+	for i, t := range decoded {
+		templates[i] = MessageTemplate{Name: t.Name, Channel: t.Channel, Subject: t.Subject, Text: t.Text, HTML: t.HTML}
+	}
+	return templates, nil
+}
+
+// WithTemplates renders messages from registry. Message bodies given in a
+// request still take precedence over the templates.
+func WithTemplates(registry *TemplateRegistry) Option {
+	return func(s *OTPService) {
+		s.templates = registry
+	}
+}
+
+// WithAppName sets the AppName shown by templates.
+func WithAppName(name string) Option {
+	return func(s *OTPService) {
+		s.appName = name
+	}
+}
+
+// errNoTemplate is returned when a message is rendered for a channel that
+// has neither a request body nor a template.
+var errNoTemplate = errors.New("no message template for channel")
+
+// templateNames returns the names of the templates otp may be rendered
+// with, most specific first.
+func (s *OTPService) templateNames(otp *OTP) []string {
+	var names []string
+	if otp.Template != "" {
+		names = append(names, otp.Template)
+	}
+	if policy, ok := s.purposes.Lookup(otp.Purpose); ok && policy.Template != "" {
+		names = append(names, policy.Template)
+	} else {
+		names = append(names, otp.Purpose)
+	}
+	return append(names, DefaultTemplate)
+}
+
+// templateFor returns the template that renders otp for channel.
+func (s *OTPService) templateFor(otp *OTP, channel string) (*compiledTemplate, bool) {
+	if s.templates == nil {
+		return nil, false
+	}
+	return s.templates.lookup(s.templateNames(otp), channel)
+}
+
+// templateData returns the variables otp is rendered with for channel.
+func (s *OTPService) templateData(otp *OTP, channel, displayOTP string, boundPayload map[string]interface{}) TemplateData {
+	data := TemplateData{
+		Code:          displayOTP,
+		ExpiryMinutes: max(0, int(math.Ceil(time.Until(otp.ExpiresAt).Minutes()))),
+		Purpose:       otp.Purpose,
+		Channel:       channel,
+		Locale:        otp.Locale,
+		AppName:       s.appName,
+		Recipient:     MaskRecipient(s.recipientFor(otp, channel)),
+	}
+	if boundPayload != nil {
+		data.Amount = payloadString(boundPayload, PayloadAmount)
+		data.Currency = payloadString(boundPayload, PayloadCurrency)
+		data.Payee = payloadString(boundPayload, PayloadPayee)
+		data.Transaction = make(map[string]string, len(boundPayload))
+		for key := range boundPayload {
+			data.Transaction[key] = payloadString(boundPayload, key)
+		}
+	}
+	return data
+}
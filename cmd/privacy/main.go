@@ -0,0 +1,114 @@
+// Command privacy serves data subject requests for a recipient: it exports
+// every stored OTP record for a mobile number or email as JSON, or erases
+// the recipient from them.
+//
+//	go run ./cmd/privacy export -recipient +15551234567 -out export.json
+//	go run ./cmd/privacy erase -recipient user@example.com -mode pseudonymize
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/Zaman-R/otp-validator/cmd/client"
+	"github.com/Zaman-R/otp-validator/cmd/config"
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: privacy export -recipient <mobile or email> [-out file]")
+	fmt.Fprintln(os.Stderr, "       privacy erase -recipient <mobile or email> [-mode redact|pseudonymize]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	recipient := flags.String("recipient", "", "mobile number or email to export or erase")
+	out := flags.String("out", "", "file to write the export to (default: stdout)")
+	mode := flags.String("mode", string(otp.ErasureRedact), "erasure mode: redact or pseudonymize")
+	flags.Parse(os.Args[2:])
+	if *recipient == "" {
+		usage()
+	}
+
+	config.LoadConfig()
+	service := newService()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch os.Args[1] {
+	case "export":
+		export, err := service.ExportRecipientData(ctx, *recipient)
+		if err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", *out, err)
+			}
+			defer file.Close()
+			w = file
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			log.Fatalf("Failed to write export: %v", err)
+		}
+		log.Printf("Exported %d records", len(export.Records))
+	case "erase":
+		erased, err := service.EraseRecipientData(ctx, *recipient, otp.ErasureMode(*mode))
+		log.Printf("Erased the recipient from %d records", erased)
+		if err != nil {
+			log.Fatalf("Erasure failed: %v", err)
+		}
+	default:
+		usage()
+	}
+}
+
+// newService builds a service over the configured store, plus the archive
+// when archiving is enabled. Messages are never sent, so the default
+// providers suffice.
+func newService() *otp.OTPService {
+	keyring, err := otp.NewKeyringFromConfig(config.ConfigOTP.PayloadKeyring, config.ConfigOTP.PayloadPrimaryKey, config.ConfigOTP.PayloadKeys)
+	if err != nil {
+		log.Fatalf("Failed to load payload keyring: %v", err)
+	}
+	opts := []otp.Option{
+		otp.WithPayloadKeyring(keyring),
+		otp.WithPseudonymKey([]byte(config.ConfigOTP.PseudonymKey)),
+	}
+
+	var store otp.OTPStore
+	switch config.AppConfig.OTPStore {
+	case "redis":
+		store = repository.NewRedisOTPRepository(redis.NewClient(redis.Options{
+			Addr:     config.AppConfig.RedisAddr,
+			Password: config.AppConfig.RedisPassword,
+			DB:       config.AppConfig.RedisDB,
+		}), config.AppConfig.RedisPrefix)
+	case "memory":
+		log.Fatal("The memory store is not shared between processes; nothing to export or erase")
+	default:
+		config.ConnectDB()
+		store = repository.NewOTPRepository(config.GetDB().GetDB())
+		if config.ConfigOTP.Sweep.Archive {
+			opts = append(opts, otp.WithPrivacyStores(repository.NewOTPArchiveRepository(config.GetDB().GetDB())))
+		}
+	}
+	return otp.NewOTPService(store, client.NewCustomSMSProvider(), client.NewCustomEmailProvider(), opts...)
+}
@@ -0,0 +1,255 @@
+// Package redis is a small client for servers speaking the Redis protocol
+// (RESP2). It covers what the OTP store needs: plain commands, connection
+// pooling and optimistic WATCH/MULTI/EXEC transactions.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	// Nil is returned by the reply helpers when the server sent a null reply.
+	Nil = errors.New("redis: nil")
+	// ErrTxAborted is returned by Conn.Exec when a watched key changed.
+	ErrTxAborted = errors.New("redis: transaction aborted, watched key changed")
+)
+
+type Options struct {
+	Addr        string
+	Password    string
+	DB          int
+	DialTimeout time.Duration
+	PoolSize    int
+}
+
+// Client is a pool of connections to one server. It is safe for concurrent use.
+type Client struct {
+	opts Options
+	idle chan *Conn
+}
+
+func NewClient(opts Options) *Client {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = 10
+	}
+	return &Client{opts: opts, idle: make(chan *Conn, opts.PoolSize)}
+}
+
+// Do sends one command on a pooled connection and returns its reply. Error
+// replies are returned as err with type Error.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.Do(ctx, args...)
+	c.put(conn)
+	return reply, err
+}
+
+// Exec runs cmds atomically inside MULTI/EXEC on a pooled connection.
+func (c *Client) Exec(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.Exec(ctx, cmds...)
+	c.put(conn)
+	return replies, err
+}
+
+// Watch WATCHes keys on a dedicated connection and runs fn with it. fn
+// should read the keys and finish with Conn.Exec; the watch is cleared
+// when Watch returns.
+func (c *Client) Watch(ctx context.Context, fn func(conn *Conn) error, keys ...string) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"WATCH"}, keys...)
+	if _, err = conn.Do(ctx, args...); err == nil {
+		err = fn(conn)
+		if _, unwatchErr := conn.Do(ctx, "UNWATCH"); unwatchErr != nil {
+			err = errors.Join(err, unwatchErr)
+		}
+	}
+	c.put(conn)
+	return err
+}
+
+func (c *Client) Close() error {
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*Conn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := newConn(netConn)
+	if c.opts.Password != "" {
+		if _, err := conn.Do(ctx, "AUTH", c.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := conn.Do(ctx, "SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// put returns conn to the pool unless a failed round trip left it in an
+// unknown state.
+func (c *Client) put(conn *Conn) {
+	if conn.broken {
+		conn.Close()
+		return
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Conn is a single connection. It is not safe for concurrent use.
+type Conn struct {
+	netConn net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	broken  bool
+}
+
+func newConn(netConn net.Conn) *Conn {
+	return &Conn{netConn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+}
+
+// Do sends one command and waits for its reply, honoring ctx's deadline and
+// cancellation.
+func (c *Conn) Do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	c.netConn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		c.netConn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.broken = true
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	if replyErr, ok := reply.(Error); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+func (c *Conn) roundTrip(args []string) (interface{}, error) {
+	if err := WriteCommand(c.w, args...); err != nil {
+		return nil, err
+	}
+	return ReadReply(c.r)
+}
+
+// Exec runs cmds inside MULTI/EXEC and returns their replies. It returns
+// ErrTxAborted if a key watched on this connection changed.
+func (c *Conn) Exec(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	if _, err := c.Do(ctx, "MULTI"); err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		if _, err := c.Do(ctx, cmd...); err != nil {
+			c.Do(ctx, "DISCARD")
+			return nil, err
+		}
+	}
+	reply, err := c.Do(ctx, "EXEC")
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrTxAborted
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected EXEC reply %T", reply)
+	}
+	for _, r := range replies {
+		if replyErr, ok := r.(Error); ok {
+			return replies, replyErr
+		}
+	}
+	return replies, nil
+}
+
+func (c *Conn) Close() error {
+	return c.netConn.Close()
+}
+
+// String converts a reply to a string, returning Nil for null replies.
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return "", Nil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+}
+
+// Int converts a reply to an int64, returning Nil for null replies.
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return 0, Nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+}
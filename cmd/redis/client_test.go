@@ -0,0 +1,166 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+	"github.com/Zaman-R/otp-validator/cmd/redis/redistest"
+)
+
+func newServer(t *testing.T) (*redistest.Server, *redis.Client) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func TestClientDo(t *testing.T) {
+	_, client := newServer(t)
+	ctx := context.Background()
+
+	if _, err := client.Do(ctx, "SET", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := redis.String(client.Do(ctx, "GET", "key")); err != nil || got != "value" {
+		t.Errorf("GET key = %q, %v; want value", got, err)
+	}
+	if _, err := redis.String(client.Do(ctx, "GET", "missing")); !errors.Is(err, redis.Nil) {
+		t.Errorf("GET missing error = %v, want redis.Nil", err)
+	}
+	if got, err := redis.Int(client.Do(ctx, "INCR", "counter")); err != nil || got != 1 {
+		t.Errorf("INCR counter = %d, %v; want 1", got, err)
+	}
+
+	var replyErr redis.Error
+	if _, err := client.Do(ctx, "INCR", "key"); !errors.As(err, &replyErr) {
+		t.Errorf("INCR on a string error = %v, want a redis.Error", err)
+	}
+	// An error reply leaves the connection usable.
+	if got, err := redis.String(client.Do(ctx, "PING")); err != nil || got != "PONG" {
+		t.Errorf("PING after an error reply = %q, %v", got, err)
+	}
+}
+
+func TestClientDoCanceled(t *testing.T) {
+	_, client := newServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Do(ctx, "PING"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do with a canceled context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestClientExec(t *testing.T) {
+	_, client := newServer(t)
+	ctx := context.Background()
+
+	replies, err := client.Exec(ctx, []string{"SET", "a", "1"}, []string{"INCR", "a"}, []string{"GET", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || replies[1] != int64(2) || replies[2] != "2" {
+		t.Errorf("Exec replies = %#v", replies)
+	}
+}
+
+func TestClientWatchAborts(t *testing.T) {
+	_, client := newServer(t)
+	ctx := context.Background()
+	client.Do(ctx, "SET", "key", "1")
+
+	err := client.Watch(ctx, func(conn *redis.Conn) error {
+		// Another client changes the watched key before EXEC.
+		if _, err := client.Do(ctx, "SET", "key", "2"); err != nil {
+			return err
+		}
+		_, err := conn.Exec(ctx, []string{"SET", "key", "3"})
+		return err
+	}, "key")
+	if !errors.Is(err, redis.ErrTxAborted) {
+		t.Fatalf("Watch error = %v, want redis.ErrTxAborted", err)
+	}
+	if got, _ := redis.String(client.Do(ctx, "GET", "key")); got != "2" {
+		t.Errorf("key = %q after an aborted transaction, want 2", got)
+	}
+
+	// The watch is cleared when Watch returns, so the pooled connection
+	// can run transactions again.
+	err = client.Watch(ctx, func(conn *redis.Conn) error {
+		_, err := conn.Exec(ctx, []string{"SET", "key", "4"})
+		return err
+	}, "key")
+	if err != nil {
+		t.Errorf("Watch without a concurrent write: %v", err)
+	}
+}
+
+func TestClientWatchConcurrentIncrements(t *testing.T) {
+	_, client := newServer(t)
+	ctx := context.Background()
+	client.Do(ctx, "SET", "counter", "0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := client.Watch(ctx, func(conn *redis.Conn) error {
+					n, err := redis.Int(conn.Do(ctx, "GET", "counter"))
+					if err != nil {
+						return err
+					}
+					_, err = conn.Exec(ctx, []string{"SET", "counter", strconv.FormatInt(n+1, 10)})
+					return err
+				}, "counter")
+				if !errors.Is(err, redis.ErrTxAborted) {
+					if err != nil {
+						t.Error(err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := redis.Int(client.Do(ctx, "GET", "counter")); got != 10 {
+		t.Errorf("counter = %d after 10 optimistic increments, want 10", got)
+	}
+}
+
+func TestServerKeepTTLAndExpiry(t *testing.T) {
+	server, client := newServer(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetClock(func() time.Time { return now })
+
+	expireAt := now.Add(time.Minute).UnixMilli()
+	client.Do(ctx, "SET", "key", "1", "PXAT", strconv.FormatInt(expireAt, 10))
+	client.Do(ctx, "SET", "key", "2", "KEEPTTL")
+	if ttl, _ := redis.Int(client.Do(ctx, "PTTL", "key")); ttl <= 0 || ttl > time.Minute.Milliseconds() {
+		t.Errorf("PTTL after SET KEEPTTL = %d, want the original TTL", ttl)
+	}
+	client.Do(ctx, "SET", "plain", "1", "PXAT", strconv.FormatInt(expireAt, 10))
+	client.Do(ctx, "SET", "plain", "2")
+	if ttl, _ := redis.Int(client.Do(ctx, "PTTL", "plain")); ttl != -1 {
+		t.Errorf("PTTL after SET without KEEPTTL = %d, want -1", ttl)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := redis.String(client.Do(ctx, "GET", "key")); !errors.Is(err, redis.Nil) {
+		t.Errorf("GET of an expired key error = %v, want redis.Nil", err)
+	}
+}
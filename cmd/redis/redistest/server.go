@@ -0,0 +1,471 @@
+// Package redistest provides an in-process stand-in for a Redis server,
+// for use in tests of code built on the redis package.
+package redistest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+)
+
+// Server implements the subset of Redis commands used by this module:
+// PING, AUTH, SELECT, GET, SET (EX, PX, EXAT, PXAT, NX, XX, KEEPTTL), DEL,
+// EXISTS, INCR, PEXPIREAT, PTTL, KEYS, FLUSHALL, WATCH, UNWATCH, MULTI, EXEC
+// and DISCARD. Keys expire on access, like Redis' lazy expiry.
+type Server struct {
+	Addr string
+
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]*entry
+	versions map[string]uint64
+	now      func() time.Time
+	conns    sync.WaitGroup
+	closed   chan struct{}
+}
+
+type entry struct {
+	value    string
+	expireAt time.Time
+}
+
+// NewServer starts a server listening on a random loopback port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		data:     make(map[string]*entry),
+		versions: make(map[string]uint64),
+		now:      time.Now,
+		closed:   make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Client returns a client connected to the server.
+func (s *Server) Client() *redis.Client {
+	return redis.NewClient(redis.Options{Addr: s.Addr})
+}
+
+// SetClock replaces the clock used for key expiry.
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	s.now = now
+	s.mu.Unlock()
+}
+
+func (s *Server) Close() error {
+	close(s.closed)
+	err := s.listener.Close()
+	s.conns.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Add(1)
+		go s.handle(conn)
+	}
+}
+
+type session struct {
+	watched map[string]uint64
+	queue   [][]string
+	inMulti bool
+	dirty   bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.conns.Done()
+	defer conn.Close()
+	go func() {
+		<-s.closed
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	sess := &session{}
+	for {
+		reply, err := redis.ReadReply(r)
+		if err != nil {
+			return
+		}
+		args, ok := toArgs(reply)
+		if !ok || len(args) == 0 {
+			writeReply(w, redis.Error("ERR protocol error"))
+		} else {
+			writeReply(w, s.dispatch(sess, args))
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func toArgs(reply interface{}) ([]string, bool) {
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	args := make([]string, len(values))
+	for i, v := range values {
+		if args[i], ok = v.(string); !ok {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+func (s *Server) dispatch(sess *session, args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "MULTI":
+		if sess.inMulti {
+			return redis.Error("ERR MULTI calls can not be nested")
+		}
+		sess.inMulti = true
+		sess.queue = nil
+		sess.dirty = false
+		return "OK"
+	case "DISCARD":
+		if !sess.inMulti {
+			return redis.Error("ERR DISCARD without MULTI")
+		}
+		sess.inMulti = false
+		sess.queue = nil
+		sess.watched = nil
+		return "OK"
+	case "EXEC":
+		if !sess.inMulti {
+			return redis.Error("ERR EXEC without MULTI")
+		}
+		return s.exec(sess)
+	case "WATCH":
+		if sess.inMulti {
+			return redis.Error("ERR WATCH inside MULTI is not allowed")
+		}
+		if len(args) < 2 {
+			return wrongArgs("watch")
+		}
+		s.mu.Lock()
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			s.expire(key)
+			sess.watched[key] = s.versions[key]
+		}
+		s.mu.Unlock()
+		return "OK"
+	case "UNWATCH":
+		sess.watched = nil
+		return "OK"
+	}
+
+	if sess.inMulti {
+		if _, known := commands[name]; !known {
+			sess.dirty = true
+			return redis.Error("ERR unknown command '" + args[0] + "'")
+		}
+		sess.queue = append(sess.queue, args)
+		return "QUEUED"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run(args)
+}
+
+func (s *Server) exec(sess *session) interface{} {
+	queue, watched, dirty := sess.queue, sess.watched, sess.dirty
+	sess.inMulti, sess.queue, sess.watched, sess.dirty = false, nil, nil, false
+	if dirty {
+		return redis.Error("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, version := range watched {
+		s.expire(key)
+		if s.versions[key] != version {
+			return nil
+		}
+	}
+	replies := make([]interface{}, len(queue))
+	for i, args := range queue {
+		replies[i] = s.run(args)
+	}
+	return replies
+}
+
+type command func(s *Server, args []string) interface{}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":      cmdPing,
+		"AUTH":      cmdOK,
+		"SELECT":    cmdOK,
+		"GET":       cmdGet,
+		"SET":       cmdSet,
+		"DEL":       cmdDel,
+		"EXISTS":    cmdExists,
+		"INCR":      cmdIncr,
+		"PEXPIREAT": cmdPExpireAt,
+		"PTTL":      cmdPTTL,
+		"KEYS":      cmdKeys,
+		"FLUSHALL":  cmdFlushAll,
+	}
+}
+
+// run executes a non-transactional command with s.mu held.
+func (s *Server) run(args []string) interface{} {
+	cmd, ok := commands[strings.ToUpper(args[0])]
+	if !ok {
+		return redis.Error("ERR unknown command '" + args[0] + "'")
+	}
+	return cmd(s, args[1:])
+}
+
+// expire drops key if its TTL has passed.
+func (s *Server) expire(key string) {
+	if e, ok := s.data[key]; ok && !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		delete(s.data, key)
+		s.versions[key]++
+	}
+}
+
+func (s *Server) lookup(key string) (*entry, bool) {
+	s.expire(key)
+	e, ok := s.data[key]
+	return e, ok
+}
+
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+func wrongArgs(name string) redis.Error {
+	return redis.Error("ERR wrong number of arguments for '" + name + "' command")
+}
+
+func cmdPing(s *Server, args []string) interface{} {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return "PONG"
+}
+
+func cmdOK(s *Server, args []string) interface{} {
+	return "OK"
+}
+
+func cmdGet(s *Server, args []string) interface{} {
+	if len(args) != 1 {
+		return wrongArgs("get")
+	}
+	if e, ok := s.lookup(args[0]); ok {
+		return e.value
+	}
+	return nil
+}
+
+func cmdSet(s *Server, args []string) interface{} {
+	if len(args) < 2 {
+		return wrongArgs("set")
+	}
+	key, value := args[0], args[1]
+	existing, exists := s.lookup(key)
+
+	var expireAt time.Time
+	keepTTL, nx, xx := false, false, false
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return redis.Error("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return redis.Error("ERR invalid expire time in 'set' command")
+			}
+			i++
+			switch opt {
+			case "EX":
+				expireAt = s.now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expireAt = s.now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expireAt = time.Unix(n, 0)
+			case "PXAT":
+				expireAt = time.UnixMilli(n)
+			}
+		default:
+			return redis.Error("ERR syntax error")
+		}
+	}
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	if keepTTL && exists {
+		expireAt = existing.expireAt
+	}
+	s.data[key] = &entry{value: value, expireAt: expireAt}
+	s.touch(key)
+	return "OK"
+}
+
+func cmdDel(s *Server, args []string) interface{} {
+	if len(args) == 0 {
+		return wrongArgs("del")
+	}
+	var deleted int64
+	for _, key := range args {
+		if _, ok := s.lookup(key); ok {
+			delete(s.data, key)
+			s.touch(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func cmdExists(s *Server, args []string) interface{} {
+	if len(args) == 0 {
+		return wrongArgs("exists")
+	}
+	var found int64
+	for _, key := range args {
+		if _, ok := s.lookup(key); ok {
+			found++
+		}
+	}
+	return found
+}
+
+func cmdIncr(s *Server, args []string) interface{} {
+	if len(args) != 1 {
+		return wrongArgs("incr")
+	}
+	e, ok := s.lookup(args[0])
+	if !ok {
+		e = &entry{value: "0"}
+		s.data[args[0]] = e
+	}
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return redis.Error("ERR value is not an integer or out of range")
+	}
+	n++
+	e.value = strconv.FormatInt(n, 10)
+	s.touch(args[0])
+	return n
+}
+
+func cmdPExpireAt(s *Server, args []string) interface{} {
+	if len(args) != 2 {
+		return wrongArgs("pexpireat")
+	}
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return redis.Error("ERR value is not an integer or out of range")
+	}
+	e, ok := s.lookup(args[0])
+	if !ok {
+		return int64(0)
+	}
+	e.expireAt = time.UnixMilli(ms)
+	s.touch(args[0])
+	s.expire(args[0])
+	return int64(1)
+}
+
+func cmdPTTL(s *Server, args []string) interface{} {
+	if len(args) != 1 {
+		return wrongArgs("pttl")
+	}
+	e, ok := s.lookup(args[0])
+	if !ok {
+		return int64(-2)
+	}
+	if e.expireAt.IsZero() {
+		return int64(-1)
+	}
+	return e.expireAt.Sub(s.now()).Milliseconds()
+}
+
+func cmdKeys(s *Server, args []string) interface{} {
+	if len(args) != 1 {
+		return wrongArgs("keys")
+	}
+	prefix, wildcard := strings.CutSuffix(args[0], "*")
+	if strings.ContainsAny(prefix, "*?[") {
+		return redis.Error("ERR only prefix* patterns are supported")
+	}
+	keys := []interface{}{}
+	for key := range s.data {
+		s.expire(key)
+		if _, ok := s.data[key]; !ok {
+			continue
+		}
+		if key == args[0] || (wildcard && strings.HasPrefix(key, prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func cmdFlushAll(s *Server, args []string) interface{} {
+	for key := range s.data {
+		delete(s.data, key)
+		s.touch(key)
+	}
+	return "OK"
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case redis.Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case string:
+		if v == "OK" || v == "QUEUED" || v == "PONG" {
+			w.WriteString("+" + v + "\r\n")
+			return
+		}
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		writeReply(w, redis.Error("ERR internal error"))
+	}
+}
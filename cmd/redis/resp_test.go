@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := WriteCommand(w, "SET", "key", "a\r\nb", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n$0\r\n\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCommand wrote %q, want %q", got, want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"error", "-ERR wrong\r\n", Error("ERR wrong")},
+		{"integer", ":-42\r\n", int64(-42)},
+		{"bulk string", "$5\r\na\r\nbc\r\n", "a\r\nbc"},
+		{"empty bulk string", "$0\r\n\r\n", ""},
+		{"null bulk string", "$-1\r\n", nil},
+		{"null array", "*-1\r\n", nil},
+		{"nested array", "*3\r\n:1\r\n$-1\r\n*1\r\n+QUEUED\r\n", []interface{}{int64(1), nil, []interface{}{"QUEUED"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadReply() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadReplyMalformed(t *testing.T) {
+	for _, input := range []string{"", "\r\n", "+OK\n", "$x\r\n", "$5\r\nab\r\n", "*2\r\n:1\r\n", "?what\r\n"} {
+		if got, err := ReadReply(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("ReadReply(%q) = %#v, want an error", input, got)
+		}
+	}
+}
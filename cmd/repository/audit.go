@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditEventRecord is a row of the otp_audit_events table.
+type AuditEventRecord struct {
+	Sequence  int64     `gorm:"primaryKey;autoIncrement:false"`
+	Time      time.Time `gorm:"not null"`
+	Type      string    `gorm:"type:varchar(32);not null"`
+	OTPID     uuid.UUID `gorm:"type:uuid;index"`
+	Purpose   string    `gorm:"type:varchar(50)"`
+	Delivery  string    `gorm:"type:varchar(20)"`
+	Recipient string    `gorm:"type:varchar(255)"`
+	Client    string    `gorm:"type:varchar(255)"`
+	Metadata  string    `gorm:"type:text"`
+	PrevHash  string    `gorm:"type:varchar(64)"`
+	Hash      string    `gorm:"type:varchar(64);not null"`
+}
+
+func (AuditEventRecord) TableName() string {
+	return "otp_audit_events"
+}
+
+// AuditRepository is an otp.AuditSink storing events in the
+// otp_audit_events table. Sequences are the primary key, so two writers
+// extending the same chain cannot both succeed.
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+var _ otp.AuditSink = (*AuditRepository)(nil)
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) Append(ctx context.Context, event *otp.AuditEvent) error {
+	record := AuditEventRecord{
+		Sequence:  event.Sequence,
+		Time:      event.Time,
+		Type:      string(event.Type),
+		OTPID:     event.OTPID,
+		Purpose:   event.Purpose,
+		Delivery:  event.Delivery,
+		Recipient: event.Recipient,
+		Client:    event.Client,
+		PrevHash:  event.PrevHash,
+		Hash:      event.Hash,
+	}
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return err
+		}
+		record.Metadata = string(metadata)
+	}
+	return r.db.WithContext(ctx).Create(&record).Error
+}
+
+func (r *AuditRepository) Last(ctx context.Context) (*otp.AuditEvent, error) {
+	var record AuditEventRecord
+	err := r.db.WithContext(ctx).Order("sequence DESC").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.event()
+}
+
+func (r *AuditRepository) Read(ctx context.Context, after int64, limit int) ([]*otp.AuditEvent, error) {
+	var records []AuditEventRecord
+	err := r.db.WithContext(ctx).
+		Where("sequence > ?", after).
+		Order("sequence").
+		Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*otp.AuditEvent, len(records))
+	for i := range records {
+		if events[i], err = records[i].event(); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (r *AuditEventRecord) event() (*otp.AuditEvent, error) {
+	event := &otp.AuditEvent{
+		Sequence:  r.Sequence,
+		Time:      r.Time,
+		Type:      otp.AuditEventType(r.Type),
+		OTPID:     r.OTPID,
+		Purpose:   r.Purpose,
+		Delivery:  r.Delivery,
+		Recipient: r.Recipient,
+		Client:    r.Client,
+		PrevHash:  r.PrevHash,
+		Hash:      r.Hash,
+	}
+	if r.Metadata != "" {
+		if err := json.Unmarshal([]byte(r.Metadata), &event.Metadata); err != nil {
+			return nil, fmt.Errorf("audit event %d has malformed metadata: %w", r.Sequence, err)
+		}
+	}
+	return event, nil
+}
+
+// JSONLAuditSink is an otp.AuditSink appending events to a file, one JSON
+// object per line.
+type JSONLAuditSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	// Where the last Read stopped, so sequential reads do not rescan.
+	readSeq    int64
+	readOffset int64
+}
+
+var _ otp.AuditSink = (*JSONLAuditSink)(nil)
+
+// NewJSONLAuditSink opens the file at path for appending, creating it if
+// needed.
+func NewJSONLAuditSink(path string) (*JSONLAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLAuditSink{path: path, file: file}, nil
+}
+
+func (s *JSONLAuditSink) Append(ctx context.Context, event *otp.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *JSONLAuditSink) Last(ctx context.Context) (*otp.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last *otp.AuditEvent
+	err := s.scan(ctx, 0, func(event *otp.AuditEvent, _ int64) bool {
+		last = event
+		return true
+	})
+	return last, err
+}
+
+func (s *JSONLAuditSink) Read(ctx context.Context, after int64, limit int) ([]*otp.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset := int64(0)
+	if after > 0 && after == s.readSeq {
+		offset = s.readOffset
+	}
+	var events []*otp.AuditEvent
+	err := s.scan(ctx, offset, func(event *otp.AuditEvent, end int64) bool {
+		if event.Sequence <= after {
+			return true
+		}
+		events = append(events, event)
+		s.readSeq, s.readOffset = event.Sequence, end
+		return len(events) < limit
+	})
+	return events, err
+}
+
+// scan decodes the events from offset on, passing each with the offset
+// where its line ends to fn until fn returns false.
+func (s *JSONLAuditSink) scan(ctx context.Context, offset int64, fn func(event *otp.AuditEvent, end int64) bool) error {
+	reader := bufio.NewReader(io.NewSectionReader(s.file, offset, 1<<62))
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				return fmt.Errorf("%s: truncated audit event after offset %d", s.path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		var event otp.AuditEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("%s: malformed audit event: %w", s.path, err)
+		}
+		if !fn(&event, offset) {
+			return nil
+		}
+	}
+}
+
+func (s *JSONLAuditSink) Close() error {
+	return s.file.Close()
+}
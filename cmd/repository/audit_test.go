@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+)
+
+func TestJSONLAuditSink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewJSONLAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+
+	if last, err := sink.Last(ctx); last != nil || err != nil {
+		t.Fatalf("Last of an empty sink = %+v, %v", last, err)
+	}
+
+	log := otp.NewAuditLog(sink)
+	var recorded []*otp.AuditEvent
+	for i, eventType := range []otp.AuditEventType{otp.AuditIssued, otp.AuditDelivered, otp.AuditAttemptFailed, otp.AuditVerified, otp.AuditExpired} {
+		event, err := log.Record(ctx, otp.AuditEvent{
+			Time:      time.Date(2024, 6, 1, 12, 0, i, 123456789, time.FixedZone("UTC+2", 2*60*60)),
+			Type:      eventType,
+			OTPID:     uuid.New(),
+			Purpose:   "login",
+			Delivery:  string(otp.DeliverySMS),
+			Recipient: "+1*********67",
+			Client:    "web",
+			Metadata:  map[string]string{"request_id": uuid.NewString()},
+		})
+		if err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		recorded = append(recorded, event)
+	}
+
+	// Read pages through the events in order, from a fresh sink too.
+	reopened, err := NewJSONLAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	for _, s := range []*JSONLAuditSink{sink, reopened} {
+		var read []*otp.AuditEvent
+		for {
+			page, err := s.Read(ctx, int64(len(read)), 2)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			read = append(read, page...)
+		}
+		if !reflect.DeepEqual(read, recorded) {
+			t.Errorf("Read = %+v, want %+v", read, recorded)
+		}
+		if last, err := s.Last(ctx); err != nil || !reflect.DeepEqual(last, recorded[len(recorded)-1]) {
+			t.Errorf("Last = %+v, %v", last, err)
+		}
+		if result, err := otp.VerifyAuditChain(ctx, s, 2); err != nil || result.Events != int64(len(recorded)) {
+			t.Errorf("VerifyAuditChain = %+v, %v", result, err)
+		}
+	}
+
+	// A second log continues the chain.
+	event, err := otp.NewAuditLog(reopened).Record(ctx, otp.AuditEvent{Type: otp.AuditRevoked, OTPID: uuid.New()})
+	if err != nil || event.Sequence != 6 || event.PrevHash != recorded[4].Hash {
+		t.Errorf("Record on the reopened sink = %+v, %v", event, err)
+	}
+}
@@ -0,0 +1,519 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+)
+
+// MemoryOTPRepository is an in-process otp.OTPStore. Records are evicted
+// once they are retention past their ExpiresAt, either lazily on access or by
+// the janitor started with StartEviction.
+type MemoryOTPRepository struct {
+	mu        sync.RWMutex
+	otps      map[uuid.UUID]*otp.OTP
+	outbox    map[uuid.UUID]*otp.OutboxMessage
+	retention time.Duration
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+var _ otp.OTPStore = (*MemoryOTPRepository)(nil)
+
+func NewMemoryOTPRepository(retention time.Duration) *MemoryOTPRepository {
+	return &MemoryOTPRepository{
+		otps:      make(map[uuid.UUID]*otp.OTP),
+		outbox:    make(map[uuid.UUID]*otp.OutboxMessage),
+		retention: retention,
+		stop:      make(chan struct{}),
+	}
+}
+
+// StartEviction removes evictable records every interval until Close is called.
+func (r *MemoryOTPRepository) StartEviction(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Evict(time.Now())
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Evict removes every record that is evictable at now and returns how many
+// were removed.
+func (r *MemoryOTPRepository) Evict(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evicted := 0
+	for id, record := range r.otps {
+		if r.evictable(record, now) {
+			delete(r.otps, id)
+			evicted++
+		}
+	}
+	r.dropOrphanMessages()
+	return evicted
+}
+
+func (r *MemoryOTPRepository) Close() error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	return nil
+}
+
+func (r *MemoryOTPRepository) SaveOTP(ctx context.Context, record *otp.OTP) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if record.ID == uuid.Nil {
+		record.ID = uuid.New()
+	}
+	now := time.Now()
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	if record.Status == "" {
+		record.Status = otp.OTPStatusPending
+	}
+
+	stored := *record
+	r.mu.Lock()
+	r.otps[record.ID] = &stored
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *MemoryOTPRepository) GetOTPByID(ctx context.Context, id uuid.UUID) (*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return nil, otp.ErrNotFound
+	}
+	found := *record
+	return &found, nil
+}
+
+func (r *MemoryOTPRepository) GetValidOTPByPurpose(ctx context.Context, recipient, purpose string) (*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var latest *otp.OTP
+	for _, record := range r.otps {
+		if record.Status != otp.OTPStatusPending || record.Purpose != purpose || r.evictable(record, now) {
+			continue
+		}
+		if record.MobileNumber != recipient && record.Email != recipient {
+			continue
+		}
+		if latest == nil || record.CreatedAt.After(latest.CreatedAt) {
+			latest = record
+		}
+	}
+	if latest == nil {
+		return nil, otp.ErrNotFound
+	}
+	found := *latest
+	return &found, nil
+}
+
+func (r *MemoryOTPRepository) UpdateOTPStatus(ctx context.Context, id uuid.UUID, status string) error {
+	return r.update(ctx, id, func(record *otp.OTP) {
+		record.Status = status
+	})
+}
+
+func (r *MemoryOTPRepository) UpdateHashedOTP(ctx context.Context, id uuid.UUID, hashedOTP string) error {
+	return r.update(ctx, id, func(record *otp.OTP) {
+		record.HashedOTP = hashedOTP
+	})
+}
+
+func (r *MemoryOTPRepository) IncrementRetryCount(ctx context.Context, id uuid.UUID) error {
+	return r.update(ctx, id, func(record *otp.OTP) {
+		record.RetryCount++
+	})
+}
+
+func (r *MemoryOTPRepository) ConsumeOTP(ctx context.Context, id uuid.UUID, status string, now time.Time) error {
+	_, err := r.updateIf(ctx, id, now, func(record *otp.OTP) {
+		record.Status = status
+	})
+	return err
+}
+
+func (r *MemoryOTPRepository) ExpireOTP(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return otp.ErrNotFound
+	}
+	if record.Status != otp.OTPStatusPending {
+		return otp.ErrNoLongerValid
+	}
+	record.Status = otp.OTPStatusExpired
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryOTPRepository) RecordFailedAttempt(ctx context.Context, id uuid.UUID, now time.Time) (*otp.OTP, error) {
+	return r.updateIf(ctx, id, now, func(record *otp.OTP) {
+		record.RetryCount++
+		if record.RetryCount >= record.RetryLimit {
+			record.Status = otp.OTPStatusExpired
+		}
+	})
+}
+
+func (r *MemoryOTPRepository) RecordResend(ctx context.Context, id uuid.UUID, resendCount int, hashedOTP, delivery string, now time.Time) (*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return nil, otp.ErrNotFound
+	}
+	if !resendable(record, resendCount, now) {
+		return nil, otp.ErrNoLongerValid
+	}
+	applyResend(record, hashedOTP, delivery, now)
+	updated := *record
+	return &updated, nil
+}
+
+func (r *MemoryOTPRepository) RevertResend(ctx context.Context, id uuid.UUID, resendCount int, previous *otp.OTP) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return otp.ErrNotFound
+	}
+	if record.ResendCount != resendCount {
+		return otp.ErrNoLongerValid
+	}
+	revertResend(record, previous)
+	return nil
+}
+
+// resendable reports whether record may be re-sent by a caller that read it
+// with resendCount resends.
+func resendable(record *otp.OTP, resendCount int, now time.Time) bool {
+	return record.Status == otp.OTPStatusPending && now.Before(record.ExpiresAt) &&
+		record.ResendCount == resendCount && record.ResendCount < record.MaxResends
+}
+
+func applyResend(record *otp.OTP, hashedOTP, delivery string, now time.Time) {
+	record.HashedOTP = hashedOTP
+	record.Delivery = delivery
+	record.ResendCount++
+	record.LastSentAt = now
+	record.UpdatedAt = time.Now()
+}
+
+func revertResend(record, previous *otp.OTP) {
+	record.HashedOTP = previous.HashedOTP
+	record.Delivery = previous.Delivery
+	record.ResendCount = previous.ResendCount
+	record.LastSentAt = previous.LastSentAt
+	record.UpdatedAt = time.Now()
+}
+
+func (r *MemoryOTPRepository) update(ctx context.Context, id uuid.UUID, apply func(record *otp.OTP)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return otp.ErrNotFound
+	}
+	apply(record)
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+// updateIf applies apply only while the record is consumable at now and
+// returns a copy of the updated record.
+func (r *MemoryOTPRepository) updateIf(ctx context.Context, id uuid.UUID, now time.Time, apply func(record *otp.OTP)) (*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok || r.evictable(record, time.Now()) {
+		return nil, otp.ErrNotFound
+	}
+	if !record.Consumable(now) {
+		return nil, otp.ErrNoLongerValid
+	}
+	apply(record)
+	record.UpdatedAt = time.Now()
+	updated := *record
+	return &updated, nil
+}
+
+func (r *MemoryOTPRepository) evictable(record *otp.OTP, now time.Time) bool {
+	return now.After(record.ExpiresAt.Add(r.retention))
+}
+
+var _ otp.PayloadRotationStore = (*MemoryOTPRepository)(nil)
+
+func (r *MemoryOTPRepository) ListPayloadsToRotate(ctx context.Context, keyID string, after uuid.UUID, limit int) ([]*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	var records []*otp.OTP
+	for id, record := range r.otps {
+		if record.TransactionPayload != "" && record.PayloadKeyID != keyID && id.String() > after.String() {
+			copied := *record
+			records = append(records, &copied)
+		}
+	}
+	r.mu.RUnlock()
+	return firstByID(records, limit), nil
+}
+
+func (r *MemoryOTPRepository) UpdatePayload(ctx context.Context, id uuid.UUID, oldKeyID, keyID, payload string) (bool, error) {
+	updated := false
+	err := r.update(ctx, id, func(record *otp.OTP) {
+		if record.PayloadKeyID == oldKeyID {
+			record.TransactionPayload = payload
+			record.PayloadKeyID = keyID
+			updated = true
+		}
+	})
+	return updated, err
+}
+
+// firstByID sorts records by ID and returns the first limit of them.
+func firstByID(records []*otp.OTP, limit int) []*otp.OTP {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID.String() < records[j].ID.String()
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records
+}
+
+var _ otp.MaintenanceStore = (*MemoryOTPRepository)(nil)
+
+func (r *MemoryOTPRepository) ExpireOverdue(ctx context.Context, now time.Time, limit int) ([]*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired []*otp.OTP
+	for _, record := range r.otps {
+		if len(expired) == limit {
+			break
+		}
+		if record.Status == otp.OTPStatusPending && !now.Before(record.ExpiresAt) {
+			record.Status = otp.OTPStatusExpired
+			record.UpdatedAt = time.Now()
+			updated := *record
+			expired = append(expired, &updated)
+		}
+	}
+	return expired, nil
+}
+
+func (r *MemoryOTPRepository) ListPurgeable(ctx context.Context, status string, cutoff time.Time, limit int) ([]*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []*otp.OTP
+	for _, record := range r.otps {
+		if len(records) == limit {
+			break
+		}
+		if record.Status == status && record.CreatedAt.Before(cutoff) {
+			copied := *record
+			records = append(records, &copied)
+		}
+	}
+	return records, nil
+}
+
+func (r *MemoryOTPRepository) DeleteOTPs(ctx context.Context, ids []uuid.UUID) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for _, id := range ids {
+		if _, ok := r.otps[id]; ok {
+			delete(r.otps, id)
+			deleted++
+		}
+	}
+	r.dropOrphanMessages()
+	return deleted, nil
+}
+
+var _ otp.RecipientDataStore = (*MemoryOTPRepository)(nil)
+
+// ListByRecipient also returns records that are hidden pending eviction, as
+// they still hold the recipient.
+func (r *MemoryOTPRepository) ListByRecipient(ctx context.Context, recipient string) ([]*otp.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []*otp.OTP
+	for _, record := range r.otps {
+		if record.MobileNumber == recipient || record.Email == recipient {
+			copied := *record
+			records = append(records, &copied)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+func (r *MemoryOTPRepository) RedactOTP(ctx context.Context, id uuid.UUID, mobileNumber, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.otps[id]
+	if !ok {
+		return otp.ErrNotFound
+	}
+	record.MobileNumber, record.Email = mobileNumber, email
+	record.TransactionPayload, record.PayloadDigest, record.PayloadKeyID = "", "", ""
+	record.SMSBody, record.EmailSubject, record.EmailBody = "", "", ""
+	if record.Status == otp.OTPStatusPending {
+		record.Status = otp.OTPStatusExpired
+	}
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+var _ otp.OutboxStore = (*MemoryOTPRepository)(nil)
+
+func (r *MemoryOTPRepository) SaveOTPWithMessage(ctx context.Context, record *otp.OTP, message *otp.OutboxMessage) error {
+	if err := r.SaveOTP(ctx, record); err != nil {
+		return err
+	}
+	now := time.Now()
+	message.OTPID = record.ID
+	message.CreatedAt, message.UpdatedAt = now, now
+	stored := *message
+	r.mu.Lock()
+	r.outbox[message.ID] = &stored
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *MemoryOTPRepository) ClaimMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*otp.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []*otp.OutboxMessage
+	for _, message := range r.outbox {
+		if message.Status == otp.OutboxPending && !message.NextAttemptAt.After(now) {
+			due = append(due, message)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*otp.OutboxMessage, len(due))
+	for i, message := range due {
+		message.Attempts++
+		message.NextAttemptAt = now.Add(lease)
+		message.UpdatedAt = time.Now()
+		copied := *message
+		claimed[i] = &copied
+	}
+	return claimed, nil
+}
+
+func (r *MemoryOTPRepository) UpdateMessage(ctx context.Context, message *otp.OutboxMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.outbox[message.ID]
+	if !ok {
+		return otp.ErrNotFound
+	}
+	now := time.Now()
+	stored.Status = message.Status
+	stored.Attempts = message.Attempts
+	stored.NextAttemptAt = message.NextAttemptAt
+	stored.LastError = message.LastError
+	stored.SealedCode = message.SealedCode
+	stored.UpdatedAt = now
+	if record, ok := r.otps[message.OTPID]; ok {
+		record.DeliveryStatus = message.Status
+		record.UpdatedAt = now
+	}
+	return nil
+}
+
+// dropOrphanMessages removes outbox messages whose OTP was deleted. The
+// caller must hold the write lock.
+func (r *MemoryOTPRepository) dropOrphanMessages() {
+	for id, message := range r.outbox {
+		if _, ok := r.otps[message.OTPID]; !ok {
+			delete(r.outbox, id)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/otp/otptest"
+)
+
+func newMemoryRepository(t *testing.T) otp.OTPStore {
+	store := NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestMemoryOTPRepository(t *testing.T) {
+	otptest.TestStore(t, newMemoryRepository)
+}
+
+func TestMemoryOTPRepositoryConcurrency(t *testing.T) {
+	otptest.TestConcurrency(t, newMemoryRepository)
+}
@@ -1,79 +1,366 @@
 package repository
 
 import (
-	_ "errors"
+	"context"
+	"errors"
 	"github.com/Zaman-R/otp-validator/cmd/otp"
-	"github.com/pkg/errors"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// OTPRepository is the GORM-backed otp.OTPStore.
 type OTPRepository struct {
 	db *gorm.DB
 }
 
+var _ otp.OTPStore = (*OTPRepository)(nil)
+
 func NewOTPRepository(db *gorm.DB) *OTPRepository {
 	return &OTPRepository{db: db}
 }
 
-func (r *OTPRepository) SaveOTP(otp *otp.OTP) error {
-	otp.ID = uuid.New()
+func (r *OTPRepository) SaveOTP(ctx context.Context, otp *otp.OTP) error {
+	if otp.ID == uuid.Nil {
+		otp.ID = uuid.New()
+	}
 	otp.CreatedAt = time.Now()
 	otp.UpdatedAt = time.Now()
-	return r.db.Create(otp).Error
+	return r.db.WithContext(ctx).Create(otp).Error
 }
 
-func (r *OTPRepository) GetValidOTPByPurpose(mobileOrEmail, purpose string) (*otp.OTP, error) {
+func (r *OTPRepository) GetValidOTPByPurpose(ctx context.Context, mobileOrEmail, purpose string) (*otp.OTP, error) {
 	var otpInstance otp.OTP
-	err := r.db.Where("(mobile_number = ? OR email = ?) AND purpose = ? AND status = ?",
+	err := r.db.WithContext(ctx).Where("(mobile_number = ? OR email = ?) AND purpose = ? AND status = ?",
 		mobileOrEmail, mobileOrEmail, purpose, otp.OTPStatusPending).
+		Order("created_at DESC").
 		First(&otpInstance).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, otp.ErrNotFound
+		}
 		return nil, err
 	}
 	return &otpInstance, nil
 }
 
-func (r *OTPRepository) IncrementRetryCount(id uuid.UUID) error {
-	return r.db.Model(&otp.OTP{}).Where("id = ?", id).
+func (r *OTPRepository) IncrementRetryCount(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&otp.OTP{}).Where("id = ?", id).
 		UpdateColumn("retry_count", gorm.Expr("retry_count + 1")).Error
 }
 
-func (r *OTPRepository) ExpireOTP(id uuid.UUID) error {
-	return r.db.Model(&otp.OTP{}).Where("id = ?", id).
+func (r *OTPRepository) ExpireOTP(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Model(&otp.OTP{}).
+		Where("id = ? AND status = ?", id, otp.OTPStatusPending).
 		Updates(map[string]interface{}{
 			"status":     otp.OTPStatusExpired,
 			"updated_at": time.Now(),
-		}).Error
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.notConsumable(ctx, id)
+	}
+	return nil
 }
 
-func (r *OTPRepository) UpdateRetryLimit(id uuid.UUID) error {
-	return r.db.Model(&otp.OTP{}).Where("id = ?", id).
+func (r *OTPRepository) UpdateRetryLimit(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&otp.OTP{}).Where("id = ?", id).
 		UpdateColumn("retry_count", gorm.Expr("retry_count + ?", 1)).Error
 }
 
-func (r *OTPRepository) MarkOTPAsUsed(id uuid.UUID) error {
-	return r.db.Model(&otp.OTP{}).Where("id = ?", id).
+func (r *OTPRepository) MarkOTPAsUsed(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&otp.OTP{}).Where("id = ?", id).
 		Update("status", otp.OTPStatusUsed).Error
 }
 
-func (r *OTPRepository) GetOTPByID(otpID uuid.UUID) (*otp.OTP, error) {
+func (r *OTPRepository) GetOTPByID(ctx context.Context, otpID uuid.UUID) (*otp.OTP, error) {
 	var otpInstance otp.OTP
-	if err := r.db.Where("id = ?", otpID).First(&otpInstance).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("id = ?", otpID).First(&otpInstance).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("OTP not found")
+			return nil, otp.ErrNotFound
 		}
 		return nil, err
 	}
 	return &otpInstance, nil
 }
 
-func (r *OTPRepository) UpdateOTPStatus(otpID uuid.UUID, status string) error {
-	return r.db.Model(&otp.OTP{}).Where("id = ?", otpID).
+func (r *OTPRepository) UpdateOTPStatus(ctx context.Context, otpID uuid.UUID, status string) error {
+	return r.db.WithContext(ctx).Model(&otp.OTP{}).Where("id = ?", otpID).
 		Updates(map[string]interface{}{
 			"status":     status,
 			"updated_at": time.Now(),
 		}).Error
 }
+
+func (r *OTPRepository) UpdateHashedOTP(ctx context.Context, otpID uuid.UUID, hashedOTP string) error {
+	return r.db.WithContext(ctx).Model(&otp.OTP{}).Where("id = ?", otpID).
+		Updates(map[string]interface{}{
+			"hashed_otp": hashedOTP,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+func (r *OTPRepository) ConsumeOTP(ctx context.Context, otpID uuid.UUID, status string, now time.Time) error {
+	result := r.db.WithContext(ctx).Model(&otp.OTP{}).
+		Where("id = ? AND status = ? AND retry_count < retry_limit AND expires_at > ?", otpID, otp.OTPStatusPending, now).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.notConsumable(ctx, otpID)
+	}
+	return nil
+}
+
+func (r *OTPRepository) RecordFailedAttempt(ctx context.Context, otpID uuid.UUID, now time.Time) (*otp.OTP, error) {
+	// status is assigned before retry_count so both PostgreSQL (which reads
+	// old values) and MySQL (which reads assignments left to right) compare
+	// against the pre-increment count.
+	result := r.db.WithContext(ctx).Exec(
+		"UPDATE otps SET status = CASE WHEN retry_count + 1 >= retry_limit THEN ? ELSE status END, "+
+			"retry_count = retry_count + 1, updated_at = ? "+
+			"WHERE id = ? AND status = ? AND retry_count < retry_limit AND expires_at > ?",
+		otp.OTPStatusExpired, time.Now(), otpID, otp.OTPStatusPending, now,
+	)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, r.notConsumable(ctx, otpID)
+	}
+	return r.GetOTPByID(ctx, otpID)
+}
+
+func (r *OTPRepository) RecordResend(ctx context.Context, otpID uuid.UUID, resendCount int, hashedOTP, delivery string, now time.Time) (*otp.OTP, error) {
+	result := r.db.WithContext(ctx).Model(&otp.OTP{}).
+		Where("id = ? AND status = ? AND expires_at > ? AND resend_count = ? AND resend_count < max_resends",
+			otpID, otp.OTPStatusPending, now, resendCount).
+		Updates(map[string]interface{}{
+			"hashed_otp":   hashedOTP,
+			"delivery":     delivery,
+			"resend_count": gorm.Expr("resend_count + 1"),
+			"last_sent_at": now,
+			"updated_at":   time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, r.notConsumable(ctx, otpID)
+	}
+	return r.GetOTPByID(ctx, otpID)
+}
+
+func (r *OTPRepository) RevertResend(ctx context.Context, otpID uuid.UUID, resendCount int, previous *otp.OTP) error {
+	result := r.db.WithContext(ctx).Model(&otp.OTP{}).
+		Where("id = ? AND resend_count = ?", otpID, resendCount).
+		Updates(map[string]interface{}{
+			"hashed_otp":   previous.HashedOTP,
+			"delivery":     previous.Delivery,
+			"resend_count": previous.ResendCount,
+			"last_sent_at": previous.LastSentAt,
+			"updated_at":   time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.notConsumable(ctx, otpID)
+	}
+	return nil
+}
+
+// notConsumable tells a missing OTP apart from one whose conditional update
+// matched no row.
+func (r *OTPRepository) notConsumable(ctx context.Context, otpID uuid.UUID) error {
+	if _, err := r.GetOTPByID(ctx, otpID); err != nil {
+		return err
+	}
+	return otp.ErrNoLongerValid
+}
+
+var _ otp.PayloadRotationStore = (*OTPRepository)(nil)
+
+func (r *OTPRepository) ListPayloadsToRotate(ctx context.Context, keyID string, after uuid.UUID, limit int) ([]*otp.OTP, error) {
+	var records []*otp.OTP
+	err := r.db.WithContext(ctx).
+		Where("transaction_payload <> '' AND COALESCE(payload_key_id, '') <> ? AND id > ?", keyID, after).
+		Order("id").
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
+
+func (r *OTPRepository) UpdatePayload(ctx context.Context, otpID uuid.UUID, oldKeyID, keyID, payload string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&otp.OTP{}).
+		Where("id = ? AND COALESCE(payload_key_id, '') = ?", otpID, oldKeyID).
+		Updates(map[string]interface{}{
+			"transaction_payload": payload,
+			"payload_key_id":      keyID,
+			"updated_at":          time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+var _ otp.MaintenanceStore = (*OTPRepository)(nil)
+
+// ExpireOverdue locks a batch of rows first, since MySQL does not allow
+// LIMIT in an IN subquery, so the rows it returns are exactly those updated.
+func (r *OTPRepository) ExpireOverdue(ctx context.Context, now time.Time, limit int) ([]*otp.OTP, error) {
+	var records []*otp.OTP
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND expires_at <= ?", otp.OTPStatusPending, now).
+			Limit(limit).
+			Find(&records).Error
+		if err != nil || len(records) == 0 {
+			return err
+		}
+		ids := make([]uuid.UUID, len(records))
+		for i, record := range records {
+			ids[i] = record.ID
+		}
+		updatedAt := time.Now()
+		err = tx.Model(&otp.OTP{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"status": otp.OTPStatusExpired, "updated_at": updatedAt}).Error
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			record.Status, record.UpdatedAt = otp.OTPStatusExpired, updatedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *OTPRepository) ListPurgeable(ctx context.Context, status string, cutoff time.Time, limit int) ([]*otp.OTP, error) {
+	var records []*otp.OTP
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND created_at < ?", status, cutoff).
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
+
+func (r *OTPRepository) DeleteOTPs(ctx context.Context, ids []uuid.UUID) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	deleted := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("otp_id IN ?", ids).Delete(&otp.OutboxMessage{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id IN ?", ids).Delete(&otp.OTP{})
+		deleted = int(result.RowsAffected)
+		return result.Error
+	})
+	return deleted, err
+}
+
+var _ otp.RecipientDataStore = (*OTPRepository)(nil)
+
+func (r *OTPRepository) ListByRecipient(ctx context.Context, recipient string) ([]*otp.OTP, error) {
+	var records []*otp.OTP
+	err := r.db.WithContext(ctx).
+		Where("mobile_number = ? OR email = ?", recipient, recipient).
+		Order("created_at").
+		Find(&records).Error
+	return records, err
+}
+
+func (r *OTPRepository) RedactOTP(ctx context.Context, id uuid.UUID, mobileNumber, email string) error {
+	return redact(r.db.WithContext(ctx).Model(&otp.OTP{}), id, mobileNumber, email)
+}
+
+// redact applies RedactOTP to the row with id in the table of db.
+func redact(db *gorm.DB, id uuid.UUID, mobileNumber, email string) error {
+	result := db.Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"mobile_number":       mobileNumber,
+			"email":               email,
+			"transaction_payload": "",
+			"payload_digest":      "",
+			"payload_key_id":      "",
+			"sms_body":            "",
+			"email_subject":       "",
+			"email_body":          "",
+			"status":              gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END", otp.OTPStatusPending, otp.OTPStatusExpired),
+			"updated_at":          time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return otp.ErrNotFound
+	}
+	return nil
+}
+
+// OTPArchiveRecord is a row of the otp_archives table: a copy of an OTP
+// taken before it was purged.
+type OTPArchiveRecord struct {
+	otp.OTP
+	ArchivedAt time.Time `gorm:"not null"`
+}
+
+func (OTPArchiveRecord) TableName() string {
+	return "otp_archives"
+}
+
+// OTPArchiveRepository is an otp.Archiver that copies records into the
+// otp_archives table.
+type OTPArchiveRepository struct {
+	db *gorm.DB
+}
+
+var _ otp.Archiver = (*OTPArchiveRepository)(nil)
+
+func NewOTPArchiveRepository(db *gorm.DB) *OTPArchiveRepository {
+	return &OTPArchiveRepository{db: db}
+}
+
+// Archive inserts copies of records, skipping ones already archived so a
+// batch can be retried after its deletion failed.
+func (r *OTPArchiveRepository) Archive(ctx context.Context, records []*otp.OTP) error {
+	if len(records) == 0 {
+		return nil
+	}
+	now := time.Now()
+	rows := make([]OTPArchiveRecord, len(records))
+	for i, record := range records {
+		rows[i] = OTPArchiveRecord{OTP: *record, ArchivedAt: now}
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+var _ otp.RecipientDataStore = (*OTPArchiveRepository)(nil)
+
+func (r *OTPArchiveRepository) ListByRecipient(ctx context.Context, recipient string) ([]*otp.OTP, error) {
+	var rows []OTPArchiveRecord
+	err := r.db.WithContext(ctx).
+		Where("mobile_number = ? OR email = ?", recipient, recipient).
+		Order("created_at").
+		Find(&rows).Error
+	records := make([]*otp.OTP, len(rows))
+	for i := range rows {
+		records[i] = &rows[i].OTP
+	}
+	return records, err
+}
+
+func (r *OTPArchiveRepository) RedactOTP(ctx context.Context, id uuid.UUID, mobileNumber, email string) error {
+	return redact(r.db.WithContext(ctx).Model(&OTPArchiveRecord{}), id, mobileNumber, email)
+}
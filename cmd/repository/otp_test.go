@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/otp/otptest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newOTPRepository connects to the PostgreSQL database named by
+// OTP_TEST_POSTGRES_DSN, skipping the test when it is unset.
+func newOTPRepository(t *testing.T) *OTPRepository {
+	t.Helper()
+	dsn := os.Getenv("OTP_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("OTP_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&otp.OTP{}, &otp.OutboxMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewOTPRepository(db)
+}
+
+func newOTPStore(t *testing.T) otp.OTPStore {
+	return newOTPRepository(t)
+}
+
+func TestOTPRepository(t *testing.T) {
+	otptest.TestStore(t, newOTPStore)
+}
+
+func TestOTPRepositoryConcurrency(t *testing.T) {
+	otptest.TestConcurrency(t, newOTPStore)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var _ otp.OutboxStore = (*OTPRepository)(nil)
+
+func (r *OTPRepository) SaveOTPWithMessage(ctx context.Context, record *otp.OTP, message *otp.OutboxMessage) error {
+	if record.ID == uuid.Nil {
+		record.ID = uuid.New()
+	}
+	now := time.Now()
+	record.CreatedAt, record.UpdatedAt = now, now
+	message.OTPID = record.ID
+	message.CreatedAt, message.UpdatedAt = now, now
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.Create(message).Error
+	})
+}
+
+// ClaimMessages locks due rows with SKIP LOCKED, so concurrent workers
+// claim disjoint batches without waiting on each other.
+func (r *OTPRepository) ClaimMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*otp.OutboxMessage, error) {
+	var messages []*otp.OutboxMessage
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", otp.OutboxPending, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&messages).Error
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+		ids := make([]uuid.UUID, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+			message.Attempts++
+			message.NextAttemptAt = now.Add(lease)
+		}
+		return tx.Model(&otp.OutboxMessage{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"next_attempt_at": now.Add(lease),
+				"updated_at":      time.Now(),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *OTPRepository) UpdateMessage(ctx context.Context, message *otp.OutboxMessage) error {
+	now := time.Now()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&otp.OutboxMessage{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{
+				"status":          message.Status,
+				"attempts":        message.Attempts,
+				"next_attempt_at": message.NextAttemptAt,
+				"last_error":      message.LastError,
+				"sealed_code":     message.SealedCode,
+				"updated_at":      now,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&otp.OTP{}).Where("id = ?", message.OTPID).
+			Updates(map[string]interface{}{
+				"delivery_status": message.Status,
+				"updated_at":      now,
+			}).Error
+	})
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryRateLimitStore is an in-process otp.RateLimitStore. It only limits
+// requests reaching this process; use RateLimitRepository when several
+// instances share the limits.
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	buckets  map[string]memoryBucket
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type memoryBucket struct {
+	otp.Bucket
+	fullAt time.Time
+}
+
+var _ otp.RateLimitStore = (*MemoryRateLimitStore)(nil)
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]memoryBucket),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit otp.Limit, now time.Time) (otp.RateLimitResult, error) {
+	if err := ctx.Err(); err != nil {
+		return otp.RateLimitResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, result := limit.Take(s.buckets[key].Bucket, now)
+	s.buckets[key] = memoryBucket{Bucket: bucket, fullAt: limit.FullAt(bucket)}
+	return result, nil
+}
+
+func (s *MemoryRateLimitStore) Refund(ctx context.Context, key string, limit otp.Limit, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.buckets[key]; ok {
+		bucket := limit.Refund(current.Bucket, now)
+		s.buckets[key] = memoryBucket{Bucket: bucket, fullAt: limit.FullAt(bucket)}
+	}
+	return nil
+}
+
+// StartEviction removes refilled buckets every interval until Close is called.
+func (s *MemoryRateLimitStore) StartEviction(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Evict(time.Now())
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Evict removes every bucket that is full at now, since a missing bucket is
+// treated as full, and returns how many were removed.
+func (s *MemoryRateLimitStore) Evict(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	evicted := 0
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (s *MemoryRateLimitStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+// RateLimitBucket is the row a RateLimitRepository keeps per bucket key.
+type RateLimitBucket struct {
+	BucketKey string    `gorm:"type:varchar(255);primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;autoUpdateTime:false"`
+	FullAt    time.Time `gorm:"not null;index"`
+}
+
+func (RateLimitBucket) TableName() string {
+	return "otp_rate_limits"
+}
+
+// RateLimitRepository is the GORM-backed otp.RateLimitStore, for limits
+// shared by every instance using the database. Each decision locks the
+// bucket row for the duration of a short transaction.
+type RateLimitRepository struct {
+	db *gorm.DB
+}
+
+var _ otp.RateLimitStore = (*RateLimitRepository)(nil)
+
+func NewRateLimitRepository(db *gorm.DB) *RateLimitRepository {
+	return &RateLimitRepository{db: db}
+}
+
+func (r *RateLimitRepository) Allow(ctx context.Context, key string, limit otp.Limit, now time.Time) (otp.RateLimitResult, error) {
+	var result otp.RateLimitResult
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists so it can be locked; a missing bucket
+		// is a full one.
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RateLimitBucket{BucketKey: key, Tokens: float64(limit.Requests), UpdatedAt: now, FullAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		var row RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "bucket_key = ?", key).Error; err != nil {
+			return err
+		}
+
+		var bucket otp.Bucket
+		bucket, result = limit.Take(otp.Bucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt}, now)
+		return tx.Model(&RateLimitBucket{}).Where("bucket_key = ?", key).Updates(map[string]interface{}{
+			"tokens":     bucket.Tokens,
+			"updated_at": bucket.UpdatedAt,
+			"full_at":    limit.FullAt(bucket),
+		}).Error
+	})
+	return result, err
+}
+
+func (r *RateLimitRepository) Refund(ctx context.Context, key string, limit otp.Limit, now time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var row RateLimitBucket
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "bucket_key = ?", key).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// A missing bucket is already full.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		bucket := limit.Refund(otp.Bucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt}, now)
+		return tx.Model(&RateLimitBucket{}).Where("bucket_key = ?", key).Updates(map[string]interface{}{
+			"tokens":     bucket.Tokens,
+			"updated_at": bucket.UpdatedAt,
+			"full_at":    limit.FullAt(bucket),
+		}).Error
+	})
+}
+
+// Purge deletes buckets that are full at now and returns how many were removed.
+func (r *RateLimitRepository) Purge(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("full_at <= ?", now).Delete(&RateLimitBucket{})
+	return result.RowsAffected, result.Error
+}
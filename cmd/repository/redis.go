@@ -0,0 +1,345 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+	"github.com/google/uuid"
+)
+
+// maxTxAttempts bounds how often an optimistic transaction is retried when a
+// concurrent writer changes the watched record.
+const maxTxAttempts = 100
+
+// RedisOTPRepository stores OTPs as JSON values in a Redis-compatible server.
+// Each record key expires natively at the OTP's ExpiresAt, and read-modify-
+// write updates run as WATCH/MULTI/EXEC transactions.
+type RedisOTPRepository struct {
+	client *redis.Client
+	prefix string
+}
+
+var _ otp.OTPStore = (*RedisOTPRepository)(nil)
+
+// NewRedisOTPRepository creates a store whose keys all start with prefix
+// (e.g. "otp:").
+func NewRedisOTPRepository(client *redis.Client, prefix string) *RedisOTPRepository {
+	return &RedisOTPRepository{client: client, prefix: prefix}
+}
+
+func (r *RedisOTPRepository) recordKey(id uuid.UUID) string {
+	return r.prefix + "id:" + id.String()
+}
+
+func (r *RedisOTPRepository) recipientKey(purpose, recipient string) string {
+	return r.prefix + "recipient:" + purpose + ":" + recipient
+}
+
+func (r *RedisOTPRepository) SaveOTP(ctx context.Context, record *otp.OTP) error {
+	if record.ID == uuid.Nil {
+		record.ID = uuid.New()
+	}
+	now := time.Now()
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	if record.Status == "" {
+		record.Status = otp.OTPStatusPending
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	expireAt := strconv.FormatInt(record.ExpiresAt.UnixMilli(), 10)
+
+	cmds := [][]string{{"SET", r.recordKey(record.ID), string(value), "PXAT", expireAt}}
+	for _, recipient := range []string{record.MobileNumber, record.Email} {
+		if recipient != "" {
+			cmds = append(cmds, []string{"SET", r.recipientKey(record.Purpose, recipient), record.ID.String(), "PXAT", expireAt})
+		}
+	}
+
+	_, err = r.client.Exec(ctx, cmds...)
+	return err
+}
+
+func (r *RedisOTPRepository) GetOTPByID(ctx context.Context, id uuid.UUID) (*otp.OTP, error) {
+	return r.get(ctx, r.client.Do, id)
+}
+
+func (r *RedisOTPRepository) get(ctx context.Context, do func(context.Context, ...string) (interface{}, error), id uuid.UUID) (*otp.OTP, error) {
+	value, err := redis.String(do(ctx, "GET", r.recordKey(id)))
+	if errors.Is(err, redis.Nil) {
+		return nil, otp.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record otp.OTP
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *RedisOTPRepository) GetValidOTPByPurpose(ctx context.Context, recipient, purpose string) (*otp.OTP, error) {
+	rawID, err := redis.String(r.client.Do(ctx, "GET", r.recipientKey(purpose, recipient)))
+	if errors.Is(err, redis.Nil) {
+		return nil, otp.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, err
+	}
+	record, err := r.get(ctx, r.client.Do, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != otp.OTPStatusPending || record.Purpose != purpose {
+		return nil, otp.ErrNotFound
+	}
+	return record, nil
+}
+
+func (r *RedisOTPRepository) UpdateOTPStatus(ctx context.Context, id uuid.UUID, status string) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		record.Status = status
+		return nil
+	})
+}
+
+func (r *RedisOTPRepository) UpdateHashedOTP(ctx context.Context, id uuid.UUID, hashedOTP string) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		record.HashedOTP = hashedOTP
+		return nil
+	})
+}
+
+func (r *RedisOTPRepository) IncrementRetryCount(ctx context.Context, id uuid.UUID) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		record.RetryCount++
+		return nil
+	})
+}
+
+func (r *RedisOTPRepository) ConsumeOTP(ctx context.Context, id uuid.UUID, status string, now time.Time) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		if !record.Consumable(now) {
+			return otp.ErrNoLongerValid
+		}
+		record.Status = status
+		return nil
+	})
+}
+
+func (r *RedisOTPRepository) ExpireOTP(ctx context.Context, id uuid.UUID) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		if record.Status != otp.OTPStatusPending {
+			return otp.ErrNoLongerValid
+		}
+		record.Status = otp.OTPStatusExpired
+		return nil
+	})
+}
+
+func (r *RedisOTPRepository) RecordFailedAttempt(ctx context.Context, id uuid.UUID, now time.Time) (*otp.OTP, error) {
+	var updated *otp.OTP
+	err := r.update(ctx, id, func(record *otp.OTP) error {
+		if !record.Consumable(now) {
+			return otp.ErrNoLongerValid
+		}
+		record.RetryCount++
+		if record.RetryCount >= record.RetryLimit {
+			record.Status = otp.OTPStatusExpired
+		}
+		updated = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *RedisOTPRepository) RecordResend(ctx context.Context, id uuid.UUID, resendCount int, hashedOTP, delivery string, now time.Time) (*otp.OTP, error) {
+	var updated *otp.OTP
+	err := r.update(ctx, id, func(record *otp.OTP) error {
+		if !resendable(record, resendCount, now) {
+			return otp.ErrNoLongerValid
+		}
+		applyResend(record, hashedOTP, delivery, now)
+		updated = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *RedisOTPRepository) RevertResend(ctx context.Context, id uuid.UUID, resendCount int, previous *otp.OTP) error {
+	return r.update(ctx, id, func(record *otp.OTP) error {
+		if record.ResendCount != resendCount {
+			return otp.ErrNoLongerValid
+		}
+		revertResend(record, previous)
+		return nil
+	})
+}
+
+// update applies a read-modify-write to a record inside an optimistic
+// transaction, retrying when another client changed the record in between.
+// The key keeps its expiry. An error returned by apply aborts the update.
+func (r *RedisOTPRepository) update(ctx context.Context, id uuid.UUID, apply func(record *otp.OTP) error) error {
+	key := r.recordKey(id)
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err := r.client.Watch(ctx, func(conn *redis.Conn) error {
+			record, err := r.get(ctx, conn.Do, id)
+			if err != nil {
+				return err
+			}
+			if err := apply(record); err != nil {
+				return err
+			}
+			record.UpdatedAt = time.Now()
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			_, err = conn.Exec(ctx, []string{"SET", key, string(value), "KEEPTTL"})
+			return err
+		}, key)
+		if !errors.Is(err, redis.ErrTxAborted) {
+			return err
+		}
+		// Back off with jitter so contending writers spread out.
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(attempt+1) * int64(time.Millisecond)))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return redis.ErrTxAborted
+}
+
+var _ otp.PayloadRotationStore = (*RedisOTPRepository)(nil)
+
+// ListPayloadsToRotate scans every record with KEYS, so key rotation should
+// run off-peak on large keyspaces.
+func (r *RedisOTPRepository) ListPayloadsToRotate(ctx context.Context, keyID string, after uuid.UUID, limit int) ([]*otp.OTP, error) {
+	reply, err := r.client.Do(ctx, "KEYS", r.prefix+"id:*")
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := reply.([]interface{})
+	var records []*otp.OTP
+	for _, key := range keys {
+		rawID, _ := key.(string)
+		id, err := uuid.Parse(strings.TrimPrefix(rawID, r.prefix+"id:"))
+		if err != nil || id.String() <= after.String() {
+			continue
+		}
+		record, err := r.get(ctx, r.client.Do, id)
+		if errors.Is(err, otp.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.TransactionPayload != "" && record.PayloadKeyID != keyID {
+			records = append(records, record)
+		}
+	}
+	return firstByID(records, limit), nil
+}
+
+func (r *RedisOTPRepository) UpdatePayload(ctx context.Context, id uuid.UUID, oldKeyID, keyID, payload string) (bool, error) {
+	updated := false
+	err := r.update(ctx, id, func(record *otp.OTP) error {
+		if record.PayloadKeyID != oldKeyID {
+			return errPayloadRotated
+		}
+		record.TransactionPayload = payload
+		record.PayloadKeyID = keyID
+		updated = true
+		return nil
+	})
+	if errors.Is(err, errPayloadRotated) {
+		return false, nil
+	}
+	return updated, err
+}
+
+// errPayloadRotated aborts an UpdatePayload whose record was re-encrypted
+// concurrently.
+var errPayloadRotated = errors.New("payload already rotated")
+
+var _ otp.RecipientDataStore = (*RedisOTPRepository)(nil)
+
+// ListByRecipient scans every record with KEYS, like ListPayloadsToRotate.
+func (r *RedisOTPRepository) ListByRecipient(ctx context.Context, recipient string) ([]*otp.OTP, error) {
+	reply, err := r.client.Do(ctx, "KEYS", r.prefix+"id:*")
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := reply.([]interface{})
+	var records []*otp.OTP
+	for _, key := range keys {
+		rawID, _ := key.(string)
+		id, err := uuid.Parse(strings.TrimPrefix(rawID, r.prefix+"id:"))
+		if err != nil {
+			continue
+		}
+		record, err := r.get(ctx, r.client.Do, id)
+		if errors.Is(err, otp.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.MobileNumber == recipient || record.Email == recipient {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// RedactOTP also deletes the record's recipient lookup keys, whose names
+// contain the recipient.
+func (r *RedisOTPRepository) RedactOTP(ctx context.Context, id uuid.UUID, mobileNumber, email string) error {
+	var lookupKeys []string
+	err := r.update(ctx, id, func(record *otp.OTP) error {
+		lookupKeys = lookupKeys[:0]
+		for _, recipient := range []string{record.MobileNumber, record.Email} {
+			if recipient != "" {
+				lookupKeys = append(lookupKeys, r.recipientKey(record.Purpose, recipient))
+			}
+		}
+		record.MobileNumber, record.Email = mobileNumber, email
+		record.TransactionPayload, record.PayloadDigest, record.PayloadKeyID = "", "", ""
+		record.SMSBody, record.EmailSubject, record.EmailBody = "", "", ""
+		if record.Status == otp.OTPStatusPending {
+			record.Status = otp.OTPStatusExpired
+		}
+		return nil
+	})
+	if err != nil || len(lookupKeys) == 0 {
+		return err
+	}
+	_, err = r.client.Do(ctx, append([]string{"DEL"}, lookupKeys...)...)
+	return err
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/otp/otptest"
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+	"github.com/Zaman-R/otp-validator/cmd/redis/redistest"
+)
+
+func newRedisRepository(t *testing.T) (*RedisOTPRepository, *redistest.Server) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewRedisOTPRepository(client, "otp:"), server
+}
+
+func newRedisStore(t *testing.T) otp.OTPStore {
+	store, _ := newRedisRepository(t)
+	return store
+}
+
+func TestRedisOTPRepository(t *testing.T) {
+	otptest.TestStore(t, newRedisStore)
+}
+
+func TestRedisOTPRepositoryConcurrency(t *testing.T) {
+	otptest.TestConcurrency(t, newRedisStore)
+}
+
+func TestRedisOTPRepositoryUpdatesKeepExpiry(t *testing.T) {
+	store, server := newRedisRepository(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetClock(func() time.Time { return now })
+
+	record := &otp.OTP{
+		Purpose:      "login",
+		HashedOTP:    "$bcrypt$cost=4$hash",
+		MobileNumber: "+15550100",
+		RetryLimit:   3,
+		ExpiresAt:    now.Add(5 * time.Minute),
+		Status:       otp.OTPStatusPending,
+	}
+	if err := store.SaveOTP(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RecordFailedAttempt(ctx, record.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ConsumeOTP(ctx, record.ID, otp.OTPStatusVerified, now); err != nil {
+		t.Fatal(err)
+	}
+
+	client := server.Client()
+	defer client.Close()
+	ttl, err := redis.Int(client.Do(ctx, "PTTL", store.recordKey(record.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (5 * time.Minute).Milliseconds(); ttl <= 0 || ttl > want {
+		t.Errorf("record TTL after updates = %dms, want at most %dms", ttl, want)
+	}
+
+	now = record.ExpiresAt
+	if _, err := store.GetOTPByID(ctx, record.ID); !errors.Is(err, otp.ErrNotFound) {
+		t.Errorf("GetOTPByID after ExpiresAt error = %v, want otp.ErrNotFound", err)
+	}
+	if _, err := store.GetValidOTPByPurpose(ctx, record.MobileNumber, record.Purpose); !errors.Is(err, otp.ErrNotFound) {
+		t.Errorf("GetValidOTPByPurpose after ExpiresAt error = %v, want otp.ErrNotFound", err)
+	}
+}
+
+func TestRedisOTPRepositoryFailedAttemptsExpire(t *testing.T) {
+	store, _ := newRedisRepository(t)
+	ctx := context.Background()
+	record := &otp.OTP{
+		Purpose:    "login",
+		HashedOTP:  "$bcrypt$cost=4$hash",
+		Email:      "user@example.com",
+		RetryLimit: 2,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}
+	if err := store.SaveOTP(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 1; i <= 2; i++ {
+		updated, err := store.RecordFailedAttempt(ctx, record.ID, now)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if updated.RetryCount != i {
+			t.Errorf("attempt %d: RetryCount = %d", i, updated.RetryCount)
+		}
+	}
+	if _, err := store.RecordFailedAttempt(ctx, record.ID, now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.Errorf("attempt past the limit error = %v, want otp.ErrNoLongerValid", err)
+	}
+	if err := store.ConsumeOTP(ctx, record.ID, otp.OTPStatusVerified, now); !errors.Is(err, otp.ErrNoLongerValid) {
+		t.Errorf("ConsumeOTP after the retry limit error = %v, want otp.ErrNoLongerValid", err)
+	}
+	found, err := store.GetOTPByID(ctx, record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Status != otp.OTPStatusExpired {
+		t.Errorf("Status = %s, want %s", found.Status, otp.OTPStatusExpired)
+	}
+}
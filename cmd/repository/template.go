@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MessageTemplateRecord is a row of the otp_templates table.
+type MessageTemplateRecord struct {
+	Name      string `gorm:"type:varchar(100);primaryKey"`
+	Channel   string `gorm:"type:varchar(20);primaryKey;default:''"`
+	Subject   string `gorm:"type:varchar(255)"`
+	Text      string `gorm:"type:text;not null"`
+	HTML      string `gorm:"column:html;type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (MessageTemplateRecord) TableName() string {
+	return "otp_templates"
+}
+
+// TemplateRepository stores message templates in the otp_templates table.
+type TemplateRepository struct {
+	db *gorm.DB
+}
+
+func NewTemplateRepository(db *gorm.DB) *TemplateRepository {
+	return &TemplateRepository{db: db}
+}
+
+// LoadTemplates returns every stored template. They are validated when
+// registered with an otp.TemplateRegistry.
+func (r *TemplateRepository) LoadTemplates(ctx context.Context) ([]otp.MessageTemplate, error) {
+	var records []MessageTemplateRecord
+	if err := r.db.WithContext(ctx).Order("name, channel").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	templates := make([]otp.MessageTemplate, len(records))
+	for i, record := range records {
+		templates[i] = otp.MessageTemplate{
+			Name:    record.Name,
+			Channel: record.Channel,
+			Subject: record.Subject,
+			Text:    record.Text,
+			HTML:    record.HTML,
+		}
+	}
+	return templates, nil
+}
+
+// SaveTemplate validates t and stores it, replacing the template with the
+// same name and channel.
+func (r *TemplateRepository) SaveTemplate(ctx context.Context, t otp.MessageTemplate) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	now := time.Now()
+	record := MessageTemplateRecord{
+		Name:      t.Name,
+		Channel:   t.Channel,
+		Subject:   t.Subject,
+		Text:      t.Text,
+		HTML:      t.HTML,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "channel"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subject", "text", "html", "updated_at"}),
+	}).Create(&record).Error
+}
+
+// DeleteTemplate removes the template with name and channel, if any.
+func (r *TemplateRepository) DeleteTemplate(ctx context.Context, name, channel string) error {
+	return r.db.WithContext(ctx).Where("name = ? AND channel = ?", name, channel).
+		Delete(&MessageTemplateRecord{}).Error
+}
@@ -0,0 +1,65 @@
+// Command rotatekeys re-encrypts stored transaction payloads with the
+// primary key of the configured payload keyring. Run it after adding a new
+// primary key; once it reports no failures, retired keys can be removed.
+//
+//	go run ./cmd/rotatekeys -batch 500
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/Zaman-R/otp-validator/cmd/config"
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/redis"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+)
+
+func main() {
+	batch := flag.Int("batch", 500, "number of records re-encrypted per batch")
+	flag.Parse()
+
+	config.LoadConfig()
+
+	keyring, err := otp.NewKeyringFromConfig(config.ConfigOTP.PayloadKeyring, config.ConfigOTP.PayloadPrimaryKey, config.ConfigOTP.PayloadKeys)
+	if err != nil {
+		log.Fatalf("Failed to load payload keyring: %v", err)
+	}
+	if keyring == nil {
+		log.Fatal("No payload keyring configured; set OTP_PAYLOAD_KEYRING_FILE or OTP_PAYLOAD_KEYS")
+	}
+
+	var store otp.PayloadRotationStore
+	switch config.AppConfig.OTPStore {
+	case "redis":
+		store = repository.NewRedisOTPRepository(redis.NewClient(redis.Options{
+			Addr:     config.AppConfig.RedisAddr,
+			Password: config.AppConfig.RedisPassword,
+			DB:       config.AppConfig.RedisDB,
+		}), config.AppConfig.RedisPrefix)
+	case "memory":
+		log.Fatal("The memory store is not shared between processes; nothing to rotate")
+	default:
+		config.ConnectDB()
+		store = repository.NewOTPRepository(config.GetDB().GetDB())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := rotate(ctx, log.Default(), store, keyring, *batch); err != nil {
+		log.Fatalf("Rotation finished with errors: %v", err)
+	}
+}
+
+// rotate re-encrypts every payload in store with the primary key of keyring
+// and logs the totals.
+func rotate(ctx context.Context, logger *log.Logger, store otp.PayloadRotationStore, keyring *otp.Keyring, batch int) error {
+	logger.Printf("Re-encrypting payloads with key %q", keyring.Primary())
+	stats, err := otp.RotatePayloadKeys(ctx, store, keyring, batch)
+	logger.Printf("Scanned %d, re-encrypted %d, failed %d", stats.Scanned, stats.Rotated, stats.Failed)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/repository"
+	"github.com/google/uuid"
+)
+
+func newKeyring(t *testing.T, primary string, ids ...string) *otp.Keyring {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), otp.PayloadKeySize)
+	}
+	keyring, err := otp.NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestRotate(t *testing.T) {
+	store := repository.NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+
+	old := newKeyring(t, "k1", "k1")
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		now := time.Now()
+		record := &otp.OTP{
+			ID:           uuid.New(),
+			Purpose:      "transaction",
+			HashedOTP:    "$bcrypt$cost=4$unused",
+			MobileNumber: "+15551234567",
+			Status:       otp.OTPStatusPending,
+			RetryLimit:   3,
+			LastSentAt:   now,
+			ExpiresAt:    now.Add(5 * time.Minute),
+		}
+		keyID, sealed, err := old.Seal(record.ID, []byte(`{"amount":"100.00"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.PayloadKeyID, record.TransactionPayload = keyID, sealed
+		if err := store.SaveOTP(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, record.ID)
+	}
+
+	var out bytes.Buffer
+	keyring := newKeyring(t, "k2", "k1", "k2")
+	if err := rotate(ctx, log.New(&out, "", 0), store, keyring, 2); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if !strings.Contains(out.String(), "Scanned 3, re-encrypted 3, failed 0") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	current := newKeyring(t, "k2", "k2")
+	for _, id := range ids {
+		record, err := store.GetOTPByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := current.Open(id, record.PayloadKeyID, record.TransactionPayload); err != nil {
+			t.Errorf("OTP %s is not sealed with the new primary key: %v", id, err)
+		}
+	}
+}
+
+func TestRotateReportsFailures(t *testing.T) {
+	store := repository.NewMemoryOTPRepository(time.Hour)
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+
+	now := time.Now()
+	record := &otp.OTP{
+		ID:                 uuid.New(),
+		Purpose:            "transaction",
+		HashedOTP:          "$bcrypt$cost=4$unused",
+		MobileNumber:       "+15551234567",
+		Status:             otp.OTPStatusPending,
+		RetryLimit:         3,
+		LastSentAt:         now,
+		ExpiresAt:          now.Add(5 * time.Minute),
+		PayloadKeyID:       "k0",
+		TransactionPayload: "$aes-256-gcm$AAAA$AAAA",
+	}
+	if err := store.SaveOTP(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := rotate(ctx, log.New(&out, "", 0), store, newKeyring(t, "k2", "k2"), 10); err == nil {
+		t.Error("rotate succeeded with a payload sealed by a missing key")
+	}
+	if !strings.Contains(out.String(), "failed 1") {
+		t.Errorf("output = %q", out.String())
+	}
+}
@@ -0,0 +1,484 @@
+package smpp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/utils"
+)
+
+var (
+	// ErrClosed is returned by Submit after Close.
+	ErrClosed = errors.New("smpp: client closed")
+	// ErrConnectionLost is returned for requests whose connection failed
+	// before the response arrived. The SMSC may have received them.
+	ErrConnectionLost = errors.New("smpp: connection lost")
+	// ErrTimeout is returned for requests without a response within
+	// Options.ResponseTimeout. The SMSC may have received them.
+	ErrTimeout = errors.New("smpp: response timeout")
+)
+
+const (
+	DefaultWindowSize          = 10
+	DefaultEnquireLinkInterval = 30 * time.Second
+	DefaultResponseTimeout     = 10 * time.Second
+	DefaultRebindDelay         = time.Second
+	DefaultMaxRebindDelay      = 30 * time.Second
+)
+
+type Options struct {
+	Addr       string
+	SystemID   string
+	Password   string
+	SystemType string
+	// TLSConfig, when set, connects with TLS.
+	TLSConfig *tls.Config
+	// WindowSize is the number of submits awaiting a response at once.
+	WindowSize int
+	// EnquireLinkInterval is how often the bind is checked; a bind whose
+	// enquire_link is not answered is dropped and rebound.
+	EnquireLinkInterval time.Duration
+	ResponseTimeout     time.Duration
+	// RebindDelay is the wait after a failed bind, doubling up to
+	// MaxRebindDelay.
+	RebindDelay    time.Duration
+	MaxRebindDelay time.Duration
+	// OnReceipt is called with each delivery receipt, on the goroutine
+	// reading the connection, so it must not block.
+	OnReceipt func(Receipt)
+	// Logger defaults to utils.DefaultLogger.
+	Logger *slog.Logger
+}
+
+// Message is a text message to submit.
+type Message struct {
+	// Source is the sender: a number, "+" and a number in international
+	// format, or an alphanumeric ID.
+	Source      string
+	Destination string
+	Text        string
+	// RegisteredDelivery requests a delivery receipt.
+	RegisteredDelivery bool
+}
+
+// Client keeps a transceiver bind to an SMSC, rebinding when it is lost. It
+// is safe for concurrent use.
+type Client struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	window chan struct{}
+	ref    atomic.Uint32
+
+	mu      sync.Mutex
+	sess    *session
+	bound   chan struct{}
+	lastErr error
+}
+
+// NewClient returns a client and starts binding in the background. Submit
+// waits for the bind.
+func NewClient(opts Options) (*Client, error) {
+	if opts.Addr == "" || opts.SystemID == "" {
+		return nil, errors.New("smpp: address and system ID are required")
+	}
+	if opts.WindowSize <= 0 {
+		opts.WindowSize = DefaultWindowSize
+	}
+	if opts.EnquireLinkInterval == 0 {
+		opts.EnquireLinkInterval = DefaultEnquireLinkInterval
+	}
+	if opts.ResponseTimeout == 0 {
+		opts.ResponseTimeout = DefaultResponseTimeout
+	}
+	if opts.RebindDelay == 0 {
+		opts.RebindDelay = DefaultRebindDelay
+	}
+	if opts.MaxRebindDelay < opts.RebindDelay {
+		opts.MaxRebindDelay = max(DefaultMaxRebindDelay, opts.RebindDelay)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		window: make(chan struct{}, opts.WindowSize),
+		bound:  make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.opts.Logger != nil {
+		return utils.Redacting(c.opts.Logger)
+	}
+	return utils.DefaultLogger()
+}
+
+// Submit sends msg, split into concatenated parts if needed, and returns
+// the message ID of each part.
+func (c *Client) Submit(ctx context.Context, msg Message) ([]string, error) {
+	coding, parts, err := EncodeText(msg.Text)
+	if err != nil {
+		return nil, err
+	}
+	sm := ShortMessage{DataCoding: coding}
+	sm.SourceTON, sm.SourceNPI, sm.Source = addressType(msg.Source)
+	sm.DestTON, sm.DestNPI, sm.Destination = addressType(msg.Destination)
+	if msg.RegisteredDelivery {
+		sm.RegisteredDelivery = 1
+	}
+
+	ref := byte(c.ref.Add(1))
+	ids := make([]string, 0, len(parts))
+	for i, part := range parts {
+		sm.Message = part
+		if len(parts) > 1 {
+			sm.ESMClass = ESMClassUDHI
+			sm.Message = append(concatHeader(ref, len(parts), i+1), part...)
+		}
+		body, err := sm.MarshalBinary()
+		if err != nil {
+			return ids, err
+		}
+		id, err := c.submit(ctx, body)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// submit sends one submit_sm within the window.
+func (c *Client) submit(ctx context.Context, body []byte) (string, error) {
+	select {
+	case c.window <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-c.ctx.Done():
+		return "", ErrClosed
+	}
+	defer func() { <-c.window }()
+
+	sess, err := c.session(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sess.request(ctx, SubmitSM, body)
+	if err != nil {
+		return "", err
+	}
+	return ParseMessageID(resp.Body)
+}
+
+// session waits for a bound session.
+func (c *Client) session(ctx context.Context) (*session, error) {
+	for {
+		c.mu.Lock()
+		sess, bound := c.sess, c.bound
+		c.mu.Unlock()
+		if sess != nil {
+			return sess, nil
+		}
+		select {
+		case <-bound:
+		case <-c.ctx.Done():
+			return nil, ErrClosed
+		case <-ctx.Done():
+			c.mu.Lock()
+			lastErr := c.lastErr
+			c.mu.Unlock()
+			if lastErr != nil {
+				return nil, fmt.Errorf("smpp: not bound: %w (last error: %v)", ctx.Err(), lastErr)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close unbinds and stops rebinding. Submits waiting for a bind fail with
+// ErrClosed.
+func (c *Client) Close(ctx context.Context) error {
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run binds, waits for the session to end and rebinds, until Close.
+func (c *Client) run() {
+	defer close(c.done)
+	delay := c.opts.RebindDelay
+	for {
+		sess, err := c.bind()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.mu.Lock()
+			c.lastErr = err
+			c.mu.Unlock()
+			c.logger().Warn("SMPP bind failed", "addr", c.opts.Addr, "retry_in", delay, "error", err)
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, c.opts.MaxRebindDelay)
+			continue
+		}
+		delay = c.opts.RebindDelay
+
+		c.mu.Lock()
+		c.sess, c.lastErr = sess, nil
+		close(c.bound)
+		c.mu.Unlock()
+		c.logger().Info("SMPP bound", "addr", c.opts.Addr, "system_id", c.opts.SystemID)
+
+		select {
+		case <-sess.done:
+		case <-c.ctx.Done():
+			sess.unbind()
+		}
+		// The session may have failed before it was published above, when
+		// lost had nothing to forget yet.
+		c.lost(sess)
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger().Warn("SMPP bind lost, rebinding", "addr", c.opts.Addr, "error", sess.err)
+	}
+}
+
+// lost forgets sess if it is the bound session.
+func (c *Client) lost(sess *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sess == sess {
+		c.sess, c.lastErr = nil, sess.err
+		c.bound = make(chan struct{})
+	}
+}
+
+func (c *Client) bind() (*session, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.opts.ResponseTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if c.opts.TLSConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.opts.TLSConfig}).DialContext(ctx, "tcp", c.opts.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smpp: connect: %w", err)
+	}
+
+	sess := newSession(c, conn)
+	go sess.read()
+	body, _ := Bind{SystemID: c.opts.SystemID, Password: c.opts.Password, SystemType: c.opts.SystemType}.MarshalBinary()
+	if _, err := sess.request(ctx, BindTransceiver, body); err != nil {
+		sess.fail(err)
+		return nil, fmt.Errorf("smpp: bind: %w", err)
+	}
+	go sess.keepalive()
+	return sess, nil
+}
+
+// addressType returns the TON, NPI and value of addr: international for a
+// leading "+", unknown for other numbers and alphanumeric otherwise.
+func addressType(addr string) (ton, npi byte, value string) {
+	if number, ok := strings.CutPrefix(addr, "+"); ok {
+		return 1, 1, number
+	}
+	if addr != "" && strings.Trim(addr, "0123456789") == "" {
+		return 0, 1, addr
+	}
+	return 5, 0, addr
+}
+
+// session is one bound connection.
+type session struct {
+	c       *Client
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]chan PDU
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newSession(c *Client, conn net.Conn) *session {
+	return &session{c: c, conn: conn, pending: make(map[uint32]chan PDU), done: make(chan struct{})}
+}
+
+// fail ends the session with err. Submits wait for the next bind from
+// then on.
+func (s *session) fail(err error) {
+	s.once.Do(func() {
+		s.err = err
+		s.conn.Close()
+		s.c.lost(s)
+		close(s.done)
+	})
+}
+
+func (s *session) write(p PDU) error {
+	b, _ := p.MarshalBinary()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(s.c.opts.ResponseTimeout))
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// request sends a request and waits for its response.
+func (s *session) request(ctx context.Context, commandID uint32, body []byte) (PDU, error) {
+	ch := make(chan PDU, 1)
+	s.mu.Lock()
+	s.seq++
+	if s.seq > 0x7FFFFFFF {
+		s.seq = 1
+	}
+	seq := s.seq
+	s.pending[seq] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		s.mu.Unlock()
+	}()
+
+	if err := s.write(PDU{CommandID: commandID, Sequence: seq, Body: body}); err != nil {
+		s.fail(err)
+		return PDU{}, fmt.Errorf("%w: %v", ErrConnectionLost, err)
+	}
+	timer := time.NewTimer(s.c.opts.ResponseTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.CommandID == GenericNack || resp.Status != StatusOK {
+			return resp, &StatusError{CommandID: commandID, Status: resp.Status}
+		}
+		return resp, nil
+	case <-s.done:
+		return PDU{}, fmt.Errorf("%w: %v", ErrConnectionLost, s.err)
+	case <-ctx.Done():
+		return PDU{}, ctx.Err()
+	case <-timer.C:
+		return PDU{}, ErrTimeout
+	}
+}
+
+// read dispatches incoming PDUs until the connection fails.
+func (s *session) read() {
+	for {
+		p, err := ReadPDU(s.conn)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if p.IsResponse() {
+			s.mu.Lock()
+			ch := s.pending[p.Sequence]
+			s.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- p:
+				default:
+				}
+			}
+			continue
+		}
+
+		switch p.CommandID {
+		case DeliverSM:
+			s.reply(p, DeliverSMResp, MessageIDBody(""))
+			s.deliver(p)
+		case EnquireLink:
+			s.reply(p, EnquireLinkResp, nil)
+		case Unbind:
+			s.reply(p, UnbindResp, nil)
+			s.fail(errors.New("smpp: unbound by SMSC"))
+			return
+		default:
+			if err := s.write(PDU{CommandID: GenericNack, Status: StatusInvalidCmd, Sequence: p.Sequence}); err != nil {
+				s.fail(err)
+			}
+		}
+	}
+}
+
+func (s *session) reply(p PDU, commandID uint32, body []byte) {
+	if err := s.write(PDU{CommandID: commandID, Sequence: p.Sequence, Body: body}); err != nil {
+		s.fail(err)
+	}
+}
+
+// deliver passes delivery receipts to OnReceipt and ignores mobile
+// originated messages.
+func (s *session) deliver(p PDU) {
+	sm, err := ParseShortMessage(p.Body)
+	if err != nil {
+		s.c.logger().Warn("SMPP deliver_sm malformed", "addr", s.c.opts.Addr, "error", err)
+		return
+	}
+	if sm.ESMClass&0x3C != ESMClassReceipt {
+		s.c.logger().Debug("SMPP ignoring mobile originated message", "addr", s.c.opts.Addr)
+		return
+	}
+	if s.c.opts.OnReceipt != nil {
+		s.c.opts.OnReceipt(ParseReceipt(sm))
+	}
+}
+
+// keepalive sends enquire_link until the session ends, ending it when one
+// is not answered.
+func (s *session) keepalive() {
+	ticker := time.NewTicker(s.c.opts.EnquireLinkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.request(context.Background(), EnquireLink, nil); err != nil {
+				s.fail(fmt.Errorf("smpp: enquire_link: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// unbind ends the session politely.
+func (s *session) unbind() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.c.opts.ResponseTimeout)
+	defer cancel()
+	_, err := s.request(ctx, Unbind, nil)
+	if err == nil {
+		err = ErrClosed
+	}
+	s.fail(err)
+}
@@ -0,0 +1,314 @@
+package smpp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/smpp"
+	"github.com/Zaman-R/otp-validator/cmd/smpp/smpptest"
+)
+
+func newServer(t *testing.T, opts smpptest.Options) *smpptest.Server {
+	t.Helper()
+	server, err := smpptest.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newClient(t *testing.T, opts smpp.Options) *smpp.Client {
+	t.Helper()
+	if opts.SystemID == "" {
+		opts.SystemID = "otp"
+	}
+	if opts.RebindDelay == 0 {
+		opts.RebindDelay = 10 * time.Millisecond
+	}
+	if opts.ResponseTimeout == 0 {
+		opts.ResponseTimeout = 2 * time.Second
+	}
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := smpp.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Close(ctx); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return client
+}
+
+// waitFor polls cond for up to 5s.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func submit(t *testing.T, client *smpp.Client, msg smpp.Message) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ids, err := client.Submit(ctx, msg)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	return ids
+}
+
+func TestClientBindAndSubmit(t *testing.T) {
+	server := newServer(t, smpptest.Options{SystemID: "otp", Password: "secret"})
+	client := newClient(t, smpp.Options{Addr: server.Addr, SystemID: "otp", Password: "secret"})
+
+	ids := submit(t, client, smpp.Message{Source: "Acme", Destination: "+4915112345678", Text: "Your code is 123456"})
+	if len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("ids = %q, want one ID", ids)
+	}
+	if got := server.Binds(); got != 1 {
+		t.Errorf("Binds() = %d, want 1", got)
+	}
+
+	submits := server.Submits()
+	if len(submits) != 1 {
+		t.Fatalf("server received %d submits, want 1", len(submits))
+	}
+	sm := submits[0]
+	if sm.DataCoding != smpp.CodingDefault || sm.ESMClass != 0 {
+		t.Errorf("data coding %#x, ESM class %#x; want GSM-7 without UDH", sm.DataCoding, sm.ESMClass)
+	}
+	if got := smpp.DecodeText(sm.DataCoding, sm.Message); got != "Your code is 123456" {
+		t.Errorf("text = %q", got)
+	}
+	if sm.DestTON != 1 || sm.DestNPI != 1 || sm.Destination != "4915112345678" {
+		t.Errorf("destination = %d/%d/%q, want international 4915112345678", sm.DestTON, sm.DestNPI, sm.Destination)
+	}
+	if sm.SourceTON != 5 || sm.Source != "Acme" {
+		t.Errorf("source = %d/%q, want alphanumeric Acme", sm.SourceTON, sm.Source)
+	}
+}
+
+func TestClientBindRejected(t *testing.T) {
+	server := newServer(t, smpptest.Options{SystemID: "otp", Password: "secret"})
+	client := newClient(t, smpp.Options{Addr: server.Addr, SystemID: "otp", Password: "wrong"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := client.Submit(ctx, smpp.Message{Destination: "+1555", Text: "code"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Submit error = %v, want a deadline error", err)
+	}
+	if !strings.Contains(err.Error(), "ESME_RINVPASWD") {
+		t.Errorf("Submit error %q does not report the bind failure", err)
+	}
+}
+
+// reassemble checks the concatenation headers of parts and returns their
+// decoded text.
+func reassemble(t *testing.T, parts []smpp.ShortMessage) string {
+	t.Helper()
+	var text strings.Builder
+	for i, sm := range parts {
+		if sm.ESMClass&smpp.ESMClassUDHI == 0 {
+			t.Fatalf("part %d has no UDH indicator", i+1)
+		}
+		udh := sm.Message[:6]
+		if udh[0] != 5 || udh[1] != 0 || udh[2] != 3 || udh[3] != parts[0].Message[3] ||
+			int(udh[4]) != len(parts) || int(udh[5]) != i+1 {
+			t.Fatalf("part %d has UDH % x", i+1, udh)
+		}
+		text.WriteString(smpp.DecodeText(sm.DataCoding, sm.Message[6:]))
+	}
+	return text.String()
+}
+
+func TestClientSubmitConcatenated(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		coding byte
+		parts  int
+	}{
+		{"GSM-7", strings.Repeat("0123456789", 20), smpp.CodingDefault, 2},
+		{"GSM-7 extension", strings.Repeat("{}", 80), smpp.CodingDefault, 3},
+		{"UCS-2", strings.Repeat("код ", 20), smpp.CodingUCS2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, smpptest.Options{})
+			client := newClient(t, smpp.Options{Addr: server.Addr})
+
+			ids := submit(t, client, smpp.Message{Destination: "+1555", Text: tt.text})
+			if len(ids) != tt.parts {
+				t.Fatalf("got %d IDs, want %d", len(ids), tt.parts)
+			}
+			parts := server.Submits()
+			if len(parts) != tt.parts {
+				t.Fatalf("server received %d parts, want %d", len(parts), tt.parts)
+			}
+			for _, sm := range parts {
+				if sm.DataCoding != tt.coding {
+					t.Fatalf("data coding = %#x, want %#x", sm.DataCoding, tt.coding)
+				}
+			}
+			if got := reassemble(t, parts); got != tt.text {
+				t.Errorf("reassembled text = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestClientWindow(t *testing.T) {
+	server := newServer(t, smpptest.Options{ResponseDelay: 50 * time.Millisecond})
+	client := newClient(t, smpp.Options{Addr: server.Addr, WindowSize: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := client.Submit(ctx, smpp.Message{Destination: "+1555", Text: "code"}); err != nil {
+				t.Errorf("Submit: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := server.MaxInFlight(); got != 2 {
+		t.Errorf("MaxInFlight() = %d, want the window size 2", got)
+	}
+	if got := len(server.Submits()); got != 6 {
+		t.Errorf("server received %d submits, want 6", got)
+	}
+}
+
+func TestClientRebindsAfterDrop(t *testing.T) {
+	server := newServer(t, smpptest.Options{})
+	client := newClient(t, smpp.Options{Addr: server.Addr})
+
+	submit(t, client, smpp.Message{Destination: "+1555", Text: "first"})
+	server.DropConnections()
+	// Submits racing the drop may fail with ErrConnectionLost; wait for
+	// the new bind.
+	waitFor(t, func() bool { return server.Binds() == 2 })
+	submit(t, client, smpp.Message{Destination: "+1555", Text: "second"})
+
+	if got := server.Binds(); got != 2 {
+		t.Errorf("Binds() = %d, want 2", got)
+	}
+	if got := len(server.Submits()); got != 2 {
+		t.Errorf("server received %d submits, want 2", got)
+	}
+}
+
+// TestClientConnectionDroppedAfterBind covers an SMSC that accepts binds and
+// closes the connection straight away, so sessions fail before the client
+// has published them.
+func TestClientConnectionDroppedAfterBind(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var binds atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if p, err := smpp.ReadPDU(conn); err == nil && p.CommandID == smpp.BindTransceiver {
+				resp, _ := smpp.PDU{CommandID: smpp.BindTransceiverResp, Sequence: p.Sequence, Body: smpp.MessageIDBody("smsc")}.MarshalBinary()
+				conn.Write(resp)
+				binds.Add(1)
+			}
+			conn.Close()
+		}
+	}()
+
+	client := newClient(t, smpp.Options{Addr: listener.Addr().String(), RebindDelay: time.Millisecond})
+	waitFor(t, func() bool { return binds.Load() >= 10 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.Submit(ctx, smpp.Message{Destination: "+1555", Text: "code"}); err == nil {
+		t.Error("Submit succeeded over connections that are closed after the bind")
+	}
+}
+
+func TestClientSubmitRejected(t *testing.T) {
+	server := newServer(t, smpptest.Options{
+		SubmitStatus: func(smpp.ShortMessage) uint32 { return smpp.StatusThrottled },
+	})
+	client := newClient(t, smpp.Options{Addr: server.Addr})
+
+	_, err := client.Submit(context.Background(), smpp.Message{Destination: "+1555", Text: "code"})
+	var statusErr *smpp.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != smpp.StatusThrottled {
+		t.Fatalf("Submit error = %v, want ESME_RTHROTTLED", err)
+	}
+	if !statusErr.Temporary() {
+		t.Error("throttled submit is not temporary")
+	}
+}
+
+func TestClientReceipts(t *testing.T) {
+	server := newServer(t, smpptest.Options{ReceiptState: "UNDELIV"})
+	receipts := make(chan smpp.Receipt, 1)
+	client := newClient(t, smpp.Options{
+		Addr:      server.Addr,
+		OnReceipt: func(r smpp.Receipt) { receipts <- r },
+	})
+
+	ids := submit(t, client, smpp.Message{Source: "12345", Destination: "+1555", Text: "code", RegisteredDelivery: true})
+	select {
+	case receipt := <-receipts:
+		if receipt.MessageID != ids[0] {
+			t.Errorf("receipt for %q, want %q", receipt.MessageID, ids[0])
+		}
+		if receipt.State != "UNDELIV" || receipt.Delivered() {
+			t.Errorf("receipt state = %q, delivered = %v", receipt.State, receipt.Delivered())
+		}
+		if receipt.Source != "1555" || receipt.Destination != "12345" {
+			t.Errorf("receipt from %q to %q", receipt.Source, receipt.Destination)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no receipt within 5s")
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	server := newServer(t, smpptest.Options{})
+	client, err := smpp.NewClient(smpp.Options{Addr: server.Addr, SystemID: "otp", Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit(t, client, smpp.Message{Destination: "+1555", Text: "code"})
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := client.Submit(context.Background(), smpp.Message{Destination: "+1555", Text: "code"}); !errors.Is(err, smpp.ErrClosed) {
+		t.Errorf("Submit after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,343 @@
+// Package smpp is a small SMPP v3.4 client for sending SMS through an SMSC.
+// It covers what an OTP sender needs: bind_transceiver, submit_sm with
+// GSM-7 or UCS-2 text split into concatenated parts, enquire_link
+// keepalives, automatic rebinds, a window of outstanding requests and
+// delivery receipts from deliver_sm.
+package smpp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Command IDs. Responses have the high bit set.
+const (
+	GenericNack         uint32 = 0x80000000
+	BindTransceiver     uint32 = 0x00000009
+	BindTransceiverResp uint32 = 0x80000009
+	SubmitSM            uint32 = 0x00000004
+	SubmitSMResp        uint32 = 0x80000004
+	DeliverSM           uint32 = 0x00000005
+	DeliverSMResp       uint32 = 0x80000005
+	Unbind              uint32 = 0x00000006
+	UnbindResp          uint32 = 0x80000006
+	EnquireLink         uint32 = 0x00000015
+	EnquireLinkResp     uint32 = 0x80000015
+)
+
+// Command status values used by this package.
+const (
+	StatusOK          uint32 = 0x00000000
+	StatusInvalidCmd  uint32 = 0x00000003
+	StatusInvalidDest uint32 = 0x0000000B
+	StatusBindFailed  uint32 = 0x0000000D
+	StatusInvalidPass uint32 = 0x0000000E
+	StatusInvalidSys  uint32 = 0x0000000F
+	StatusSysError    uint32 = 0x00000008
+	StatusMsgQueueFul uint32 = 0x00000014
+	StatusSubmitFail  uint32 = 0x00000045
+	StatusThrottled   uint32 = 0x00000058
+)
+
+// Optional parameter tags used by this package.
+const (
+	TagReceiptedMessageID uint16 = 0x001E
+	TagMessageState       uint16 = 0x0427
+)
+
+// InterfaceVersion is the version sent with binds.
+const InterfaceVersion = 0x34
+
+const (
+	headerLen = 16
+	// maxPDULen bounds the PDUs read, well above any valid submit or
+	// deliver.
+	maxPDULen = 64 * 1024
+)
+
+var errMalformed = errors.New("smpp: malformed PDU")
+
+// PDU is a protocol data unit with its body still encoded.
+type PDU struct {
+	CommandID uint32
+	Status    uint32
+	Sequence  uint32
+	Body      []byte
+}
+
+// IsResponse reports whether p answers a request.
+func (p PDU) IsResponse() bool {
+	return p.CommandID&0x80000000 != 0
+}
+
+// ReadPDU reads one PDU from r.
+func ReadPDU(r io.Reader) (PDU, error) {
+	var header [headerLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return PDU{}, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < headerLen || length > maxPDULen {
+		return PDU{}, fmt.Errorf("smpp: invalid PDU length %d", length)
+	}
+	p := PDU{
+		CommandID: binary.BigEndian.Uint32(header[4:8]),
+		Status:    binary.BigEndian.Uint32(header[8:12]),
+		Sequence:  binary.BigEndian.Uint32(header[12:16]),
+		Body:      make([]byte, length-headerLen),
+	}
+	if _, err := io.ReadFull(r, p.Body); err != nil {
+		return PDU{}, err
+	}
+	return p, nil
+}
+
+// MarshalBinary encodes p with its header.
+func (p PDU) MarshalBinary() ([]byte, error) {
+	b := make([]byte, headerLen, headerLen+len(p.Body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(headerLen+len(p.Body)))
+	binary.BigEndian.PutUint32(b[4:8], p.CommandID)
+	binary.BigEndian.PutUint32(b[8:12], p.Status)
+	binary.BigEndian.PutUint32(b[12:16], p.Sequence)
+	return append(b, p.Body...), nil
+}
+
+// Bind is the body of a bind_transceiver.
+type Bind struct {
+	SystemID     string
+	Password     string
+	SystemType   string
+	AddrTON      byte
+	AddrNPI      byte
+	AddressRange string
+}
+
+func (b Bind) MarshalBinary() ([]byte, error) {
+	var w writer
+	w.cstring(b.SystemID)
+	w.cstring(b.Password)
+	w.cstring(b.SystemType)
+	w.byte(InterfaceVersion)
+	w.byte(b.AddrTON)
+	w.byte(b.AddrNPI)
+	w.cstring(b.AddressRange)
+	return w.buf, nil
+}
+
+func ParseBind(body []byte) (Bind, error) {
+	r := reader{buf: body}
+	b := Bind{SystemID: r.cstring(), Password: r.cstring(), SystemType: r.cstring()}
+	r.byte() // interface_version
+	b.AddrTON, b.AddrNPI = r.byte(), r.byte()
+	b.AddressRange = r.cstring()
+	return b, r.err
+}
+
+// ShortMessage is the body of a submit_sm or deliver_sm, which share
+// their layout.
+type ShortMessage struct {
+	ServiceType          string
+	SourceTON            byte
+	SourceNPI            byte
+	Source               string
+	DestTON              byte
+	DestNPI              byte
+	Destination          string
+	ESMClass             byte
+	ProtocolID           byte
+	PriorityFlag         byte
+	ScheduleDeliveryTime string
+	ValidityPeriod       string
+	RegisteredDelivery   byte
+	ReplaceIfPresent     byte
+	DataCoding           byte
+	DefaultMsgID         byte
+	Message              []byte
+	// Options holds optional parameters by tag.
+	Options map[uint16][]byte
+}
+
+// ESM class bits.
+const (
+	// ESMClassUDHI marks a Message that starts with a user data header.
+	ESMClassUDHI byte = 0x40
+	// ESMClassReceipt marks a deliver_sm carrying a delivery receipt.
+	ESMClassReceipt byte = 0x04
+)
+
+func (sm ShortMessage) MarshalBinary() ([]byte, error) {
+	if len(sm.Message) > 254 {
+		return nil, fmt.Errorf("smpp: short message of %d bytes exceeds 254", len(sm.Message))
+	}
+	var w writer
+	w.cstring(sm.ServiceType)
+	w.byte(sm.SourceTON)
+	w.byte(sm.SourceNPI)
+	w.cstring(sm.Source)
+	w.byte(sm.DestTON)
+	w.byte(sm.DestNPI)
+	w.cstring(sm.Destination)
+	w.byte(sm.ESMClass)
+	w.byte(sm.ProtocolID)
+	w.byte(sm.PriorityFlag)
+	w.cstring(sm.ScheduleDeliveryTime)
+	w.cstring(sm.ValidityPeriod)
+	w.byte(sm.RegisteredDelivery)
+	w.byte(sm.ReplaceIfPresent)
+	w.byte(sm.DataCoding)
+	w.byte(sm.DefaultMsgID)
+	w.byte(byte(len(sm.Message)))
+	w.buf = append(w.buf, sm.Message...)
+	for tag, value := range sm.Options {
+		w.tlv(tag, value)
+	}
+	return w.buf, nil
+}
+
+func ParseShortMessage(body []byte) (ShortMessage, error) {
+	r := reader{buf: body}
+	sm := ShortMessage{
+		ServiceType: r.cstring(),
+		SourceTON:   r.byte(),
+		SourceNPI:   r.byte(),
+		Source:      r.cstring(),
+		DestTON:     r.byte(),
+		DestNPI:     r.byte(),
+	}
+	sm.Destination = r.cstring()
+	sm.ESMClass, sm.ProtocolID, sm.PriorityFlag = r.byte(), r.byte(), r.byte()
+	sm.ScheduleDeliveryTime, sm.ValidityPeriod = r.cstring(), r.cstring()
+	sm.RegisteredDelivery, sm.ReplaceIfPresent = r.byte(), r.byte()
+	sm.DataCoding, sm.DefaultMsgID = r.byte(), r.byte()
+	sm.Message = r.bytes(int(r.byte()))
+	sm.Options = r.tlvs()
+	return sm, r.err
+}
+
+// MessageIDBody encodes the body of a submit_sm_resp or deliver_sm_resp.
+func MessageIDBody(id string) []byte {
+	var w writer
+	w.cstring(id)
+	return w.buf
+}
+
+// ParseMessageID decodes the body of a submit_sm_resp, or the system_id of
+// a bind response.
+func ParseMessageID(body []byte) (string, error) {
+	r := reader{buf: body}
+	id := r.cstring()
+	return id, r.err
+}
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) cstring(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *writer) tlv(tag uint16, value []byte) {
+	w.buf = binary.BigEndian.AppendUint16(w.buf, tag)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(value)))
+	w.buf = append(w.buf, value...)
+}
+
+// reader decodes fields, recording the first error; reads after it return
+// zero values.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) == 0 {
+		r.err = errMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, b := range r.buf {
+		if b == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = errMalformed
+	return ""
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || len(r.buf) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := append([]byte(nil), r.buf[:n]...)
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) tlvs() map[uint16][]byte {
+	var options map[uint16][]byte
+	for r.err == nil && len(r.buf) > 0 {
+		if len(r.buf) < 4 {
+			r.err = errMalformed
+			return nil
+		}
+		tag := binary.BigEndian.Uint16(r.buf[0:2])
+		length := int(binary.BigEndian.Uint16(r.buf[2:4]))
+		r.buf = r.buf[4:]
+		value := r.bytes(length)
+		if options == nil {
+			options = make(map[uint16][]byte)
+		}
+		options[tag] = value
+	}
+	return options
+}
+
+// StatusError is a response with a non-zero command status.
+type StatusError struct {
+	CommandID uint32
+	Status    uint32
+}
+
+var statusNames = map[uint32]string{
+	StatusInvalidCmd:  "ESME_RINVCMDID",
+	StatusSysError:    "ESME_RSYSERR",
+	StatusInvalidDest: "ESME_RINVDSTADR",
+	StatusBindFailed:  "ESME_RBINDFAIL",
+	StatusInvalidPass: "ESME_RINVPASWD",
+	StatusInvalidSys:  "ESME_RINVSYSID",
+	StatusMsgQueueFul: "ESME_RMSGQFUL",
+	StatusSubmitFail:  "ESME_RSUBMITFAIL",
+	StatusThrottled:   "ESME_RTHROTTLED",
+}
+
+func (e *StatusError) Error() string {
+	name := statusNames[e.Status]
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("smpp: command 0x%08x failed with status 0x%08x (%s)", e.CommandID, e.Status, name)
+}
+
+// Temporary reports whether the SMSC asked to retry later.
+func (e *StatusError) Temporary() bool {
+	return e.Status == StatusThrottled || e.Status == StatusMsgQueueFul
+}
@@ -0,0 +1,92 @@
+package smpp
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Receipt is a delivery receipt sent by the SMSC in a deliver_sm.
+type Receipt struct {
+	// MessageID is the ID submit_sm_resp returned for the message.
+	MessageID string
+	// Source and Destination are those of the deliver_sm: the receipt
+	// comes from the recipient of the original message.
+	Source      string
+	Destination string
+	// State is the final state, e.g. DELIVRD, EXPIRED, UNDELIV or REJECTD.
+	State      string
+	Error      string
+	SubmitDate time.Time
+	DoneDate   time.Time
+	// Text is the start of the original message, if the SMSC includes it.
+	Text string
+}
+
+// Delivered reports whether the message reached the handset.
+func (r Receipt) Delivered() bool {
+	return r.State == "DELIVRD"
+}
+
+// messageStates maps the message_state parameter to receipt states.
+var messageStates = map[byte]string{
+	1: "ENROUTE", 2: "DELIVRD", 3: "EXPIRED", 4: "DELETED",
+	5: "UNDELIV", 6: "ACCEPTD", 7: "UNKNOWN", 8: "REJECTD",
+}
+
+var receiptField = regexp.MustCompile(`(?i)\b(id|sub|dlvrd|submit date|done date|stat|err|text):`)
+
+// ParseReceipt reads the receipt in sm, a deliver_sm with ESMClassReceipt
+// set. The receipted_message_id and message_state parameters take
+// precedence over the text, whose format ("id:... stat:DELIVRD ...") is
+// only a convention.
+func ParseReceipt(sm ShortMessage) Receipt {
+	receipt := Receipt{Source: sm.Source, Destination: sm.Destination}
+	// Receipt text is ASCII in practice, whatever the data coding says.
+	text := string(sm.Message)
+	if sm.DataCoding == CodingUCS2 {
+		text = DecodeText(sm.DataCoding, sm.Message)
+	}
+	matches := receiptField.FindAllStringSubmatchIndex(text, -1)
+	for i, match := range matches {
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		value := strings.TrimSpace(text[match[1]:end])
+		switch strings.ToLower(text[match[2]:match[3]]) {
+		case "id":
+			receipt.MessageID = value
+		case "stat":
+			receipt.State = strings.ToUpper(value)
+		case "err":
+			receipt.Error = value
+		case "submit date":
+			receipt.SubmitDate = parseReceiptTime(value)
+		case "done date":
+			receipt.DoneDate = parseReceiptTime(value)
+		case "text":
+			receipt.Text = value
+		}
+	}
+
+	if id, ok := sm.Options[TagReceiptedMessageID]; ok {
+		receipt.MessageID = strings.TrimRight(string(id), "\x00")
+	}
+	if state, ok := sm.Options[TagMessageState]; ok && len(state) == 1 {
+		if name, known := messageStates[state[0]]; known {
+			receipt.State = name
+		}
+	}
+	return receipt
+}
+
+// parseReceiptTime parses YYMMDDhhmm, optionally followed by ss.
+func parseReceiptTime(value string) time.Time {
+	for _, layout := range []string{"060102150405", "0601021504"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
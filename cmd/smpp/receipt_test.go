@@ -0,0 +1,58 @@
+package smpp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReceipt(t *testing.T) {
+	sm := ShortMessage{
+		Source:      "4915112345678",
+		Destination: "Acme",
+		ESMClass:    ESMClassReceipt,
+		Message:     []byte("id:0A1B2C sub:001 dlvrd:001 submit date:2406011200 done date:240601120130 stat:DELIVRD err:000 text:Your code is"),
+	}
+	got := ParseReceipt(sm)
+	want := Receipt{
+		MessageID:   "0A1B2C",
+		Source:      "4915112345678",
+		Destination: "Acme",
+		State:       "DELIVRD",
+		Error:       "000",
+		SubmitDate:  time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		DoneDate:    time.Date(2024, 6, 1, 12, 1, 30, 0, time.UTC),
+		Text:        "Your code is",
+	}
+	if got != want {
+		t.Errorf("ParseReceipt() = %+v, want %+v", got, want)
+	}
+	if !got.Delivered() {
+		t.Error("DELIVRD receipt is not delivered")
+	}
+}
+
+func TestParseReceiptOptionsTakePrecedence(t *testing.T) {
+	sm := ShortMessage{
+		ESMClass: ESMClassReceipt,
+		Message:  []byte("ID:short Stat:delivrd"),
+		Options: map[uint16][]byte{
+			TagReceiptedMessageID: []byte("full-id\x00"),
+			TagMessageState:       {5},
+		},
+	}
+	got := ParseReceipt(sm)
+	if got.MessageID != "full-id" || got.State != "UNDELIV" {
+		t.Errorf("ParseReceipt() = %q/%q, want full-id/UNDELIV", got.MessageID, got.State)
+	}
+}
+
+func TestParseReceiptUCS2(t *testing.T) {
+	_, parts, err := EncodeText("id:42 stat:EXPIRED err:000 text:✓")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ParseReceipt(ShortMessage{DataCoding: CodingUCS2, Message: parts[0]})
+	if got.MessageID != "42" || got.State != "EXPIRED" || got.Text != "✓" {
+		t.Errorf("ParseReceipt() = %q/%q/%q, want 42/EXPIRED/✓", got.MessageID, got.State, got.Text)
+	}
+}
@@ -0,0 +1,258 @@
+// Package smpptest provides an in-process stand-in for an SMSC, for use in
+// tests of code built on the smpp package.
+package smpptest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Zaman-R/otp-validator/cmd/smpp"
+)
+
+// Options configures a Server.
+type Options struct {
+	// SystemID and Password are required to bind; empty accepts any.
+	SystemID string
+	Password string
+	// ResponseDelay delays every submit_sm_resp. Submits are answered
+	// concurrently, so a client may keep several outstanding.
+	ResponseDelay time.Duration
+	// SubmitStatus, when set, picks the command status of each submit;
+	// non-zero statuses reject it.
+	SubmitStatus func(sm smpp.ShortMessage) uint32
+	// ReceiptState is the state reported in delivery receipts for submits
+	// with registered delivery; empty means DELIVRD.
+	ReceiptState string
+}
+
+// Server implements bind_transceiver, submit_sm, enquire_link and unbind,
+// and sends a deliver_sm receipt for each submit that asks for one.
+type Server struct {
+	Addr string
+
+	opts     Options
+	listener net.Listener
+	mu       sync.Mutex
+	submits  []smpp.ShortMessage
+	binds    int
+	enquires int
+	inFlight int
+	maxIn    int
+	open     map[net.Conn]struct{}
+	conns    sync.WaitGroup
+	closed   chan struct{}
+}
+
+// NewServer starts a server listening on a random loopback port.
+func NewServer(opts Options) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		opts:     opts,
+		listener: listener,
+		open:     make(map[net.Conn]struct{}),
+		closed:   make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Submits returns the short messages submitted so far.
+func (s *Server) Submits() []smpp.ShortMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smpp.ShortMessage(nil), s.submits...)
+}
+
+// Binds returns the number of successful binds.
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds
+}
+
+// EnquireLinks returns the number of enquire_link requests answered.
+func (s *Server) EnquireLinks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enquires
+}
+
+// MaxInFlight returns the most submits that were outstanding at once.
+func (s *Server) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxIn
+}
+
+// DropConnections closes every open connection without an unbind, as a
+// failing network would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.open {
+		conn.Close()
+	}
+}
+
+func (s *Server) Close() error {
+	close(s.closed)
+	err := s.listener.Close()
+	s.DropConnections()
+	s.conns.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.open[conn] = struct{}{}
+		s.mu.Unlock()
+		s.conns.Add(1)
+		go s.handle(conn)
+	}
+}
+
+type session struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	bound   bool
+	seq     uint32
+	pending sync.WaitGroup
+}
+
+func (sess *session) write(p smpp.PDU) {
+	b, _ := p.MarshalBinary()
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.conn.Write(b)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.conns.Done()
+	sess := &session{conn: conn}
+	defer func() {
+		conn.Close()
+		sess.pending.Wait()
+		s.mu.Lock()
+		delete(s.open, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		p, err := smpp.ReadPDU(conn)
+		if err != nil {
+			return
+		}
+		switch p.CommandID {
+		case smpp.BindTransceiver:
+			status := smpp.StatusOK
+			if bind, err := smpp.ParseBind(p.Body); err != nil {
+				status = smpp.StatusBindFailed
+			} else if s.opts.SystemID != "" && bind.SystemID != s.opts.SystemID {
+				status = smpp.StatusInvalidSys
+			} else if s.opts.Password != "" && bind.Password != s.opts.Password {
+				status = smpp.StatusInvalidPass
+			}
+			sess.bound = status == smpp.StatusOK
+			if sess.bound {
+				s.mu.Lock()
+				s.binds++
+				s.mu.Unlock()
+			}
+			sess.write(smpp.PDU{CommandID: smpp.BindTransceiverResp, Status: status, Sequence: p.Sequence, Body: smpp.MessageIDBody("smpptest")})
+		case smpp.SubmitSM:
+			sm, err := smpp.ParseShortMessage(p.Body)
+			if !sess.bound || err != nil {
+				sess.write(smpp.PDU{CommandID: smpp.SubmitSMResp, Status: smpp.StatusInvalidCmd, Sequence: p.Sequence})
+				continue
+			}
+			s.submit(sess, p, sm)
+		case smpp.EnquireLink:
+			s.mu.Lock()
+			s.enquires++
+			s.mu.Unlock()
+			sess.write(smpp.PDU{CommandID: smpp.EnquireLinkResp, Sequence: p.Sequence})
+		case smpp.Unbind:
+			sess.write(smpp.PDU{CommandID: smpp.UnbindResp, Sequence: p.Sequence})
+			return
+		case smpp.DeliverSMResp, smpp.EnquireLinkResp:
+		default:
+			sess.write(smpp.PDU{CommandID: smpp.GenericNack, Status: smpp.StatusInvalidCmd, Sequence: p.Sequence})
+		}
+	}
+}
+
+// submit answers a submit_sm after ResponseDelay and sends its receipt.
+func (s *Server) submit(sess *session, p smpp.PDU, sm smpp.ShortMessage) {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxIn = max(s.maxIn, s.inFlight)
+	s.mu.Unlock()
+
+	sess.pending.Add(1)
+	go func() {
+		defer sess.pending.Done()
+		select {
+		case <-time.After(s.opts.ResponseDelay):
+		case <-s.closed:
+		}
+
+		status := smpp.StatusOK
+		if s.opts.SubmitStatus != nil {
+			status = s.opts.SubmitStatus(sm)
+		}
+		var id string
+		s.mu.Lock()
+		s.inFlight--
+		if status == smpp.StatusOK {
+			s.submits = append(s.submits, sm)
+			id = fmt.Sprintf("%08X", len(s.submits))
+		}
+		s.mu.Unlock()
+
+		resp := smpp.PDU{CommandID: smpp.SubmitSMResp, Status: status, Sequence: p.Sequence}
+		if status == smpp.StatusOK {
+			resp.Body = smpp.MessageIDBody(id)
+		}
+		sess.write(resp)
+		if status == smpp.StatusOK && sm.RegisteredDelivery&0x01 != 0 {
+			sess.write(s.receipt(sess, sm, id))
+		}
+	}()
+}
+
+func (s *Server) receipt(sess *session, sm smpp.ShortMessage, id string) smpp.PDU {
+	state := s.opts.ReceiptState
+	if state == "" {
+		state = "DELIVRD"
+	}
+	now := time.Now().Format("0601021504")
+	text := fmt.Sprintf("id:%s sub:001 dlvrd:001 submit date:%s done date:%s stat:%s err:000 text:", id, now, now, state)
+	body, _ := smpp.ShortMessage{
+		SourceTON:   sm.DestTON,
+		SourceNPI:   sm.DestNPI,
+		Source:      sm.Destination,
+		DestTON:     sm.SourceTON,
+		DestNPI:     sm.SourceNPI,
+		Destination: sm.Source,
+		ESMClass:    smpp.ESMClassReceipt,
+		Message:     []byte(text),
+		Options:     map[uint16][]byte{smpp.TagReceiptedMessageID: append([]byte(id), 0)},
+	}.MarshalBinary()
+
+	sess.writeMu.Lock()
+	sess.seq++
+	seq := sess.seq
+	sess.writeMu.Unlock()
+	return smpp.PDU{CommandID: smpp.DeliverSM, Sequence: seq, Body: body}
+}
@@ -0,0 +1,170 @@
+package smpp
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Data coding values.
+const (
+	// CodingDefault is the SMSC default alphabet, GSM 03.38, sent one
+	// septet per octet.
+	CodingDefault byte = 0x00
+	// CodingUCS2 is UTF-16BE.
+	CodingUCS2 byte = 0x08
+)
+
+// Message size limits, in septets for GSM-7 and in octets for UCS-2.
+const (
+	maxGSMSingle  = 160
+	maxGSMPart    = 153
+	maxUCS2Single = 140
+	maxUCS2Part   = 134
+	// maxParts is the most parts a concatenated message can have.
+	maxParts = 255
+)
+
+const gsmEscape = 0x1B
+
+var errTooLong = errors.New("smpp: message needs more than 255 parts")
+
+// gsmBasic is the GSM 03.38 basic character set, indexed by septet. The
+// escape septet 0x1B is a placeholder.
+var gsmBasic = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// gsmExtension maps characters of the extension table to the septet sent
+// after the escape.
+var gsmExtension = map[rune]byte{
+	'\f': 0x0A, '^': 0x14, '{': 0x28, '}': 0x29, '\\': 0x2F,
+	'[': 0x3C, '~': 0x3D, ']': 0x3E, '|': 0x40, '€': 0x65,
+}
+
+var gsmIndex = func() map[rune]byte {
+	index := make(map[rune]byte, len(gsmBasic))
+	for i, r := range gsmBasic {
+		if i != gsmEscape {
+			index[r] = byte(i)
+		}
+	}
+	return index
+}()
+
+// encodeGSM returns text as unpacked GSM-7 septets, one slice per
+// character, or false if text has characters outside the alphabet.
+func encodeGSM(text string) ([][]byte, bool) {
+	chars := make([][]byte, 0, len(text))
+	for _, r := range text {
+		if septet, ok := gsmIndex[r]; ok {
+			chars = append(chars, []byte{septet})
+		} else if septet, ok := gsmExtension[r]; ok {
+			chars = append(chars, []byte{gsmEscape, septet})
+		} else {
+			return nil, false
+		}
+	}
+	return chars, true
+}
+
+// encodeUCS2 returns text as UTF-16BE, one slice per character; surrogate
+// pairs stay together.
+func encodeUCS2(text string) [][]byte {
+	chars := make([][]byte, 0, len(text))
+	for _, r := range text {
+		var units []uint16
+		if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+			units = []uint16{uint16(r1), uint16(r2)}
+		} else {
+			units = []uint16{uint16(r)}
+		}
+		char := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			char = append(char, byte(u>>8), byte(u))
+		}
+		chars = append(chars, char)
+	}
+	return chars
+}
+
+// EncodeText encodes text in GSM-7 if it fits the alphabet, or UCS-2
+// otherwise, and splits it into the parts of a concatenated message when it
+// is too long for one. Each part but a single one needs a UDH from
+// concatHeader.
+func EncodeText(text string) (coding byte, parts [][]byte, err error) {
+	chars, ok := encodeGSM(text)
+	single, part := maxGSMSingle, maxGSMPart
+	coding = CodingDefault
+	if !ok {
+		chars = encodeUCS2(text)
+		single, part = maxUCS2Single, maxUCS2Part
+		coding = CodingUCS2
+	}
+
+	var size int
+	for _, char := range chars {
+		size += len(char)
+	}
+	if size <= single {
+		return coding, [][]byte{join(chars)}, nil
+	}
+
+	var current []byte
+	for _, char := range chars {
+		if len(current)+len(char) > part {
+			parts = append(parts, current)
+			current = nil
+		}
+		current = append(current, char...)
+	}
+	parts = append(parts, current)
+	if len(parts) > maxParts {
+		return 0, nil, errTooLong
+	}
+	return coding, parts, nil
+}
+
+// DecodeText decodes a short message in the given coding. Unsupported
+// codings are returned as bytes.
+func DecodeText(coding byte, message []byte) string {
+	switch coding {
+	case CodingUCS2:
+		units := make([]uint16, len(message)/2)
+		for i := range units {
+			units[i] = uint16(message[2*i])<<8 | uint16(message[2*i+1])
+		}
+		return string(utf16.Decode(units))
+	case CodingDefault:
+		runes := make([]rune, 0, len(message))
+		for i := 0; i < len(message); i++ {
+			septet := message[i] & 0x7F
+			if septet == gsmEscape && i+1 < len(message) {
+				i++
+				for r, ext := range gsmExtension {
+					if ext == message[i] {
+						runes = append(runes, r)
+						break
+					}
+				}
+				continue
+			}
+			runes = append(runes, gsmBasic[septet])
+		}
+		return string(runes)
+	}
+	return string(message)
+}
+
+// concatHeader returns the user data header of part seq (1-based) of
+// total, with the 8-bit reference ref.
+func concatHeader(ref byte, total, seq int) []byte {
+	return []byte{0x05, 0x00, 0x03, ref, byte(total), byte(seq)}
+}
+
+func join(chars [][]byte) []byte {
+	var b []byte
+	for _, char := range chars {
+		b = append(b, char...)
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package smpp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		coding byte
+		sizes  []int
+	}{
+		{"GSM-7 single", strings.Repeat("a", 160), CodingDefault, []int{160}},
+		{"GSM-7 two parts", strings.Repeat("a", 161), CodingDefault, []int{153, 8}},
+		{"GSM-7 extension counts twice", strings.Repeat("€", 80), CodingDefault, []int{160}},
+		{"GSM-7 escape not split", strings.Repeat("a", 152) + "€" + strings.Repeat("a", 7), CodingDefault, []int{152, 9}},
+		{"UCS-2 single", strings.Repeat("ж", 70), CodingUCS2, []int{140}},
+		{"UCS-2 two parts", strings.Repeat("ж", 71), CodingUCS2, []int{134, 8}},
+		{"UCS-2 surrogate pair not split", strings.Repeat("ж", 66) + "😀" + strings.Repeat("ж", 3), CodingUCS2, []int{132, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coding, parts, err := EncodeText(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if coding != tt.coding {
+				t.Errorf("coding = %#x, want %#x", coding, tt.coding)
+			}
+			var sizes []int
+			for _, part := range parts {
+				sizes = append(sizes, len(part))
+			}
+			if !equalInts(sizes, tt.sizes) {
+				t.Errorf("part sizes = %v, want %v", sizes, tt.sizes)
+			}
+			if got := DecodeText(coding, bytes.Join(parts, nil)); got != tt.text {
+				t.Errorf("DecodeText() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestEncodeTextGSMAlphabet(t *testing.T) {
+	text := "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà^{}\\[~]|€\f"
+	coding, parts, err := EncodeText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coding != CodingDefault {
+		t.Fatalf("coding = %#x, want GSM-7", coding)
+	}
+	if got := DecodeText(coding, bytes.Join(parts, nil)); got != text {
+		t.Errorf("DecodeText() = %q, want %q", got, text)
+	}
+}
+
+func TestEncodeTextTooLong(t *testing.T) {
+	if _, _, err := EncodeText(strings.Repeat("a", 153*255+1)); err != errTooLong {
+		t.Errorf("EncodeText() error = %v, want errTooLong", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,6 @@ package totp
 
 import (
 	"github.com/Zaman-R/otp-validator/cmd/config"
-	"log"
 
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -15,7 +14,7 @@ func GenerateTOTPSecret(username string) (*otp.Key, error) {
 		SecretSize:  uint(config.ConfigTOTP.SecretSize),
 	})
 	if err != nil {
-		log.Println("❌ Error generating TOTP secret:", err)
+		config.Logger().Error("failed to generate TOTP secret", "error", err)
 		return nil, err
 	}
 	return key, nil
@@ -1,17 +1,16 @@
 package totp
 
 import (
-	"log"
-
+	"github.com/Zaman-R/otp-validator/cmd/utils"
 	"github.com/pquerna/otp/totp"
 )
 
 func ValidateTOTP(secret, code string) bool {
 	valid := totp.Validate(code, secret)
 	if !valid {
-		log.Println("❌ Invalid TOTP code")
+		utils.DefaultLogger().Debug("invalid TOTP code")
 		return false
 	}
-	log.Println("✅ TOTP code validated successfully")
+	utils.DefaultLogger().Debug("TOTP code validated")
 	return true
 }
@@ -1 +1,175 @@
 package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Redacted replaces the values of secret log attributes.
+const Redacted = "[REDACTED]"
+
+// secretKeys are attribute keys whose values never reach logs.
+var secretKeys = map[string]bool{
+	"otp": true, "code": true, "raw_otp": true, "hashed_otp": true,
+	"secret": true, "password": true, "pepper": true, "token": true,
+	"key": true, "payload": true, "message": true, "body": true,
+}
+
+// recipientKeys are attribute keys holding a mobile number or email, which
+// are logged masked.
+var recipientKeys = map[string]bool{
+	"recipient": true, "phone": true, "mobile": true, "mobile_number": true,
+	"email": true, "to": true,
+}
+
+// NewLogger returns a logger writing to w at level, as "json" or text
+// lines, that redacts secrets and masks recipients.
+func NewLogger(w io.Writer, level slog.Leveler, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(NewRedactingHandler(handler))
+}
+
+// DefaultLogger returns slog.Default with redaction, for components that
+// were not given a logger.
+func DefaultLogger() *slog.Logger {
+	return Redacting(slog.Default())
+}
+
+// Redacting returns logger with redaction added unless it already redacts.
+func Redacting(logger *slog.Logger) *slog.Logger {
+	if _, ok := logger.Handler().(*RedactingHandler); ok {
+		return logger
+	}
+	return slog.New(NewRedactingHandler(logger.Handler()))
+}
+
+// ParseLogLevel parses debug, info, warn or error; anything else is info.
+func ParseLogLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// RedactingHandler wraps a slog.Handler, replacing the values of attributes
+// whose keys name secrets (otp, code, token, password, ...) with Redacted
+// and masking those that name recipients (recipient, phone, email, ...).
+// Keys ending in _secret, _password, _token or _key are secrets too.
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	key := strings.ToLower(attr.Key)
+	switch {
+	case attr.Value.Kind() == slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	case isSecretKey(key):
+		return slog.String(attr.Key, Redacted)
+	case recipientKeys[key]:
+		if attr.Value.Kind() != slog.KindString {
+			return slog.String(attr.Key, Redacted)
+		}
+		return slog.String(attr.Key, MaskRecipient(attr.Value.String()))
+	}
+	return attr
+}
+
+func isSecretKey(key string) bool {
+	if secretKeys[key] {
+		return true
+	}
+	for _, suffix := range []string{"_secret", "_password", "_token", "_key"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskRecipient hides most of a mobile number or email: the last two
+// digits of a number and the first character and domain of an email stay
+// visible.
+func MaskRecipient(recipient string) string {
+	if recipient == "" {
+		return ""
+	}
+	if local, domain, ok := strings.Cut(recipient, "@"); ok {
+		if local == "" {
+			return "*@" + domain
+		}
+		runes := []rune(local)
+		return string(runes[0]) + strings.Repeat("*", max(len(runes)-1, 1)) + "@" + domain
+	}
+	runes := []rune(recipient)
+	visible := 2
+	if len(runes) <= visible {
+		return strings.Repeat("*", len(runes))
+	}
+	masked := make([]rune, len(runes))
+	for i, r := range runes {
+		switch {
+		case i >= len(runes)-visible, i == 0 && r == '+':
+			masked[i] = r
+		default:
+			masked[i] = '*'
+		}
+	}
+	return string(masked)
+}
+
+// Printf adapts a logger to interfaces expecting Printf, logging each
+// formatted line at level.
+type Printf struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (p Printf) Printf(format string, args ...interface{}) {
+	p.Logger.Log(context.Background(), p.Level, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
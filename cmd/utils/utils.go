@@ -1,19 +1,24 @@
 package utils
 
 import (
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
-	"math/rand"
+	"math/big"
 	"strings"
 	"time"
 )
 
 var OTPJwtSecretKey = []byte("your-secret-key")
 
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = jwt.ErrTokenExpired
+)
+
 type OTPPayload struct {
 	OTPref string    `json:"otp_ref"`
 	Iat    time.Time `json:"iat"`
@@ -57,23 +62,13 @@ func ValidateToken(tokenStr string) (map[string]interface{}, error) {
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		return claims, nil
 	}
-	return nil, errors.New("invalid token")
-}
-
-func HashOTPWithTime(otp string, createdAt, expiresAt time.Time) string {
-	data := otp + createdAt.String() + expiresAt.String()
-	hash := sha256.Sum256([]byte(data))
-	return base64.StdEncoding.EncodeToString(hash[:])
-}
-
-func ValidateOTP(otp, providedHash string, createdAt, expiresAt time.Time) bool {
-	return HashOTPWithTime(otp, createdAt, expiresAt) == providedHash
+	return nil, ErrInvalidToken
 }
 
 func EncodeBase64(payload map[string]interface{}) (string, error) {
@@ -109,21 +104,15 @@ func ReplacePlaceholder(template *string, otpCode string) string {
 	return strings.ReplaceAll(*template, "<otp>", otpCode)
 }
 
+// GenerateSecureOTP returns a random 6-digit numeric code.
+//
+// Deprecated: use otp.CodeGenerator, which supports other lengths and alphabets.
 func GenerateSecureOTP() (string, error) {
-	b := make([]byte, 4)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", err
-	}
-	return base64.StdEncoding.EncodeToString(b)[:6], nil
-}
-
-func HashOTP(otp string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(otp), bcrypt.DefaultCost)
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
 	if err != nil {
 		return "", err
 	}
-	return string(hashed), nil
+	return fmt.Sprintf("%06d", n.Int64()), nil
 }
 
 func DetermineDeliveryMethod(email, phone string) string {
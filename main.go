@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/Zaman-R/otp-validator/cmd/client"
+	"github.com/Zaman-R/otp-validator/cmd/redis"
 	"github.com/Zaman-R/otp-validator/cmd/repository"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/Zaman-R/otp-validator/cmd/config"
+	"github.com/Zaman-R/otp-validator/cmd/metrics"
 	"github.com/Zaman-R/otp-validator/cmd/otp"
+	"github.com/Zaman-R/otp-validator/cmd/smpp"
 )
 
 //✅ Database initializes properly
@@ -17,35 +26,394 @@ import (
 func main() {
 	// Load configurations
 	config.LoadConfig()
-	config.ConnectDB()
-	db := config.GetDB()
+	logger := config.Logger()
+	slog.SetDefault(logger)
 
 	// Create OTP repository
-	otpRepo := repository.NewOTPRepository(db.GetDB())
+	var otpRepo otp.OTPStore
+	switch config.AppConfig.OTPStore {
+	case "redis":
+		otpRepo = repository.NewRedisOTPRepository(redis.NewClient(redis.Options{
+			Addr:     config.AppConfig.RedisAddr,
+			Password: config.AppConfig.RedisPassword,
+			DB:       config.AppConfig.RedisDB,
+		}), config.AppConfig.RedisPrefix)
+	case "memory":
+		otpRepo = repository.NewMemoryOTPRepository(time.Hour)
+	default:
+		config.ConnectDB()
+		otpRepo = repository.NewOTPRepository(config.GetDB().GetDB())
+	}
 
 	// Initialize providers (Clients can implement their own)
-	smsProvider := client.NewCustomSMSProvider()
-	emailProvider := client.NewCustomEmailProvider()
+	smsProvider, err := newSMSProvider(config.ConfigOTP.SMS, logger)
+	if err != nil {
+		fatal(logger, "failed to configure SMS provider", err)
+	}
+	if smppProvider, ok := smsProvider.(*client.SMPPProvider); ok {
+		defer smppProvider.Close(context.Background())
+	}
+	emailProvider, err := newEmailProvider(config.ConfigOTP.SMTP, logger)
+	if err != nil {
+		fatal(logger, "failed to configure email provider", err)
+	}
+
+	// Hash codes with the configured algorithm
+	hasher, err := otp.NewHasher(config.ConfigOTP.HashAlgorithm, []byte(config.ConfigOTP.HashPepper), config.ConfigOTP.HashPepperID)
+	if err != nil {
+		fatal(logger, "failed to configure OTP hasher", err)
+	}
+	previousHashers, err := otp.ParsePreviousHashers(config.ConfigOTP.HashPrevious)
+	if err != nil {
+		fatal(logger, "failed to configure OTP hasher", err)
+	}
+
+	// Generate codes from the configured alphabet and length bounds
+	alphabet := otp.ResolveAlphabet(config.ConfigOTP.CodeAlphabet)
+	generator, err := otp.NewCodeGenerator(alphabet)
+	if err != nil {
+		fatal(logger, "failed to configure OTP generator", err)
+	}
+	if err := otp.CheckGrouping(alphabet, config.ConfigOTP.CodeGroupSep); err != nil {
+		fatal(logger, "failed to configure OTP generator", err)
+	}
+	if err := otp.CheckLengthBounds(config.ConfigOTP.MinLength, config.ConfigOTP.MaxLength); err != nil {
+		fatal(logger, "failed to configure OTP generator", err)
+	}
+
+	// Rate limit sends and validations per recipient and client
+	limiter, err := newRateLimiter(config.ConfigOTP.RateLimit)
+	if err != nil {
+		fatal(logger, "failed to configure rate limits", err)
+	}
+
+	// Register the purposes OTPs may be issued for
+	purposes, err := newPurposeRegistry(config.ConfigOTP.PurposesFile)
+	if err != nil {
+		fatal(logger, "failed to load OTP purposes", err)
+	}
+	if err := purposes.CheckGrouping(config.ConfigOTP.CodeGroupSep); err != nil {
+		fatal(logger, "failed to load OTP purposes", err)
+	}
+
+	// Render messages from named templates when a template store is configured
+	templates, err := newTemplateRegistry(config.ConfigOTP.TemplateStore, config.ConfigOTP.TemplatesFile)
+	if err != nil {
+		fatal(logger, "failed to load message templates", err)
+	}
+
+	// Encrypt transaction payloads when a keyring is configured
+	keyring, err := otp.NewKeyringFromConfig(config.ConfigOTP.PayloadKeyring, config.ConfigOTP.PayloadPrimaryKey, config.ConfigOTP.PayloadKeys)
+	if err != nil {
+		fatal(logger, "failed to load payload keyring", err)
+	}
+
+	// Route deliveries of purposes without their own routing
+	routing, err := otp.ParseRoutingPolicy(config.ConfigOTP.Routing)
+	if err != nil {
+		fatal(logger, "failed to parse OTP routing", err)
+	}
+
+	// Record OTP lifecycle events when an audit sink is configured
+	auditSink, err := newAuditSink(config.ConfigOTP.AuditSink, config.ConfigOTP.AuditFile)
+	if err != nil {
+		fatal(logger, "failed to open audit log", err)
+	}
+	var auditLog *otp.AuditLog
+	if auditSink != nil {
+		auditLog = otp.NewAuditLog(auditSink)
+	}
+
+	// Expose Prometheus metrics when an address is configured
+	registry := metrics.NewRegistry()
+	otpMetrics := metrics.NewOTPMetrics(registry)
+	if addr := config.ConfigOTP.MetricsAddr; addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		go func() {
+			logger.Info("serving metrics", "addr", addr, "path", "/metrics")
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				logger.Error("metrics server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Queue deliveries in the transactional outbox when enabled
+	var outbox otp.OutboxStore
+	if config.ConfigOTP.Outbox.Enabled {
+		var ok bool
+		if outbox, ok = otpRepo.(otp.OutboxStore); !ok {
+			fatal(logger, "failed to configure OTP outbox", fmt.Errorf("the %s store has no outbox", config.AppConfig.OTPStore))
+		}
+		if keyring == nil {
+			fatal(logger, "failed to configure OTP outbox", fmt.Errorf("the outbox requires a payload keyring"))
+		}
+	}
 
 	// Initialize OTP Service
-	otpService := otp.NewOTPService(otpRepo, smsProvider, emailProvider)
+	otpService := otp.NewOTPService(otpRepo, smsProvider, emailProvider,
+		otp.WithHasher(hasher, previousHashers...),
+		otp.WithCodeGenerator(generator),
+		otp.WithLengthBounds(config.ConfigOTP.MinLength, config.ConfigOTP.MaxLength),
+		otp.WithCodeGrouping(config.ConfigOTP.CodeGroupSize, config.ConfigOTP.CodeGroupSep),
+		otp.WithResendPolicy(time.Duration(config.ConfigOTP.ResendCooldown)*time.Second, config.ConfigOTP.MaxResends),
+		otp.WithRateLimiter(limiter),
+		otp.WithPurposes(purposes),
+		otp.WithTemplates(templates),
+		otp.WithAppName(config.ConfigOTP.AppName),
+		otp.WithRouting(routing),
+		otp.WithPayloadKeyring(keyring),
+		otp.WithPseudonymKey([]byte(config.ConfigOTP.PseudonymKey)),
+		otp.WithAuditLog(auditLog),
+		otp.WithMetrics(otpMetrics),
+		otp.WithOutbox(outbox),
+		otp.WithLogger(logger),
+	)
+
+	// Expire overdue OTPs and purge old ones in the background
+	if sweeper, err := newSweeper(otpRepo, otpService, config.ConfigOTP.Sweep); err != nil {
+		fatal(logger, "failed to configure OTP sweeper", err)
+	} else if sweeper != nil {
+		sweeper.Start()
+		defer sweeper.Stop(context.Background())
+	}
+
+	// Deliver queued OTPs in the background
+	if outbox != nil {
+		cfg := config.ConfigOTP.Outbox
+		worker := otp.NewOutboxWorker(otpService, outbox, otp.OutboxConfig{
+			Workers:     cfg.Workers,
+			BatchSize:   cfg.BatchSize,
+			MaxAttempts: cfg.MaxAttempts,
+			BaseBackoff: time.Duration(cfg.BackoffSeconds) * time.Second,
+			MaxBackoff:  time.Duration(cfg.MaxBackoffSeconds) * time.Second,
+		})
+		worker.Start()
+		defer worker.Stop(context.Background())
+	}
 
 	// Example: Sending an OTP
-	otpRef, err := otpService.SendOTP(otp.SendOTPRequest{
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := otpService.Send(ctx, otp.SendOTPRequest{
+		Purpose:      "login",
 		MobileNumber: strPtr("+123456789"),
+		SMSBody:      strPtr("Your login code is <otp>"),
 		Length:       6,
 		RetryLimit:   3,
-		Expiration:   300, // 5 minutes
+		Expiration:   5 * time.Minute,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to send OTP: %v", err)
+		fatal(logger, "failed to send OTP", err)
 	}
 
-	fmt.Println("OTP Sent! Reference:", otpRef)
+	logger.Info("OTP sent", "otp_ref", result.OTPRef, "channels", result.Delivered(), "queued", result.Queued)
+}
+
+// fatal logs msg with err and exits.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 // Helper function to get a string pointer
 func strPtr(s string) *string {
 	return &s
 }
+
+func newRateLimiter(cfg config.RateLimitConfig) (*otp.RateLimiter, error) {
+	var defaults otp.RateLimits
+	var err error
+	if defaults.SendPerRecipient, err = otp.ParseLimit(cfg.SendPerRecipient); err != nil {
+		return nil, err
+	}
+	if defaults.SendPerClient, err = otp.ParseLimit(cfg.SendPerClient); err != nil {
+		return nil, err
+	}
+	if defaults.ValidatePerClient, err = otp.ParseLimit(cfg.ValidatePerClient); err != nil {
+		return nil, err
+	}
+	purposes, err := otp.ParseRateLimitOverrides(defaults, cfg.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	var store otp.RateLimitStore
+	switch cfg.Store {
+	case "sql":
+		config.ConnectDB()
+		store = repository.NewRateLimitRepository(config.GetDB().GetDB())
+	default:
+		memory := repository.NewMemoryRateLimitStore()
+		memory.StartEviction(time.Minute)
+		store = memory
+	}
+
+	limiter := otp.NewRateLimiter(store, defaults)
+	for purpose, limits := range purposes {
+		limiter.SetPurposeLimits(purpose, limits)
+	}
+	return limiter, nil
+}
+
+// newSMSProvider returns the SMS provider selected by cfg.Provider.
+func newSMSProvider(cfg config.SMSConfig, logger *slog.Logger) (client.SMSProvider, error) {
+	switch cfg.Provider {
+	case "":
+		return &client.CustomSMSProvider{Logger: logger}, nil
+	case "http":
+		maxRetries := cfg.MaxRetries
+		if maxRetries == 0 {
+			maxRetries = -1
+		}
+		return client.NewHTTPSMSProvider(client.HTTPSMSConfig{
+			BaseURL:             cfg.BaseURL,
+			AccountSID:          cfg.AccountSID,
+			AuthToken:           cfg.AuthToken,
+			From:                cfg.From,
+			MessagingServiceSID: cfg.MessagingServiceSID,
+			StatusCallback:      cfg.StatusCallback,
+			Timeout:             time.Duration(cfg.TimeoutSeconds) * time.Second,
+			MaxRetries:          maxRetries,
+			Logger:              logger,
+		})
+	case "smpp":
+		options := smpp.Options{
+			Addr:                cfg.SMPP.Addr,
+			SystemID:            cfg.SMPP.SystemID,
+			Password:            cfg.SMPP.Password,
+			SystemType:          cfg.SMPP.SystemType,
+			WindowSize:          cfg.SMPP.WindowSize,
+			EnquireLinkInterval: time.Duration(cfg.SMPP.EnquireLinkSeconds) * time.Second,
+			Logger:              logger,
+			OnReceipt: func(receipt smpp.Receipt) {
+				logger.Info("SMS delivery receipt", "provider", "smpp", "message_id", receipt.MessageID,
+					"state", receipt.State, "recipient", receipt.Source)
+			},
+		}
+		if cfg.SMPP.TLS {
+			host, _, _ := net.SplitHostPort(cfg.SMPP.Addr)
+			options.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		return client.NewSMPPProvider(client.SMPPConfig{
+			Options:            options,
+			Source:             cfg.SMPP.Source,
+			RegisteredDelivery: cfg.SMPP.RegisteredDelivery,
+		})
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", cfg.Provider)
+	}
+}
+
+// newEmailProvider returns an SMTP provider when an SMTP host is configured,
+// or the placeholder provider otherwise.
+func newEmailProvider(cfg config.SMTPConfig, logger *slog.Logger) (client.EmailProvider, error) {
+	if cfg.Host == "" {
+		return &client.CustomEmailProvider{Logger: logger}, nil
+	}
+	return client.NewSMTPProvider(client.SMTPConfig{
+		Host:          cfg.Host,
+		Port:          cfg.Port,
+		Security:      client.SMTPSecurity(cfg.Security),
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		AuthMechanism: cfg.AuthMechanism,
+		From:          cfg.From,
+		ReplyTo:       cfg.ReplyTo,
+		Subject:       cfg.Subject,
+		Timeout:       time.Duration(cfg.TimeoutSeconds) * time.Second,
+		Logger:        logger,
+	})
+}
+
+// newPurposeRegistry loads purpose policies from path, or uses the built-in
+// purposes when path is empty.
+func newPurposeRegistry(path string) (*otp.PurposeRegistry, error) {
+	if path == "" {
+		return otp.NewPurposeRegistry(otp.DefaultPurposePolicies()...)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	policies, err := otp.LoadPurposePolicies(file)
+	if err != nil {
+		return nil, err
+	}
+	return otp.NewPurposeRegistry(policies...)
+}
+
+// newTemplateRegistry loads message templates from the store named kind,
+// or returns nil when kind is empty.
+func newTemplateRegistry(kind, path string) (*otp.TemplateRegistry, error) {
+	var templates []otp.MessageTemplate
+	switch kind {
+	case "":
+		return nil, nil
+	case "sql":
+		config.ConnectDB()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var err error
+		if templates, err = repository.NewTemplateRepository(config.GetDB().GetDB()).LoadTemplates(ctx); err != nil {
+			return nil, err
+		}
+	case "file":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if templates, err = otp.LoadMessageTemplates(file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown template store %q", kind)
+	}
+	return otp.NewTemplateRegistry(templates...)
+}
+
+// newSweeper returns a sweeper for store that reports expiries through
+// service, or nil when sweeping is disabled or store does not need it.
+func newSweeper(store otp.OTPStore, service *otp.OTPService, cfg config.SweepConfig) (*otp.Sweeper, error) {
+	maintenance, ok := store.(otp.MaintenanceStore)
+	if !cfg.Enabled || !ok {
+		return nil, nil
+	}
+	retention, err := otp.ParseRetention(cfg.Retention)
+	if err != nil {
+		return nil, err
+	}
+	sweeperConfig := otp.SweeperConfig{
+		Interval:  time.Duration(cfg.IntervalSeconds) * time.Second,
+		BatchSize: cfg.BatchSize,
+		Retention: retention,
+		Service:   service,
+	}
+	if cfg.Archive {
+		if _, sql := store.(*repository.OTPRepository); !sql {
+			return nil, fmt.Errorf("archiving requires the sql store")
+		}
+		sweeperConfig.Archiver = repository.NewOTPArchiveRepository(config.GetDB().GetDB())
+	}
+	return otp.NewSweeper(maintenance, sweeperConfig), nil
+}
+
+// newAuditSink opens the audit sink named kind, or returns nil when kind is
+// empty.
+func newAuditSink(kind, path string) (otp.AuditSink, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "sql":
+		config.ConnectDB()
+		return repository.NewAuditRepository(config.GetDB().GetDB()), nil
+	case "jsonl":
+		return repository.NewJSONLAuditSink(path)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", kind)
+	}
+}